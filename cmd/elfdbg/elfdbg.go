@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/DomBlack/advent-of-code-2018/lib/elf_code"
+)
+
+const helpText = `Commands:
+  step [n]           Execute the next n instructions (default 1)
+  continue           Run until a breakpoint, watchpoint or the program halts
+  break <ip>         Stop when the instruction pointer reaches ip
+  delete <ip>        Remove the breakpoint on ip
+  watch r<n> [value] Stop when register n changes (or is set to value)
+  unwatch <id>       Remove a watchpoint
+  regs               Print the registers
+  set r<n> <value>   Set register n to value
+  disasm [from [to]] Print the program listing
+  flow [ip]          Print the registers live at, and the values reaching, ip (default the next instruction)
+  decompile          Print the program as structured pseudo code
+  trace on|off       Print every instruction as it is executed
+  reset              Reload the program and clear the registers, keeping breakpoints and watchpoints
+  help               Show this message
+  quit               Exit the debugger`
+
+func main() {
+	lintOnly := flag.Bool("lint", false, "check the program for problems instead of debugging it")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: elfdbg [-lint] <program file>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	filename := flag.Arg(0)
+	source, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *lintOnly {
+		failed, err := lint(filename, string(source), os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	s, err := newSession(strings.TrimSpace(string(source)), os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s.run(os.Stdin, true)
+}
+
+// Prints the problems found in the program as `file:line: severity: message`,
+// returning true if any are warnings or errors
+func lint(filename string, source string, out io.Writer) (failed bool, err error) {
+	cpu, assembly, err := load(source)
+	if err != nil {
+		return false, err
+	}
+
+	for _, diagnostic := range cpu.Lint() {
+		fmt.Fprintf(out, "%s:%d: %s: %s\n", filename, assembly.Lines[diagnostic.IP], diagnostic.Severity, diagnostic.Message)
+		failed = failed || diagnostic.Severity >= elf_code.SeverityWarning
+	}
+
+	return
+}
+
+// Assembles the program file into a CPU, which both linting and debugging use so they accept the same files
+func load(source string) (cpu *elf_code.CPU, assembly *elf_code.Assembly, err error) {
+	assembly, err = elf_code.Assemble(source)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cpu, err = elf_code.NewCPUWithConfig(assembly.Program, assembly.IPRegister, assembly.Config)
+	return
+}
+
+// A debugging session of a single program
+type session struct {
+	source   string             // The program file, so we can reset
+	debugger *elf_code.Debugger // The debugger controlling the CPU
+	out      io.Writer          // Where to write output to
+	trace    bool               // Print every instruction executed?
+}
+
+// Creates a new session for the given program file
+func newSession(source string, out io.Writer) (s *session, err error) {
+	s = &session{source: source, out: out}
+	err = s.reset()
+	return
+}
+
+// Reloads the program into a fresh CPU, keeping the breakpoints and watchpoints
+func (s *session) reset() error {
+	cpu, _, err := load(s.source)
+	if err != nil {
+		return err
+	}
+
+	if s.debugger == nil {
+		s.debugger = elf_code.NewDebugger(cpu)
+		return nil
+	}
+
+	// Reuse the debugger so the watchpoints keep their IDs
+	s.debugger.CPU = cpu
+	s.debugger.InstructionsExecuted = 0
+	s.debugger.LastWatchpoint = nil
+
+	return nil
+}
+
+// Reads commands from `in` until it is exhausted or the user quits
+func (s *session) run(in io.Reader, prompt bool) {
+	scanner := bufio.NewScanner(in)
+
+	for {
+		if prompt {
+			fmt.Fprintf(s.out, "(elfdbg ip=%d) ", s.debugger.IP())
+		}
+
+		if !scanner.Scan() {
+			return
+		}
+
+		quit, err := s.execute(scanner.Text())
+		if err != nil {
+			fmt.Fprintln(s.out, "error:", err)
+		}
+
+		if quit {
+			return
+		}
+	}
+}
+
+// Executes a single command line, returning true if the session should end
+func (s *session) execute(line string) (quit bool, err error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return
+	}
+
+	args := fields[1:]
+	switch fields[0] {
+	case "step", "s":
+		count := 1
+		if len(args) > 0 {
+			count, err = strconv.Atoi(args[0])
+			if err != nil {
+				return
+			}
+		}
+		err = s.step(count)
+
+	case "continue", "c":
+		err = s.resume()
+
+	case "break", "b":
+		var ip int
+		ip, err = s.parseArg(args, 0)
+		if err != nil {
+			return
+		}
+		s.debugger.SetBreakpoint(ip)
+		fmt.Fprintf(s.out, "breakpoint set at %d\n", ip)
+
+	case "delete", "d":
+		var ip int
+		ip, err = s.parseArg(args, 0)
+		if err != nil {
+			return
+		}
+		if !s.debugger.ClearBreakpoint(ip) {
+			err = fmt.Errorf("no breakpoint at %d", ip)
+		}
+
+	case "watch", "w":
+		err = s.watch(args)
+
+	case "unwatch":
+		var id int
+		id, err = s.parseArg(args, 0)
+		if err != nil {
+			return
+		}
+		if !s.debugger.Unwatch(id) {
+			err = fmt.Errorf("no watchpoint %d", id)
+		}
+
+	case "regs", "r":
+		s.printRegisters()
+
+	case "set":
+		err = s.set(args)
+
+	case "disasm":
+		err = s.disassemble(args)
+
+	case "flow":
+		err = s.flow(args)
+
+	case "decompile":
+		err = s.decompile()
+
+	case "trace":
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return false, errors.New("usage: trace on|off")
+		}
+		s.trace = args[0] == "on"
+
+	case "reset":
+		err = s.reset()
+
+	case "help", "h", "?":
+		fmt.Fprintln(s.out, helpText)
+
+	case "quit", "q", "exit":
+		return true, nil
+
+	default:
+		err = fmt.Errorf("unknown command %q, try help", fields[0])
+	}
+
+	return
+}
+
+// Executes `count` instructions, stopping early on a breakpoint, watchpoint or halt
+func (s *session) step(count int) error {
+	for i := 0; i < count; i++ {
+		reason, err := s.singleStep()
+		if err != nil {
+			return err
+		}
+
+		if reason != elf_code.StepComplete || i == count-1 {
+			s.report(reason)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Runs until the debugger stops for something other than a completed step
+func (s *session) resume() error {
+	if !s.trace {
+		reason, err := s.debugger.Continue()
+		if err != nil {
+			return err
+		}
+		s.report(reason)
+		return nil
+	}
+
+	// Step manually so every instruction can be traced
+	for {
+		reason, err := s.singleStep()
+		if err != nil {
+			return err
+		}
+
+		if reason != elf_code.StepComplete {
+			s.report(reason)
+			return nil
+		}
+	}
+}
+
+// Executes a single instruction, tracing it if required
+func (s *session) singleStep() (elf_code.StopReason, error) {
+	cpu := s.debugger.CPU
+	if s.trace && !cpu.Halted() {
+		ip := s.debugger.IP()
+		fmt.Fprintf(s.out, "%4d: %-16s %s\n", ip, cpu.Program[ip], cpu.Registers)
+	}
+
+	return s.debugger.Step()
+}
+
+// Prints why the debugger stopped and where
+func (s *session) report(reason elf_code.StopReason) {
+	switch reason {
+	case elf_code.ProgramHalted:
+		fmt.Fprintf(s.out, "%s after %d instructions\n", reason, s.debugger.InstructionsExecuted)
+	case elf_code.WatchpointHit:
+		watchpoint := s.debugger.LastWatchpoint
+		fmt.Fprintf(s.out, "%s: watchpoint %d on r%d\n", reason, watchpoint.ID, watchpoint.Register)
+		s.printCurrentInstruction()
+	default:
+		if reason == elf_code.BreakpointHit {
+			fmt.Fprintln(s.out, reason)
+		}
+		s.printCurrentInstruction()
+	}
+
+	s.printRegisters()
+}
+
+func (s *session) printCurrentInstruction() {
+	cpu := s.debugger.CPU
+	if !cpu.Halted() {
+		ip := s.debugger.IP()
+		fmt.Fprintf(s.out, "next %d: %s\n", ip, cpu.Program[ip])
+	}
+}
+
+func (s *session) printRegisters() {
+	fmt.Fprintln(s.out, "registers", s.debugger.CPU.Registers)
+}
+
+// watch r<n> [value]
+func (s *session) watch(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: watch r<n> [value]")
+	}
+
+	register, err := s.parseRegister(args[0])
+	if err != nil {
+		return err
+	}
+
+	condition := elf_code.RegisterChanged
+	if len(args) == 2 {
+		value, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		condition = elf_code.RegisterEquals(value)
+	}
+
+	watchpoint, err := s.debugger.Watch(register, condition)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(s.out, "watchpoint %d on r%d\n", watchpoint.ID, register)
+	return nil
+}
+
+// set r<n> <value>
+func (s *session) set(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: set r<n> <value>")
+	}
+
+	register, err := s.parseRegister(args[0])
+	if err != nil {
+		return err
+	}
+
+	value, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+
+	err = s.debugger.CPU.Registers.Set(register, value)
+	if err != nil {
+		return err
+	}
+
+	s.printRegisters()
+	return nil
+}
+
+// disasm [from [to]]
+func (s *session) disassemble(args []string) (err error) {
+	program := s.debugger.CPU.Program
+	from, to := 0, len(program)-1
+
+	if len(args) > 0 {
+		if from, err = strconv.Atoi(args[0]); err != nil {
+			return
+		}
+	}
+
+	if len(args) > 1 {
+		if to, err = strconv.Atoi(args[1]); err != nil {
+			return
+		}
+	}
+
+	disassembly := program.Disassemble(s.debugger.CPU.InstructionPointerRegister)
+
+	breakpoints := make(map[int]bool)
+	for _, ip := range s.debugger.Breakpoints() {
+		breakpoints[ip] = true
+	}
+
+	fmt.Fprintf(s.out, "#ip %d\n", s.debugger.CPU.InstructionPointerRegister)
+	for ip := from; ip <= to && ip < len(program); ip++ {
+		if ip < 0 {
+			continue
+		}
+
+		marker := "  "
+		if breakpoints[ip] {
+			marker = "* "
+		}
+		if ip == s.debugger.IP() {
+			marker = marker[:1] + ">"
+		}
+
+		fmt.Fprintf(s.out, "%s%4d: %-14s ; %s\n", marker, ip, program[ip], disassembly.Lines[ip].Comment)
+	}
+
+	return
+}
+
+// flow [ip]
+func (s *session) flow(args []string) (err error) {
+	ip := s.debugger.IP()
+	if len(args) > 0 {
+		if ip, err = strconv.Atoi(args[0]); err != nil {
+			return
+		}
+	}
+
+	program := s.debugger.CPU.Program
+	if ip < 0 || ip >= len(program) {
+		return fmt.Errorf("instruction %d out of range", ip)
+	}
+
+	flow, err := s.debugger.CPU.DataFlow()
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(s.out, "%d: %s\n", ip, program[ip])
+	if !flow.Reachable(ip) {
+		fmt.Fprintln(s.out, "  unreachable")
+	}
+	fmt.Fprintln(s.out, "  live before:", registerNames(flow.LiveIn(ip)))
+	fmt.Fprintln(s.out, "  live after:", registerNames(flow.LiveOut(ip)))
+
+	// Where the registers read by the instruction could have been set
+	isImmediate := elf_code.OpCodeInputType[program[ip].OpCode]
+	for _, operand := range []struct {
+		register    int
+		isImmediate bool
+	}{{program[ip].A, isImmediate.A}, {program[ip].B, isImmediate.B}} {
+		if operand.isImmediate || operand.register == s.debugger.CPU.InstructionPointerRegister {
+			continue
+		}
+
+		definitions := make([]string, 0)
+		for _, definition := range flow.ReachingDefinitions(ip, operand.register) {
+			if definition == elf_code.EntryDefinition {
+				definitions = append(definitions, "entry")
+			} else {
+				definitions = append(definitions, strconv.Itoa(definition))
+			}
+		}
+
+		value := "unknown"
+		if constant, isConstant := flow.ConstantBefore(ip, operand.register); isConstant {
+			value = strconv.Itoa(constant)
+		}
+		fmt.Fprintf(s.out, "  r%d = %s, set at %s\n", operand.register, value, strings.Join(definitions, ", "))
+	}
+
+	if flow.IsDeadWrite(ip) {
+		fmt.Fprintf(s.out, "  r%d is never read\n", program[ip].C)
+	}
+
+	return
+}
+
+// decompile
+func (s *session) decompile() error {
+	decompiled, err := s.debugger.CPU.Decompile()
+	if err != nil {
+		return err
+	}
+
+	source, err := decompiled.Write(elf_code.PseudoCodeBackend{})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(s.out, source)
+	return nil
+}
+
+// Formats registers as `r1 r2`, or `none`
+func registerNames(registers []int) string {
+	if len(registers) == 0 {
+		return "none"
+	}
+
+	names := make([]string, len(registers))
+	for i, register := range registers {
+		names[i] = fmt.Sprintf("r%d", register)
+	}
+
+	return strings.Join(names, " ")
+}
+
+// Parses a register name such as `r3`
+func (s *session) parseRegister(str string) (register int, err error) {
+	if !strings.HasPrefix(str, "r") {
+		return 0, fmt.Errorf("invalid register %q, expected r<n>", str)
+	}
+
+	register, err = strconv.Atoi(str[1:])
+	if err != nil {
+		return 0, fmt.Errorf("invalid register %q, expected r<n>", str)
+	}
+
+	if register < 0 || register >= len(s.debugger.CPU.Registers) {
+		return 0, fmt.Errorf("register %q out of range", str)
+	}
+
+	return
+}
+
+// Parses the integer argument at `index`
+func (s *session) parseArg(args []string, index int) (int, error) {
+	if index >= len(args) {
+		return 0, errors.New("missing argument")
+	}
+
+	return strconv.Atoi(args[index])
+}
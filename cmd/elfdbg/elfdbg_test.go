@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testProgram = `#ip 0
+seti 5 0 1
+seti 6 0 2
+addi 0 1 0
+addr 1 2 3
+setr 1 0 0
+seti 8 0 4
+seti 9 0 5`
+
+func Test_session(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands string
+		want     []string
+	}{
+		{"Step", "step 2\nregs", []string{"next 2: addi 0 1 0", "registers [2, 5, 6, 0, 0, 0]"}},
+		{"Breakpoint", "break 4\ncontinue", []string{"breakpoint set at 4", "breakpoint hit", "next 4: setr 1 0 0"}},
+		{"Watchpoint", "watch r5 9\nc", []string{"watchpoint 1 on r5", "watchpoint hit: watchpoint 1 on r5"}},
+		{"Halt", "continue", []string{"program halted after 5 instructions", "registers [7, 5, 6, 0, 0, 9]"}},
+		{"Set", "set r0 3\nstep", []string{"registers [3, 0, 0, 0, 0, 0]", "next 4: setr 1 0 0"}},
+		{"Trace", "trace on\nstep 2", []string{"   0: seti 5 0 1       [0, 0, 0, 0, 0, 0]", "   1: seti 6 0 2       [1, 5, 0, 0, 0, 0]"}},
+		{"Disasm", "break 3\nstep\ndisasm 0 3", []string{" >   1: seti 6 0 2", "*    3: addr 1 2 3     ; r3 = r1 + r2"}},
+		{"Flow", "flow 3", []string{"3: addr 1 2 3\n  unreachable"}},
+		{"Flow constant jump", "flow 4", []string{"4: setr 1 0 0", "  live after: r1 r2 r3 r4\n", "  r1 = 5, set at 0"}},
+		{"Decompile computed jump", "decompile", []string{"R[1] = 5 // IP: 0 (seti 5 0 1)", "R[5] = 9 // IP: 6 (seti 9 0 5)\nend"}},
+		{"Reset", "step 3\nreset\nregs", []string{"registers [0, 0, 0, 0, 0, 0]"}},
+		{"Reset keeps watchpoints", "watch r5 9\nc\nreset\nc", []string{"9]\nwatchpoint hit: watchpoint 1 on r5"}},
+		{"Errors", "set r9 1\nfoo", []string{`error: register "r9" out of range`, `error: unknown command "foo", try help`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			s, err := newSession(testProgram, &out)
+			if err != nil {
+				t.Fatalf("newSession() error = %v", err)
+			}
+
+			s.run(strings.NewReader(tt.commands), false)
+
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("session output = %q, want it to contain %q", out.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func Test_session_assembly(t *testing.T) {
+	// The session loads programs the same way as linting, so the assembler's syntax can be debugged too
+	const source = "#ip 5\n.reg count 1\n\n; Count to two\nstart: addi count 1 count\naddi count 1 count"
+
+	var out strings.Builder
+	s, err := newSession(source, &out)
+	if err != nil {
+		t.Fatalf("newSession() error = %v", err)
+	}
+
+	s.run(strings.NewReader("continue"), false)
+
+	if want := "registers [0, 2, 0, 0, 0, 2]"; !strings.Contains(out.String(), want) {
+		t.Errorf("session output = %q, want it to contain %q", out.String(), want)
+	}
+}
+
+func Test_lint(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     string
+		want       string
+		wantFailed bool
+	}{
+		{"Clean", "#ip 5\nseti 1 0 0\naddi 0 2 0", "", false},
+		{"Info only", "#ip 5\nseti 1 2 0", "test.elf:2: info: input B of seti is ignored, but is 2\n", false},
+		{"Problems", "#ip 5\n\n; Jump over the next line\nseti 1 0 5\naddi 0 1 0\nseti 1 0 0",
+			"test.elf:5: warning: unreachable instruction\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			failed, err := lint("test.elf", tt.source, &out)
+			if err != nil {
+				t.Fatalf("lint() error = %v", err)
+			}
+
+			if out.String() != tt.want || failed != tt.wantFailed {
+				t.Errorf("lint() = %q, %v, want %q, %v", out.String(), failed, tt.want, tt.wantFailed)
+			}
+		})
+	}
+}
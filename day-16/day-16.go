@@ -5,7 +5,6 @@ import (
 	"github.com/DomBlack/advent-of-code-2018/lib/elf_code"
 	"github.com/DomBlack/advent-of-code-2018/lib"
 	"log"
-	"reflect"
 	"strings"
 )
 
@@ -30,49 +29,25 @@ func part1(samples []Sample) (count int) {
 
 // Work out the number of each OpCode and then execute the sample program
 func part2(samples []Sample, program elf_code.Program) int {
-	// Create a map of all possibilities - OpCode => Possible Numbers
-	possibleNumbers := make(map[elf_code.OpCode]map[int]bool, elf_code.NumOpCodes)
-	knownNumbers := make(map[int]elf_code.OpCode)
-	for opCode := elf_code.OpCode(0); opCode < elf_code.NumOpCodes; opCode++ {
-		possibleNumbers[opCode] = make(map[int]bool)
+	opCodeSamples := make([]elf_code.OpCodeSample, len(samples))
+	for i, sample := range samples {
+		opCodeSamples[i] = sample.opCodeSample()
 	}
 
-	// Loop over every sample and set all initial possibilities
-	for _, sample := range samples {
-		instructionNumber := int(sample.instruction.OpCode) // The OpCode number in the sample
-		matching := sample.MatchingOpCodes()                // The OpCodes which match the result of the sample
-
-		for _, opCode := range matching {
-			possibleNumbers[elf_code.OpCode(opCode)][instructionNumber] = true
-		}
-	}
-
-	// Minimise the matches until there are no unknown ones
-	for len(knownNumbers) < int(elf_code.NumOpCodes) {
-		for opCode, numbers := range possibleNumbers {
-			if len(numbers) == 1 {
-
-				// Remove this number from all others
-				for o := elf_code.OpCode(0); o < elf_code.NumOpCodes; o++ {
-					if o != opCode {
-						for number := range numbers {
-							knownNumbers[number] = opCode
-							delete(possibleNumbers[o], number)
-						}
-					}
-				}
-			}
-		}
+	mapping, err := elf_code.SolveOpCodes(opCodeSamples)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// Now map the program from the original op codes to my op codes (defined by my enum)
-	for index := range program {
-		program[index].OpCode = knownNumbers[int(program[index].OpCode)]
+	program, err = mapping.Remap(program)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// Create a CPU and run it with the given program
 	cpu := elf_code.NewCPU(program, 4, 5)
-	err := cpu.Execute()
+	err = cpu.Execute()
 
 	if err != nil {
 		log.Fatal(err)
@@ -136,45 +111,14 @@ func parseSample(before, instruction, after string) (res Sample) {
 	return
 }
 
-// Tests if the given OpCode
-func (s Sample) TestOpCode(opcode elf_code.OpCode) bool {
-	f, found := elf_code.OpCodeFunc[opcode]
-
-	if !found {
-		log.Println("Unknown op code for TestOpCode: ", opcode)
-		return false
-	}
-
-	registers := s.before.Copy()
-
-	// Execute the op code and get the value of the result
-	cValue, err := f(s.instruction.A, s.instruction.B, registers)
-	if err != nil {
-		return false
-	}
-
-	// Set the register as required
-	err = registers.Set(s.instruction.C, cValue)
-	if err != nil {
-		return false
-	}
-
-	// Are the registers the same?
-	return reflect.DeepEqual(registers, s.after)
+// The sample for the op code solver
+func (s Sample) opCodeSample() elf_code.OpCodeSample {
+	return elf_code.OpCodeSample{Before: s.before, Instruction: s.instruction, After: s.after}
 }
 
 // Finds all matching OpCodes
 func (s Sample) MatchingOpCodes() (matching []elf_code.OpCode) {
-	matching = make([]elf_code.OpCode, 0)
-
-	// Test every op code
-	for opCode := elf_code.OpCode(0); opCode < elf_code.NumOpCodes; opCode++ {
-		if s.TestOpCode(opCode) {
-			matching = append(matching, opCode)
-		}
-	}
-
-	return
+	return s.opCodeSample().MatchingOpCodes()
 }
 
 func (s Sample) String() string {
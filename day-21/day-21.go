@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/DomBlack/advent-of-code-2018/lib"
+	"github.com/DomBlack/advent-of-code-2018/lib/elf_code"
+)
+
+func main() {
+	input := lib.InputAsString("day-21")
+
+	values := haltingValues(input)
+	fmt.Println("Part 1", values.First())
+	fmt.Println("Part 2", values.Last())
+}
+
+// The program only halts when R[0] matches the value it is compared against, so
+// find all the values it's compared against before it starts repeating itself
+func haltingValues(input string) *elf_code.HaltingValues {
+	cpu, err := elf_code.NewCPUFromProgramFile(input)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	comparisons := cpu.Program.EqualityComparisonsReading(0)
+	if len(comparisons) != 1 {
+		log.Fatalf("expected one comparison reading R[0], found %v", comparisons)
+	}
+
+	values, err := cpu.FindHaltingValues(comparisons[0], 0, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return values
+}
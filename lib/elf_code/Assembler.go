@@ -0,0 +1,305 @@
+package elf_code
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An error found while assembling, with the source line it was on
+type AssemblyError struct {
+	Line int   // The line number, starting at 1
+	Err  error // What was wrong with the line
+}
+
+func (e *AssemblyError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *AssemblyError) Unwrap() error {
+	return e.Err
+}
+
+// A program assembled from source, along with the names it defined
+type Assembly struct {
+	Program    Program
+	IPRegister int
+	Labels     map[string]int // Label => instruction pointer of the instruction after it
+	Registers  map[string]int // `.reg` alias => register number
+	Constants  map[string]int // `.const` name => value
+	Lines      []int          // Instruction pointer => source line number
+	Config     CPUConfig      // Set by the `#regs` and `#word` directives
+}
+
+// A line of source which still needs its operands resolving
+type assemblyLine struct {
+	line   int
+	fields []string
+}
+
+// Assembles a program from source. On top of the `#ip N` and `op A B C` lines `NewCPUFromProgramFile`
+// reads, the source can contain:
+//
+//   - `; comments`, either on their own or at the end of a line
+//   - `label:` before an instruction (or on its own line), which can be used as a value anywhere
+//   - `.reg name N` to give register N a name, which can be used as a register operand or in `#ip`
+//   - `.const name value` to name a value, which can use labels and earlier constants
+//   - Values of the form `name+1` or `label-1`
+//   - `#regs N` and `#word N [signed|unsigned]` to set up the CPU, as `NewCPUFromProgramFile` reads
+//
+// The instruction pointer is incremented after every instruction, so jumping to a label is `seti label-1 0 ip`
+func Assemble(source string) (res *Assembly, err error) {
+	res = &Assembly{
+		IPRegister: -1,
+		Labels:     make(map[string]int),
+		Registers:  make(map[string]int),
+		Constants:  make(map[string]int),
+	}
+
+	var constants, instructions, registers []assemblyLine
+	ipLine := assemblyLine{}
+	names := make(map[string]bool)
+
+	// First find all the names, so labels can be used before they are defined
+	scanner := bufio.NewScanner(strings.NewReader(source))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		text := scanner.Text()
+		if comment := strings.IndexRune(text, ';'); comment >= 0 {
+			text = text[:comment]
+		}
+		fields := strings.Fields(text)
+
+		for len(fields) > 0 && strings.HasSuffix(fields[0], ":") {
+			label := strings.TrimSuffix(fields[0], ":")
+			if err = defineName(names, label); err != nil {
+				return nil, &AssemblyError{lineNum, err}
+			}
+			res.Labels[label] = len(instructions)
+			fields = fields[1:]
+		}
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "#ip", ".ip":
+			if ipLine.line != 0 {
+				return nil, &AssemblyError{lineNum, fmt.Errorf("instruction pointer already bound on line %d", ipLine.line)}
+			}
+			if len(fields) != 2 {
+				return nil, &AssemblyError{lineNum, errors.New("#ip expects a register")}
+			}
+			ipLine = assemblyLine{lineNum, fields}
+
+		case ".reg":
+			if len(fields) != 3 {
+				return nil, &AssemblyError{lineNum, errors.New(".reg expects a name and a register")}
+			}
+			register, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, &AssemblyError{lineNum, fmt.Errorf("invalid register %q", fields[2])}
+			}
+			if err = defineName(names, fields[1]); err != nil {
+				return nil, &AssemblyError{lineNum, err}
+			}
+			res.Registers[fields[1]] = register
+			registers = append(registers, assemblyLine{lineNum, fields})
+
+		case "#regs", ".regs", "#word", ".word":
+			if _, err = res.Config.parseDirective(fields); err != nil {
+				return nil, &AssemblyError{lineNum, err}
+			}
+
+		case ".const":
+			if len(fields) != 3 {
+				return nil, &AssemblyError{lineNum, errors.New(".const expects a name and a value")}
+			}
+			if err = defineName(names, fields[1]); err != nil {
+				return nil, &AssemblyError{lineNum, err}
+			}
+			constants = append(constants, assemblyLine{lineNum, fields})
+
+		default:
+			if strings.HasPrefix(fields[0], ".") || strings.HasPrefix(fields[0], "#") {
+				return nil, &AssemblyError{lineNum, fmt.Errorf("unknown directive %q", fields[0])}
+			}
+			instructions = append(instructions, assemblyLine{lineNum, fields})
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Only now the number of registers is known can the aliases be checked
+	for _, line := range registers {
+		if err = res.checkRegister(res.Registers[line.fields[1]]); err != nil {
+			return nil, &AssemblyError{line.line, err}
+		}
+	}
+
+	if ipLine.line == 0 {
+		return nil, errors.New("no #ip directive found")
+	}
+	if res.IPRegister, err = res.register(ipLine.fields[1]); err != nil {
+		return nil, &AssemblyError{ipLine.line, err}
+	}
+
+	// Constants can only use the constants before them, which stops them referring to each other
+	for _, line := range constants {
+		value, err := res.value(line.fields[2])
+		if err != nil {
+			return nil, &AssemblyError{line.line, err}
+		}
+		res.Constants[line.fields[1]] = value
+	}
+
+	res.Program = make(Program, len(instructions))
+	res.Lines = make([]int, len(instructions))
+	for ip, line := range instructions {
+		if res.Program[ip], err = res.instruction(line.fields); err != nil {
+			return nil, &AssemblyError{line.line, err}
+		}
+		res.Lines[ip] = line.line
+	}
+
+	return res, nil
+}
+
+// Assembles the source and loads it into a new CPU
+func NewCPUFromAssembly(source string) (res *CPU, err error) {
+	assembly, err := Assemble(source)
+	if err != nil {
+		return
+	}
+
+	return NewCPUWithConfig(assembly.Program, assembly.IPRegister, assembly.Config)
+}
+
+// Checks the name is valid and hasn't been used for anything else yet
+func defineName(names map[string]bool, name string) error {
+	if name == "" {
+		return errors.New("missing name")
+	}
+
+	for i, r := range name {
+		if r != '_' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && (i == 0 || r < '0' || r > '9') {
+			return fmt.Errorf("invalid name %q", name)
+		}
+	}
+
+	if names[name] {
+		return fmt.Errorf("%q is already defined", name)
+	}
+
+	names[name] = true
+	return nil
+}
+
+// Parses an `op A B C` instruction
+func (a *Assembly) instruction(fields []string) (res Instruction, err error) {
+	if res.OpCode, err = ParseOpCode(fields[0]); err != nil {
+		return
+	}
+
+	if len(fields) != 4 {
+		return res, fmt.Errorf("%s expects 3 operands, got %d", res.OpCode, len(fields)-1)
+	}
+
+	isImmediate := OpCodeInputType[res.OpCode]
+	if res.A, err = a.operand(fields[1], isImmediate.A); err != nil {
+		return
+	}
+	if res.B, err = a.operand(fields[2], isImmediate.B); err != nil {
+		return
+	}
+	res.C, err = a.register(fields[3])
+
+	return
+}
+
+func (a *Assembly) operand(str string, isImmediate bool) (int, error) {
+	if isImmediate {
+		return a.value(str)
+	}
+
+	return a.register(str)
+}
+
+// Parses a register number or alias
+func (a *Assembly) register(str string) (register int, err error) {
+	if register, found := a.Registers[str]; found {
+		return register, nil
+	}
+
+	if register, err = strconv.Atoi(str); err != nil {
+		if _, found := a.Constants[str]; found {
+			return 0, fmt.Errorf("%q is a constant, not a register", str)
+		}
+		if _, found := a.Labels[str]; found {
+			return 0, fmt.Errorf("%q is a label, not a register", str)
+		}
+		return 0, fmt.Errorf("unknown register %q", str)
+	}
+
+	return register, a.checkRegister(register)
+}
+
+// Parses a value made of numbers, constants and labels added or subtracted together
+func (a *Assembly) value(str string) (value int, err error) {
+	sign := 1
+	for str != "" {
+		end := strings.IndexAny(str[1:], "+-") + 1
+		if end == 0 {
+			end = len(str)
+		}
+
+		term := str[:end]
+		switch term[0] {
+		case '+':
+			sign, term = 1, term[1:]
+		case '-':
+			sign, term = -1, term[1:]
+		}
+
+		termValue, err := a.term(term)
+		if err != nil {
+			return 0, err
+		}
+
+		value += sign * termValue
+		str = str[end:]
+	}
+
+	return value, nil
+}
+
+func (a *Assembly) term(str string) (int, error) {
+	if value, found := a.Constants[str]; found {
+		return value, nil
+	}
+	if value, found := a.Labels[str]; found {
+		return value, nil
+	}
+	if _, found := a.Registers[str]; found {
+		return 0, fmt.Errorf("%q is a register, not a value", str)
+	}
+
+	value, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("unknown value %q", str)
+	}
+
+	return value, nil
+}
+
+func (a *Assembly) checkRegister(register int) error {
+	if register < 0 || register >= a.Config.numRegisters() {
+		return fmt.Errorf("register %d out of range", register)
+	}
+
+	return nil
+}
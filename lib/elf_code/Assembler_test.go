@@ -0,0 +1,145 @@
+package elf_code
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The same program as loopTestProgram
+const loopTestAssembly = `; Counts R[0] up to the limit
+.reg count 0
+.reg limit 1
+.reg done  2
+.reg ip    3
+.const max 5
+
+#ip ip
+
+        seti 0 0 count
+        seti max 0 limit
+loop:   addi count 1 count      ; count++
+        eqrr count limit done
+        addr done ip ip         ; skip the jump if done
+        seti loop-1 0 ip        ; the IP is incremented after the jump
+end:
+`
+
+func TestAssemble(t *testing.T) {
+	assembly, err := Assemble(loopTestAssembly)
+	if err != nil {
+		t.Fatalf("Assemble() error = %v", err)
+	}
+
+	want, err := NewCPUFromProgramFile(loopTestProgram)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(assembly.Program, want.Program) {
+		t.Errorf("Assemble() program = %v, want %v", assembly.Program, want.Program)
+	}
+
+	if assembly.IPRegister != 3 {
+		t.Errorf("Assemble() ip register = %v, want %v", assembly.IPRegister, 3)
+	}
+
+	if wantLabels := map[string]int{"loop": 2, "end": 6}; !reflect.DeepEqual(assembly.Labels, wantLabels) {
+		t.Errorf("Assemble() labels = %v, want %v", assembly.Labels, wantLabels)
+	}
+
+	if wantLines := []int{10, 11, 12, 13, 14, 15}; !reflect.DeepEqual(assembly.Lines, wantLines) {
+		t.Errorf("Assemble() lines = %v, want %v", assembly.Lines, wantLines)
+	}
+
+	// Plain program files assemble to the same program
+	plain, err := Assemble(day19Program)
+	if err != nil {
+		t.Fatalf("Assemble(day19Program) error = %v", err)
+	}
+
+	day19, _ := NewCPUFromProgramFile(day19Program)
+	if !reflect.DeepEqual(plain.Program, day19.Program) {
+		t.Errorf("Assemble(day19Program) = %v, want %v", plain.Program, day19.Program)
+	}
+}
+
+func TestNewCPUFromAssembly(t *testing.T) {
+	cpu, err := NewCPUFromAssembly(loopTestAssembly)
+	if err != nil {
+		t.Fatalf("NewCPUFromAssembly() error = %v", err)
+	}
+
+	if err := cpu.Execute(); err != nil {
+		t.Fatalf("CPU.Execute() error = %v", err)
+	}
+
+	if want := (Registers{5, 5, 1, 5, 0, 0}); !reflect.DeepEqual(cpu.Registers, want) {
+		t.Errorf("CPU.Execute() = %v, want %v", cpu.Registers, want)
+	}
+}
+
+func TestAssemble_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		wantLine int
+		wantErr  string
+	}{
+		{"Missing #ip", "seti 1 0 0", 0, "no #ip directive found"},
+		{"Second #ip", "#ip 0\n\n#ip 1", 3, "instruction pointer already bound on line 1"},
+		{"Unknown op code", "#ip 0\nseti 1 0 0\nmodr 1 2 3", 3, "unknown op code: modr"},
+		{"Too few operands", "#ip 0\n; comment\naddi 1 2", 3, "addi expects 3 operands, got 2"},
+		{"Unknown directive", "#ip 0\n.register a 1", 2, `unknown directive ".register"`},
+		{"Register out of range", "#ip 0\naddr 1 6 2", 2, "register 6 out of range"},
+		{"Alias out of range", "#ip 0\n.reg a 7", 2, "register 7 out of range"},
+		{"Unknown label", "#ip 0\nseti missing 0 0", 2, `unknown value "missing"`},
+		{"Label as register", "#ip 0\nstart: addr start 1 2", 2, `"start" is a label, not a register`},
+		{"Register as value", "#ip 0\n.reg a 1\naddi 1 a 2", 3, `"a" is a register, not a value`},
+		{"Constant as register", "#ip 0\n.const a 1\naddr a 1 2", 3, `"a" is a constant, not a register`},
+		{"Duplicate name", "#ip 0\na: seti 1 0 0\n.const a 2", 3, `"a" is already defined`},
+		{"Invalid name", "#ip 0\n1a: seti 1 0 0", 2, `invalid name "1a"`},
+		{"Constant used before defined", "#ip 0\n.const a b\n.const b 1", 2, `unknown value "b"`},
+		{"Unknown #ip register", "#ip pc\nseti 1 0 0", 1, `unknown register "pc"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(tt.source)
+			if err == nil {
+				t.Fatalf("Assemble() error = nil, want %v", tt.wantErr)
+			}
+
+			var assemblyErr *AssemblyError
+			gotLine := 0
+			if errors.As(err, &assemblyErr) {
+				gotLine = assemblyErr.Line
+			}
+
+			if gotLine != tt.wantLine || !strings.HasSuffix(err.Error(), tt.wantErr) {
+				t.Errorf("Assemble() error = %v on line %d, want %v on line %d", err, gotLine, tt.wantErr, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestAssemble_Values(t *testing.T) {
+	source := `#ip 5
+.const base 10
+.const offset base-3+end
+a:  seti offset 0 0
+    seti -4 0 1
+    seti a+2-1 0 2
+end:`
+
+	assembly, err := Assemble(source)
+	if err != nil {
+		t.Fatalf("Assemble() error = %v", err)
+	}
+
+	// offset = 10 - 3 + 3
+	want := Program{{SetI, 10, 0, 0}, {SetI, -4, 0, 1}, {SetI, 1, 0, 2}}
+	if !reflect.DeepEqual(assembly.Program, want) {
+		t.Errorf("Assemble() = %v, want %v", assembly.Program, want)
+	}
+}
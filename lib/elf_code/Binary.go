@@ -0,0 +1,273 @@
+package elf_code
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The version of the binary formats written by `Program.MarshalBinary` and `CPU.MarshalBinary`. Version 2 added
+// the word size to snapshots and version 3 the names of registered op codes, older versions can still be read
+const BinaryVersion = 3
+
+// The magic bytes at the start of each binary format
+var (
+	programMagic  = []byte("ELFP")
+	snapshotMagic = []byte("ELFC")
+)
+
+var (
+	ErrBinaryFormat    = errors.New("not an elf code binary")
+	ErrBinaryVersion   = errors.New("unsupported binary version")
+	ErrBinaryTruncated = errors.New("binary data is truncated")
+)
+
+// Encodes the program as a header of "ELFP" and the version byte, followed by the number and name of each registered
+// op code the program uses, then the number of instructions and each instruction's op code, A, B and C as varints
+func (p Program) MarshalBinary() (data []byte, err error) {
+	data = append(data, programMagic...)
+	data = append(data, BinaryVersion)
+
+	return p.appendBinary(data), nil
+}
+
+// Decodes a program written by `Program.MarshalBinary`
+func (p *Program) UnmarshalBinary(data []byte) error {
+	r := &binaryReader{data: data}
+	r.header(programMagic)
+
+	program := r.program()
+	if err := r.finish(); err != nil {
+		return err
+	}
+
+	*p = program
+	return nil
+}
+
+// Encodes a snapshot of the CPU with a header of "ELFC" and the version byte, followed by the instruction
+// pointer register, the registers, the word size (bits then 1 if unsigned) and then the program (without
+// its header). The tracer and host aren't included
+func (cpu *CPU) MarshalBinary() (data []byte, err error) {
+	data = append(data, snapshotMagic...)
+	data = append(data, BinaryVersion)
+
+	data = binary.AppendUvarint(data, uint64(cpu.InstructionPointerRegister))
+	data = binary.AppendUvarint(data, uint64(len(cpu.Registers)))
+	for _, value := range cpu.Registers {
+		data = binary.AppendVarint(data, int64(value))
+	}
+
+	unsigned := uint64(0)
+	if cpu.Word.Unsigned {
+		unsigned = 1
+	}
+	data = binary.AppendUvarint(data, uint64(cpu.Word.Bits))
+	data = binary.AppendUvarint(data, unsigned)
+
+	return cpu.Program.appendBinary(data), nil
+}
+
+// Restores a snapshot written by `CPU.MarshalBinary`, so execution can carry on from where it was taken
+func (cpu *CPU) UnmarshalBinary(data []byte) error {
+	r := &binaryReader{data: data}
+	r.header(snapshotMagic)
+
+	ipRegister := r.uvarint()
+	registers := NewRegisters(r.count(1))
+	for i := range registers {
+		registers[i] = r.varint()
+	}
+
+	var word WordSize
+	if r.version >= 2 {
+		word.Bits = r.uvarint()
+		word.Unsigned = r.uvarint() == 1
+	}
+
+	program := r.program()
+
+	if err := r.finish(); err != nil {
+		return err
+	}
+
+	if ipRegister >= len(registers) {
+		return fmt.Errorf("instruction pointer register %d out of range", ipRegister)
+	}
+
+	if err := word.validate(); err != nil {
+		return err
+	}
+
+	cpu.Registers = registers
+	cpu.Program = program
+	cpu.InstructionPointerRegister = ipRegister
+	cpu.Word = word
+	return nil
+}
+
+// Creates a new CPU from a snapshot written by `CPU.MarshalBinary`
+func NewCPUFromSnapshot(data []byte) (res *CPU, err error) {
+	res = &CPU{}
+	if err = res.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (p Program) appendBinary(data []byte) []byte {
+	// Registered op codes are numbered in the order they were registered, so are stored with their names
+	var used []OpCode
+	for _, opCode := range RegisteredOpCodes() {
+		for _, instruction := range p {
+			if instruction.OpCode == opCode {
+				used = append(used, opCode)
+				break
+			}
+		}
+	}
+
+	data = binary.AppendUvarint(data, uint64(len(used)))
+	for _, opCode := range used {
+		name := registeredOpCodes[opCode].Name
+		data = binary.AppendUvarint(data, uint64(opCode))
+		data = binary.AppendUvarint(data, uint64(len(name)))
+		data = append(data, name...)
+	}
+
+	data = binary.AppendUvarint(data, uint64(len(p)))
+	for _, instruction := range p {
+		data = binary.AppendUvarint(data, uint64(instruction.OpCode))
+		data = binary.AppendVarint(data, int64(instruction.A))
+		data = binary.AppendVarint(data, int64(instruction.B))
+		data = binary.AppendVarint(data, int64(instruction.C))
+	}
+
+	return data
+}
+
+// Reads values from binary data, after the first error all reads return zero
+type binaryReader struct {
+	data    []byte
+	err     error
+	version byte // The version from the header
+}
+
+func (r *binaryReader) header(magic []byte) {
+	if len(r.data) < len(magic)+1 || string(r.data[:len(magic)]) != string(magic) {
+		r.err = ErrBinaryFormat
+		return
+	}
+
+	if r.version = r.data[len(magic)]; r.version < 1 || r.version > BinaryVersion {
+		r.err = fmt.Errorf("%w: %d", ErrBinaryVersion, r.version)
+		return
+	}
+
+	r.data = r.data[len(magic)+1:]
+}
+
+func (r *binaryReader) varint() int {
+	if r.err != nil {
+		return 0
+	}
+
+	value, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = ErrBinaryTruncated
+		return 0
+	}
+
+	r.data = r.data[n:]
+	return int(value)
+}
+
+func (r *binaryReader) uvarint() int {
+	if r.err != nil {
+		return 0
+	}
+
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = ErrBinaryTruncated
+		return 0
+	}
+	if value > math.MaxInt32 {
+		r.err = fmt.Errorf("value %d out of range", value)
+		return 0
+	}
+
+	r.data = r.data[n:]
+	return int(value)
+}
+
+// Reads the number of items which follow, each at least `minSize` bytes long, so corrupt
+// data can't make us allocate more than the data could hold
+func (r *binaryReader) count(minSize int) int {
+	count := r.uvarint()
+	if count > len(r.data)/minSize {
+		r.err = ErrBinaryTruncated
+		return 0
+	}
+
+	return count
+}
+
+func (r *binaryReader) string() string {
+	length := r.count(1)
+	if r.err != nil {
+		return ""
+	}
+
+	str := string(r.data[:length])
+	r.data = r.data[length:]
+	return str
+}
+
+func (r *binaryReader) program() Program {
+	// The stored number of each registered op code => the number it has been registered as now
+	registered := make(map[OpCode]OpCode)
+	if r.version >= 3 {
+		for i := r.count(2); i > 0; i-- {
+			stored, name := OpCode(r.uvarint()), r.string()
+			if opCode, found := registeredOpCodeNames[name]; found {
+				registered[stored] = opCode
+			} else if r.err == nil {
+				r.err = fmt.Errorf("unknown op code %q", name)
+			}
+		}
+	}
+
+	program := make(Program, r.count(4))
+	for ip := range program {
+		opCode := OpCode(r.uvarint())
+		if r.version >= 3 && opCode >= NumOpCodes {
+			if current, found := registered[opCode]; found {
+				opCode = current
+			} else if r.err == nil {
+				r.err = fmt.Errorf("unknown op code %d at %d", opCode, ip)
+			}
+		} else if _, found := OpCodeInputType[opCode]; !found && r.err == nil {
+			r.err = fmt.Errorf("unknown op code %d at %d", opCode, ip)
+		}
+
+		program[ip] = Instruction{opCode, r.varint(), r.varint(), r.varint()}
+	}
+
+	return program
+}
+
+// Checks all the data was read without errors
+func (r *binaryReader) finish() error {
+	if r.err != nil {
+		return r.err
+	}
+
+	if len(r.data) > 0 {
+		return fmt.Errorf("%d unexpected bytes after the end of the data", len(r.data))
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package elf_code
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProgram_MarshalBinary(t *testing.T) {
+	for _, source := range []string{debuggerTestProgram, loopTestProgram, day19Program, day21Program} {
+		cpu, err := NewCPUFromProgramFile(source)
+		if err != nil {
+			t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+		}
+
+		data, err := cpu.Program.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Program.MarshalBinary() error = %v", err)
+		}
+
+		var got Program
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Program.UnmarshalBinary() error = %v", err)
+		}
+
+		if !reflect.DeepEqual(got, cpu.Program) {
+			t.Errorf("Program.UnmarshalBinary() = %v, want %v", got, cpu.Program)
+		}
+
+		// The decoded program should print as the original source did
+		lines := strings.Split(source, "\n")[1:]
+		for ip, instruction := range got {
+			if instruction.String() != lines[ip] {
+				t.Errorf("Program.UnmarshalBinary()[%d] = %v, want %v", ip, instruction, lines[ip])
+			}
+		}
+	}
+}
+
+func TestCPU_MarshalBinary(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(day21Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	// Take a snapshot part way through the program
+	if err := cpu.ExecuteWithLimits(context.Background(), 5000); !errors.Is(err, ErrInstructionLimit) {
+		t.Fatalf("CPU.ExecuteWithLimits() error = %v, want %v", err, ErrInstructionLimit)
+	}
+
+	data, err := cpu.MarshalBinary()
+	if err != nil {
+		t.Fatalf("CPU.MarshalBinary() error = %v", err)
+	}
+
+	restored, err := NewCPUFromSnapshot(data)
+	if err != nil {
+		t.Fatalf("NewCPUFromSnapshot() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(restored.Registers, cpu.Registers) || !reflect.DeepEqual(restored.Program, cpu.Program) ||
+		restored.InstructionPointerRegister != cpu.InstructionPointerRegister {
+		t.Fatalf("NewCPUFromSnapshot() = %+v, want %+v", restored, cpu)
+	}
+
+	// Both should carry on in the same way
+	want := cpu.ExecuteWithLimits(context.Background(), 5000)
+	got := restored.ExecuteWithLimits(context.Background(), 5000)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CPU.ExecuteWithLimits() after restoring = %v, want %v", got, want)
+	}
+}
+
+func TestBinary_Errors(t *testing.T) {
+	program, _ := Program{{AddR, 1, 2, 3}, {SetI, -5, 0, 1}}.MarshalBinary()
+	snapshot, _ := NewCPU(Program{{AddR, 1, 2, 3}}, 2, 4).MarshalBinary()
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"Empty", nil, ErrBinaryFormat},
+		{"Snapshot as program", snapshot, ErrBinaryFormat},
+		{"Newer version", append([]byte("ELFP\x04"), program[5:]...), ErrBinaryVersion},
+		{"Truncated", program[:len(program)-1], ErrBinaryTruncated},
+		{"Length past the end", []byte("ELFP\x01\x09\x00\x00\x00\x00"), ErrBinaryTruncated},
+		{"Trailing bytes", append(program, 0), nil},
+		{"Unknown op code", []byte("ELFP\x01\x01\x10\x00\x00\x00"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Program
+			err := got.UnmarshalBinary(tt.data)
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("Program.UnmarshalBinary() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The instruction pointer register is outside of the registers
+	invalid := append([]byte("ELFC\x03\x04"), snapshot[6:]...)
+	if _, err := NewCPUFromSnapshot(invalid); err == nil {
+		t.Errorf("NewCPUFromSnapshot() error = nil, want an error")
+	}
+
+	if _, err := NewCPUFromSnapshot(program); !errors.Is(err, ErrBinaryFormat) {
+		t.Errorf("NewCPUFromSnapshot() error = %v, want %v", err, ErrBinaryFormat)
+	}
+}
@@ -0,0 +1,186 @@
+package elf_code
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Writes the program out as a C program. The registers are set from the command line arguments
+// and printed once the program halts
+type CBackend struct{}
+
+func (CBackend) Indent() string {
+	return "    "
+}
+
+func (CBackend) WriteProgramStart(t *TranspileState, functions []*ProgramBlock, str *strings.Builder) {
+	writeCRegisters(len(t.Registers), str)
+
+	if t.idioms > 0 {
+		str.WriteString("// Idiom helpers\n")
+		str.WriteString("static long long maxInt(long long a, long long b) { return a > b ? a : b; }\n")
+		str.WriteString("static long long floorDiv(long long a, long long b) {\n")
+		str.WriteString("    long long q = a / b;\n")
+		str.WriteString("    return (a % b != 0 && (a < 0) != (b < 0)) ? q - 1 : q;\n")
+		str.WriteString("}\n")
+		str.WriteString("static long long shiftLeft(long long value, long long places) {\n")
+		str.WriteString("    return places >= 64 ? 0 : (long long)((unsigned long long)value << places);\n")
+		str.WriteString("}\n")
+		str.WriteString("static long long divisorHit(long long factor, long long from, long long target) {\n")
+		str.WriteString("    if (factor == 0 || target % factor != 0) { return 0; }\n")
+		str.WriteString("    long long j = target / factor;\n")
+		str.WriteString("    return j >= from && j <= maxInt(from, target) ? factor : 0;\n")
+		str.WriteString("}\n\n")
+	}
+
+	for _, b := range functions {
+		str.WriteString(fmt.Sprintf("static void block%d(void);\n", b.blockNum))
+	}
+	str.WriteRune('\n')
+}
+
+func (CBackend) WriteProgramEnd(t *TranspileState, functions []*ProgramBlock, str *strings.Builder) {
+	writeCMain(fmt.Sprintf("block%d", t.mainBlock.blockNum), str)
+}
+
+func writeCRegisters(numRegisters int, str *strings.Builder) {
+	str.WriteString("#include <stdio.h>\n#include <stdlib.h>\n\n")
+	str.WriteString(fmt.Sprintf("#define NUM_REGISTERS %d\n\n", numRegisters))
+	str.WriteString("// Registers\nstatic long long R[NUM_REGISTERS];\n\n")
+}
+
+// Writes a main function which sets the registers from the arguments, calls `run` and prints the registers
+func writeCMain(run string, str *strings.Builder) {
+	str.WriteString("int main(int argc, char **argv) {\n")
+	str.WriteString("    for (int i = 1; i < argc && i <= NUM_REGISTERS; i++) {\n")
+	str.WriteString("        R[i - 1] = atoll(argv[i]);\n")
+	str.WriteString("    }\n\n")
+	str.WriteString(fmt.Sprintf("    %s();\n\n", run))
+	str.WriteString("    for (int i = 0; i < NUM_REGISTERS; i++) {\n")
+	str.WriteString("        printf(i == 0 ? \"%lld\" : \" %lld\", R[i]);\n")
+	str.WriteString("    }\n")
+	str.WriteString("    printf(\"\\n\");\n\n")
+	str.WriteString("    return 0;\n")
+	str.WriteString("}\n")
+}
+
+func (CBackend) WriteFunctionStart(t *TranspileState, b *ProgramBlock, isMain bool, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("static void block%d(void) {\n", b.blockNum))
+}
+
+func (CBackend) WriteFunctionEnd(t *TranspileState, b *ProgramBlock, isMain bool, str *strings.Builder) {
+	str.WriteString("}\n\n")
+}
+
+func (CBackend) WriteStatement(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent)
+	line.WriteInstruction(str)
+	str.WriteString(fmt.Sprintf("; // %s\n", t.ipComment(line.ip)))
+}
+
+func (CBackend) WriteCall(t *TranspileState, line *ProgramLine, target *ProgramBlock, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%sblock%d();", indent, target.blockNum))
+	if line != nil {
+		str.WriteString(fmt.Sprintf(" // %s", t.ipComment(line.ip)))
+	}
+	str.WriteRune('\n')
+}
+
+func (CBackend) WriteIfStart(t *TranspileState, line *ProgramLine, register int, value int, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%sif (R[%d] == %d) { // %s\n", indent, register, value, t.ipComment(line.ip)))
+}
+
+func (CBackend) WriteElse(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent + "} else {\n")
+}
+
+func (CBackend) WriteIfEnd(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent + "}\n")
+}
+
+func (CBackend) WriteDoWhileStart(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent + "do {\n")
+}
+
+func (CBackend) WriteDoWhileEnd(t *TranspileState, line *ProgramLine, register int, value int, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%s} while (R[%d] == %d); // %s\n", indent, register, value, t.ipComment(line.ip)))
+}
+
+func (CBackend) WriteIdiom(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	writeIdiomAssignments(t, line, indent, ";", str)
+}
+
+func (CBackend) WriteComment(t *TranspileState, comment string, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%s// %s\n", indent, comment))
+}
+
+func (CBackend) Finish(source string) (string, error) {
+	return source, nil
+}
+
+func (CBackend) WriteASTStart(d *DecompiledProgram, str *strings.Builder) {
+	writeCRegisters(d.NumRegisters, str)
+	str.WriteString("static void run(void) {\n")
+}
+
+func (CBackend) WritesGotos() bool {
+	return true
+}
+
+func (CBackend) WriteASTEnd(d *DecompiledProgram, str *strings.Builder) {
+	str.WriteString("}\n\n")
+	writeCMain("run", str)
+}
+
+func (CBackend) WriteASTNodeStart(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder) {
+	str.WriteString(indent)
+
+	switch node.Kind {
+	case ASTStatement:
+		node.programLine().WriteInstruction(str)
+		str.WriteString(";" + d.nodeComment(node))
+	case ASTIf:
+		str.WriteString(fmt.Sprintf("if (%s) {%s", node.Condition, d.nodeComment(node)))
+	case ASTWhile:
+		str.WriteString(fmt.Sprintf("while (%s) {%s", node.Condition, d.nodeComment(node)))
+	case ASTDoWhile:
+		str.WriteString("do {")
+	case ASTLoop:
+		str.WriteString("for (;;) {")
+	case ASTBreak, ASTContinue:
+		// C has no labelled breaks or continues, so they jump to the labels written around the loop
+		if node.Labelled {
+			str.WriteString(fmt.Sprintf("goto %s_%s;", node.loopName(), node.Kind))
+		} else {
+			str.WriteString(node.Kind.String() + ";")
+		}
+	case ASTHalt:
+		str.WriteString("return;")
+	case ASTGoto:
+		str.WriteString(fmt.Sprintf("goto L%d; // irreducible control flow", node.Label))
+	case ASTLabel:
+		str.WriteString(fmt.Sprintf("L%d:;", node.Label))
+	}
+
+	str.WriteRune('\n')
+}
+
+func (CBackend) WriteASTElse(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder) {
+	str.WriteString(indent + "} else {\n")
+}
+
+func (c CBackend) WriteASTNodeEnd(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder) {
+	if node.Labelled {
+		str.WriteString(fmt.Sprintf("%s%s%s_continue:;\n", indent, c.Indent(), node.loopName()))
+	}
+
+	if node.Kind == ASTDoWhile {
+		str.WriteString(fmt.Sprintf("%s} while (%s);%s\n", indent, node.Condition, d.nodeComment(node)))
+	} else {
+		str.WriteString(indent + "}\n")
+	}
+
+	if node.Labelled {
+		str.WriteString(fmt.Sprintf("%s%s_break:;\n", indent, node.loopName()))
+	}
+}
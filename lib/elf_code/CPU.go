@@ -2,6 +2,7 @@ package elf_code
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -15,6 +16,39 @@ type CPU struct {
 	Registers                  Registers // The register state of the CPU
 	Program                    Program   // The loaded program within the CPU
 	InstructionPointerRegister int       // The register that represents the instruction pointer
+	Tracer                     Tracer    // If set, is given every instruction the CPU executes
+	Host                       Host      // What op codes which talk to the host, such as I/O, use
+	Word                       WordSize  // The size of the values the registers hold, which results are wrapped to
+
+	traceBuffer Registers // Holds the registers before an instruction when tracing
+}
+
+// Checks every instruction has a known op code and only references registers which exist
+func (p Program) Validate(numRegisters int) error {
+	inRange := func(register int) bool {
+		return register >= 0 && register < numRegisters
+	}
+
+	for ip, instruction := range p {
+		isImmediate, found := OpCodeInputType[instruction.OpCode]
+		if !found {
+			return fmt.Errorf("unknown op code at %d: %s", ip, instruction)
+		}
+
+		if !isImmediate.A && !inRange(instruction.A) {
+			return fmt.Errorf("input A out of range at %d: %s", ip, instruction)
+		}
+
+		if !isImmediate.B && !inRange(instruction.B) {
+			return fmt.Errorf("input B out of range at %d: %s", ip, instruction)
+		}
+
+		if !inRange(instruction.C) {
+			return fmt.Errorf("output C out of range at %d: %s", ip, instruction)
+		}
+	}
+
+	return nil
 }
 
 // Creates a new CPU and loads it with the given program
@@ -24,32 +58,46 @@ func NewCPU(program Program, ipRegister int, numRegisters int) (res *CPU) {
 	}
 
 	return &CPU{
-		NewRegisters(numRegisters),
-		program,
-		ipRegister,
+		Registers:                  NewRegisters(numRegisters),
+		Program:                    program,
+		InstructionPointerRegister: ipRegister,
 	}
 }
 
-// Creates a new CPU using a program file
+// Creates a new CPU using a program file. As well as `#ip N`, the lines starting with `#` can set up the CPU
+// with `#regs N` for the number of registers and `#word N [signed|unsigned]` for the size of the registers
 func NewCPUFromProgramFile(fileContents string) (res *CPU, err error) {
-	ipRegister := 0
+	ipRegister, foundIP := 0, false
+	config := CPUConfig{}
 	program := make(Program, 0)
 
 	scanner := bufio.NewScanner(strings.NewReader(fileContents))
 
-	// Read the IP register binding
-	scanner.Scan()
-	num, e := fmt.Sscanf(scanner.Text(), "#ip %d", &ipRegister)
-	if e != nil {
-		return nil, e
-	}
-	if num != 1 {
-		return nil, errors.New("invalid number of params found on ip line")
-	}
-
-	// Parse the program instructions
 	for scanner.Scan() {
-		instruction, e := NewInstruction(scanner.Text())
+		line := scanner.Text()
+
+		// Read the directives
+		if fields := strings.Fields(line); len(fields) > 0 && strings.HasPrefix(fields[0], "#") {
+			if fields[0] == "#ip" {
+				num, e := fmt.Sscanf(line, "#ip %d", &ipRegister)
+				if e != nil {
+					return nil, e
+				}
+				if num != 1 {
+					return nil, errors.New("invalid number of params found on ip line")
+				}
+				foundIP = true
+			} else if found, e := config.parseDirective(fields); e != nil {
+				return nil, e
+			} else if !found {
+				return nil, fmt.Errorf("unknown directive %q", fields[0])
+			}
+
+			continue
+		}
+
+		// Parse the program instructions
+		instruction, e := NewInstruction(line)
 		if e != nil {
 			return nil, e
 		}
@@ -62,36 +110,76 @@ func NewCPUFromProgramFile(fileContents string) (res *CPU, err error) {
 		return
 	}
 
+	if !foundIP {
+		return nil, errors.New("no #ip directive found")
+	}
+
 	// Return the CPU
-	return NewCPU(program, ipRegister, 6), nil
+	return NewCPUWithConfig(program, ipRegister, config)
 }
 
 // Executes the program loaded into the CPU
 func (cpu *CPU) Execute() (err error) {
-	numInstructions := len(cpu.Program)
-
 	// While the instruction pointer is within the program
-	for cpu.Registers[cpu.InstructionPointerRegister] >= 0 &&
-		cpu.Registers[cpu.InstructionPointerRegister] < numInstructions {
+	for !cpu.Halted() {
+		err = cpu.Step()
+		if err != nil {
+			return
+		}
+	}
+
+	// Reduce the program counter back down
+	cpu.Registers[cpu.InstructionPointerRegister]--
 
-		// Grab the next instruction
-		instruction := cpu.Program[cpu.Registers[cpu.InstructionPointerRegister]]
+	return nil
+}
 
-		// Execute it
-		result, e := OpCodeFunc[instruction.OpCode](instruction.A, instruction.B, cpu.Registers)
+// How often ExecuteWithLimits checks if the context has been cancelled
+const contextCheckInterval = 4096
 
-		if e != nil {
-			return e
+// Returned by ExecuteWithLimits when `maxInstructions` have been executed without the program halting
+var ErrInstructionLimit = errors.New("instruction limit reached")
+
+// The program was stopped before it halted
+type ExecutionStoppedError struct {
+	Reason       error     // Either ErrInstructionLimit or the error from the context
+	IP           int       // The instruction pointer of the next instruction which would have executed
+	Registers    Registers // The registers at the point execution stopped
+	Instructions int       // The number of instructions executed
+}
+
+func (e *ExecutionStoppedError) Error() string {
+	return fmt.Sprintf("execution stopped at ip %d after %d instructions with registers %s: %v", e.IP, e.Instructions, e.Registers, e.Reason)
+}
+
+func (e *ExecutionStoppedError) Unwrap() error {
+	return e.Reason
+}
+
+// Executes the program loaded into the CPU, giving up once `maxInstructions` have been executed
+// (if greater than zero) or the context is done. If execution is stopped early, the error returned
+// is an *ExecutionStoppedError
+func (cpu *CPU) ExecuteWithLimits(ctx context.Context, maxInstructions int) (err error) {
+	executed := 0
+
+	for !cpu.Halted() {
+		if maxInstructions > 0 && executed >= maxInstructions {
+			return cpu.stoppedError(ErrInstructionLimit, executed)
 		}
 
-		// Store the result of it
-		e = cpu.Registers.Set(instruction.C, result)
-		if e != nil {
-			return e
+		if executed%contextCheckInterval == 0 {
+			select {
+			case <-ctx.Done():
+				return cpu.stoppedError(ctx.Err(), executed)
+			default:
+			}
 		}
 
-		// Increment the instruction pointer
-		cpu.Registers[cpu.InstructionPointerRegister]++
+		err = cpu.Step()
+		if err != nil {
+			return
+		}
+		executed++
 	}
 
 	// Reduce the program counter back down
@@ -99,3 +187,59 @@ func (cpu *CPU) Execute() (err error) {
 
 	return nil
 }
+
+func (cpu *CPU) stoppedError(reason error, executed int) error {
+	return &ExecutionStoppedError{
+		reason,
+		cpu.Registers[cpu.InstructionPointerRegister],
+		cpu.Registers.Copy(),
+		executed,
+	}
+}
+
+// Is the instruction pointer outside of the loaded program?
+func (cpu *CPU) Halted() bool {
+	ip := cpu.Registers[cpu.InstructionPointerRegister]
+	return ip < 0 || ip >= len(cpu.Program)
+}
+
+// Executes the single instruction the instruction pointer is currently pointing at
+func (cpu *CPU) Step() (err error) {
+	if cpu.Halted() {
+		return errors.New("instruction pointer is outside of the program")
+	}
+
+	// Grab the next instruction
+	ip := cpu.Registers[cpu.InstructionPointerRegister]
+	instruction := cpu.Program[ip]
+
+	if cpu.Tracer != nil {
+		cpu.traceBuffer = append(cpu.traceBuffer[:0], cpu.Registers...)
+	}
+
+	// Execute it
+	var result int
+	if instruction.OpCode.talksToHost() {
+		result, err = executeRegistered(&instruction, cpu.Registers, &cpu.Host)
+	} else {
+		result, err = OpCodeFunc[instruction.OpCode](instruction.A, instruction.B, cpu.Registers)
+	}
+	if err != nil {
+		return
+	}
+
+	// Store the result of it
+	err = cpu.Registers.Set(instruction.C, cpu.Word.Wrap(result))
+	if err != nil {
+		return
+	}
+
+	// Increment the instruction pointer
+	cpu.Registers[cpu.InstructionPointerRegister]++
+
+	if cpu.Tracer != nil {
+		cpu.Tracer.Trace(ip, instruction, cpu.traceBuffer, cpu.Registers)
+	}
+
+	return nil
+}
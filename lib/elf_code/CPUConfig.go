@@ -0,0 +1,139 @@
+package elf_code
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The number of registers a CPU has unless configured otherwise, as in the puzzles
+const DefaultNumRegisters = 6
+
+// The largest word size in bits, as values are held in Go ints
+const maxWordBits = 63
+
+// The size of the values registers hold. The zero value is Go's native int
+type WordSize struct {
+	Bits     int  // The number of bits in a register, from 1 to 63, or 0 for Go's native int
+	Unsigned bool // If values wrap around to 0 to 2^Bits - 1, rather than -2^(Bits-1) to 2^(Bits-1) - 1
+}
+
+// Wraps the value around to fit in the word, as a register of that size would when the value is written to it
+func (w WordSize) Wrap(value int) int {
+	if w.Bits == 0 {
+		return value
+	}
+
+	value &= 1<<uint(w.Bits) - 1
+	if !w.Unsigned && value >= 1<<uint(w.Bits-1) {
+		value -= 1 << uint(w.Bits)
+	}
+
+	return value
+}
+
+func (w WordSize) String() string {
+	switch {
+	case w.Bits == 0:
+		return "native"
+	case w.Unsigned:
+		return strconv.Itoa(w.Bits) + " bit unsigned"
+	default:
+		return strconv.Itoa(w.Bits) + " bit signed"
+	}
+}
+
+func (w WordSize) validate() error {
+	if w.Bits < 0 || w.Bits > maxWordBits {
+		return fmt.Errorf("word size of %d bits out of range", w.Bits)
+	}
+
+	if w.Bits == 0 && w.Unsigned {
+		return errors.New("native words can't be unsigned")
+	}
+
+	return nil
+}
+
+// How a CPU is set up, which programs can choose with the `#regs` and `#word` directives
+type CPUConfig struct {
+	NumRegisters int      // The number of registers, DefaultNumRegisters if zero
+	Word         WordSize // The size of the values the registers hold
+}
+
+func (c CPUConfig) numRegisters() int {
+	if c.NumRegisters == 0 {
+		return DefaultNumRegisters
+	}
+
+	return c.NumRegisters
+}
+
+func (c CPUConfig) validate(ipRegister int) error {
+	if c.NumRegisters < 0 {
+		return fmt.Errorf("invalid number of registers %d", c.NumRegisters)
+	}
+
+	if ipRegister < 0 || ipRegister >= c.numRegisters() {
+		return fmt.Errorf("ip register %d out of range of %d registers", ipRegister, c.numRegisters())
+	}
+
+	return c.Word.validate()
+}
+
+// Creates a new CPU set up by the config, and loads it with the given program
+func NewCPUWithConfig(program Program, ipRegister int, config CPUConfig) (res *CPU, err error) {
+	if err = config.validate(ipRegister); err != nil {
+		return nil, err
+	}
+
+	res = NewCPU(program, ipRegister, config.numRegisters())
+	res.Word = config.Word
+
+	return res, nil
+}
+
+// How the CPU is set up
+func (cpu *CPU) Config() CPUConfig {
+	return CPUConfig{len(cpu.Registers), cpu.Word}
+}
+
+// Parses the `#regs N` and `#word N [signed|unsigned]` directives, returning false for any other directive
+func (c *CPUConfig) parseDirective(fields []string) (found bool, err error) {
+	switch strings.TrimLeft(fields[0], "#.") {
+	case "regs":
+		if len(fields) != 2 {
+			return true, errors.New("#regs expects a number of registers")
+		}
+
+		if c.NumRegisters, err = strconv.Atoi(fields[1]); err != nil || c.NumRegisters < 1 {
+			return true, fmt.Errorf("invalid number of registers %q", fields[1])
+		}
+
+	case "word":
+		if len(fields) < 2 || len(fields) > 3 {
+			return true, errors.New("#word expects a number of bits and optionally signed or unsigned")
+		}
+
+		if c.Word.Bits, err = strconv.Atoi(fields[1]); err != nil || c.Word.Bits < 1 || c.Word.Bits > maxWordBits {
+			return true, fmt.Errorf("invalid word size %q", fields[1])
+		}
+
+		c.Word.Unsigned = false
+		if len(fields) == 3 {
+			switch fields[2] {
+			case "signed":
+			case "unsigned":
+				c.Word.Unsigned = true
+			default:
+				return true, fmt.Errorf("expected signed or unsigned, got %q", fields[2])
+			}
+		}
+
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
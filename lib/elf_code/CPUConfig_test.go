@@ -0,0 +1,145 @@
+package elf_code
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Adds 100 to R[0] ten times, which wraps around in 8 bit registers
+const wordTestProgram = `#regs 8
+#word 8
+#ip 7
+seti 0 0 0
+seti 0 0 1
+addi 0 100 0
+addi 1 1 1
+gtri 1 9 2
+addr 2 7 7
+seti 1 0 7`
+
+func TestWordSize_Wrap(t *testing.T) {
+	tests := []struct {
+		word  WordSize
+		value int
+		want  int
+	}{
+		{WordSize{}, 1 << 40, 1 << 40},
+		{WordSize{24, false}, 1<<23 - 1, 1<<23 - 1},
+		{WordSize{24, false}, 1 << 23, -1 << 23},
+		{WordSize{24, false}, -1, -1},
+		{WordSize{24, true}, -1, 16777215},
+		{WordSize{24, true}, 16777216 + 5, 5},
+		{WordSize{8, true}, 256, 0},
+		{WordSize{8, false}, 1000, -24},
+	}
+	for _, tt := range tests {
+		if got := tt.word.Wrap(tt.value); got != tt.want {
+			t.Errorf("WordSize{%v}.Wrap(%d) = %v, want %v", tt.word, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNewCPUFromProgramFile_Config(t *testing.T) {
+	tests := []struct {
+		name    string
+		execute func(cpu *CPU) error
+	}{
+		{"Interpreter", func(cpu *CPU) error { return cpu.Execute() }},
+		{"Fast", func(cpu *CPU) error { return cpu.ExecuteFast() }},
+		{"JIT", func(cpu *CPU) error {
+			stats, err := cpu.ExecuteJIT(JITOptions{HotLoopThreshold: 1})
+			if stats.CompiledLoops == 0 {
+				t.Errorf("CPU.ExecuteJIT() stats = %+v, want loops to be compiled", stats)
+			}
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(wordTestProgram)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			if want := (CPUConfig{8, WordSize{8, false}}); cpu.Config() != want {
+				t.Errorf("CPU.Config() = %+v, want %+v", cpu.Config(), want)
+			}
+
+			if err := tt.execute(cpu); err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+
+			if want := (Registers{-24, 10, 1, 0, 0, 0, 0, 6}); !reflect.DeepEqual(cpu.Registers, want) {
+				t.Errorf("Execute() = %v, want %v", cpu.Registers, want)
+			}
+		})
+	}
+}
+
+func TestNewCPUFromProgramFile_ConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		wantErr string
+	}{
+		{"IP out of range", "#regs 4\n#ip 5\nseti 0 0 0", "ip register 5 out of range of 4 registers"},
+		{"No registers", "#regs 0\n#ip 0", `invalid number of registers "0"`},
+		{"Word too large", "#word 64\n#ip 0", `invalid word size "64"`},
+		{"Not signed or unsigned", "#word 8 maybe\n#ip 0", `expected signed or unsigned, got "maybe"`},
+		{"Missing bits", "#word\n#ip 0", "#word expects a number of bits and optionally signed or unsigned"},
+		{"Unknown directive", "#ip 0\n#stack 8", `unknown directive "#stack"`},
+		{"No ip", "seti 0 0 0", "no #ip directive found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCPUFromProgramFile(tt.program); err == nil || err.Error() != tt.wantErr {
+				t.Errorf("NewCPUFromProgramFile() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAssemble_Config(t *testing.T) {
+	cpu, err := NewCPUFromAssembly(".reg ip 7\n#ip ip\n.regs 8\n.word 16 unsigned\nseti 65535 0 6\naddi 6 1 6")
+	if err != nil {
+		t.Fatalf("NewCPUFromAssembly() error = %v", err)
+	}
+
+	if want := (CPUConfig{8, WordSize{16, true}}); cpu.Config() != want || cpu.InstructionPointerRegister != 7 {
+		t.Errorf("NewCPUFromAssembly() = %+v with ip %d, want %+v with ip 7", cpu.Config(), cpu.InstructionPointerRegister, want)
+	}
+
+	if err := cpu.Execute(); err != nil || cpu.Registers[6] != 0 {
+		t.Errorf("CPU.Execute() = %v, %v, want R[6] to wrap to 0", cpu.Registers, err)
+	}
+
+	if _, err := Assemble(".reg x 6\n#ip 0"); err == nil || err.Error() != "line 1: register 6 out of range" {
+		t.Errorf("Assemble() error = %v, want register 6 out of range", err)
+	}
+}
+
+func TestCPUConfig_Tools(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(wordTestProgram)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	// Snapshots keep the word size
+	data, err := cpu.MarshalBinary()
+	if err != nil {
+		t.Fatalf("CPU.MarshalBinary() error = %v", err)
+	}
+	restored, err := NewCPUFromSnapshot(data)
+	if err != nil || restored.Config() != cpu.Config() {
+		t.Errorf("NewCPUFromSnapshot() = %+v, %v, want %+v", restored.Config(), err, cpu.Config())
+	}
+
+	// The transpiler only writes native words
+	if _, err := cpu.StartTranspiler(TranspileOptions{}).Run(); err == nil || err.Error() != "unable to transpile 8 bit signed words" {
+		t.Errorf("TranspileState.Run() error = %v, want unable to transpile 8 bit signed words", err)
+	}
+
+	if _, err := cpu.ExecuteSymbolic(SymbolicOptions{}); err == nil {
+		t.Errorf("CPU.ExecuteSymbolic() with 8 bit words, want error")
+	}
+}
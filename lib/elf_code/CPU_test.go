@@ -1,6 +1,8 @@
 package elf_code
 
 import (
+	"context"
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -42,3 +44,55 @@ seti 9 0 5`
 		t.Errorf("NewCPUFromProgramFile() = %v, want %v", cpu.Registers, want)
 	}
 }
+
+func TestCPU_ExecuteWithLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name            string
+		program         string
+		ctx             context.Context
+		maxInstructions int
+		wantReason      error
+		wantIP          int
+	}{
+		{"Halts", debuggerTestProgram, context.Background(), 100, nil, 0},
+		{"Unlimited", debuggerTestProgram, context.Background(), 0, nil, 0},
+		{"Infinite loop", "#ip 1\naddi 0 1 0\nseti -1 0 1", context.Background(), 1001, ErrInstructionLimit, 1},
+		{"Cancelled", "#ip 1\naddi 0 1 0\nseti -1 0 1", cancelled, 0, context.Canceled, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			err = cpu.ExecuteWithLimits(tt.ctx, tt.maxInstructions)
+			if tt.wantReason == nil {
+				if err != nil {
+					t.Errorf("CPU.ExecuteWithLimits() error = %v", err)
+				}
+				return
+			}
+
+			stopped, ok := err.(*ExecutionStoppedError)
+			if !ok {
+				t.Fatalf("CPU.ExecuteWithLimits() error = %v, want *ExecutionStoppedError", err)
+			}
+
+			if !errors.Is(err, tt.wantReason) {
+				t.Errorf("CPU.ExecuteWithLimits() reason = %v, want %v", stopped.Reason, tt.wantReason)
+			}
+
+			if stopped.IP != tt.wantIP || stopped.Instructions != tt.maxInstructions {
+				t.Errorf("CPU.ExecuteWithLimits() stopped at %d after %d, want %d after %d", stopped.IP, stopped.Instructions, tt.wantIP, tt.maxInstructions)
+			}
+
+			if !reflect.DeepEqual(stopped.Registers, cpu.Registers) {
+				t.Errorf("CPU.ExecuteWithLimits() registers = %v, want %v", stopped.Registers, cpu.Registers)
+			}
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package elf_code
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// How an instruction changes the instruction pointer
+type JumpKind int
+
+const (
+	NoJump          JumpKind = iota // The instruction doesn't write to the instruction pointer
+	Jump                            // The instruction always jumps to the same target
+	ConditionalJump                 // The instruction skips the next instruction if a comparison was true
+	ComputedJump                    // The target depends on a register which isn't known until the program runs
+)
+
+func (k JumpKind) String() string {
+	switch k {
+	case NoJump:
+		return "none"
+	case Jump:
+		return "jump"
+	case ConditionalJump:
+		return "conditional"
+	case ComputedJump:
+		return "computed"
+	default:
+		return "unknown"
+	}
+}
+
+// Where execution can go after an instruction
+type JumpInfo struct {
+	Kind              JumpKind // The type of jump
+	Targets           []int    // The possible next instruction pointers, for conditional jumps the false target is first
+	Relative          bool     // Is the target relative to the current instruction pointer?
+	ConditionRegister int      // The register holding the comparison result for conditional jumps
+}
+
+// Works out where execution can go after the instruction at `ip`. Targets outside of the program halt it.
+// `booleanInputs` are registers which can be assumed to hold 0 or 1 when they aren't set by a comparison
+// before the jump, such as R[0] which picks the part of the puzzle in day 19
+func (p Program) JumpAt(ip int, ipRegister int, booleanInputs ...int) (info JumpInfo) {
+	instruction := p[ip]
+	isImmediate := OpCodeInputType[instruction.OpCode]
+
+	if instruction.C != ipRegister {
+		return JumpInfo{NoJump, []int{ip + 1}, false, -1}
+	}
+
+	info.ConditionRegister = -1
+	info.Relative = (!isImmediate.A && instruction.A == ipRegister) || (!isImmediate.B && instruction.B == ipRegister)
+
+	// If every register input is the instruction pointer, then we know the target
+	if (isImmediate.A || instruction.A == ipRegister) && (isImmediate.B || instruction.B == ipRegister) {
+		registers := NewRegisters(ipRegister + 1)
+		registers[ipRegister] = ip
+
+		value, err := OpCodeFunc[instruction.OpCode](instruction.A, instruction.B, registers)
+		if err != nil {
+			return JumpInfo{ComputedJump, nil, info.Relative, -1}
+		}
+
+		info.Kind = Jump
+		info.Targets = []int{value + 1}
+		return
+	}
+
+	// Adding a comparison result to the instruction pointer skips the next instruction
+	if instruction.OpCode == AddR {
+		condition := instruction.A
+		if condition == ipRegister {
+			condition = instruction.B
+		}
+
+		if condition != ipRegister && (instruction.A == ipRegister || instruction.B == ipRegister) &&
+			p.holdsComparison(ip, condition, ipRegister, booleanInputs) {
+			return JumpInfo{ConditionalJump, []int{ip + 1, ip + 2}, true, condition}
+		}
+	}
+
+	return JumpInfo{ComputedJump, nil, info.Relative, -1}
+}
+
+// Is the register set by a comparison before it is used at `ip`?
+func (p Program) holdsComparison(ip int, register int, ipRegister int, booleanInputs []int) bool {
+	for prev := ip - 1; prev >= 0; prev-- {
+		instruction := p[prev]
+
+		if instruction.C == register {
+			// A jump landing after the comparison could get here with anything in the register
+			return instruction.OpCode.isComparator() && !p.jumpsInto(prev+1, ip, ipRegister)
+		}
+
+		if instruction.C == ipRegister {
+			// We've gone past a jump, so we can't be sure what the register holds
+			break
+		}
+	}
+
+	for _, input := range booleanInputs {
+		if input == register {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Can a jump land on any instruction from `from` to `to`? Adding a register to the instruction pointer
+// is assumed to be able to skip the next instruction, so this doesn't depend on `JumpAt`
+func (p Program) jumpsInto(from int, to int, ipRegister int) bool {
+	for ip, instruction := range p {
+		if instruction.C != ipRegister {
+			continue
+		}
+
+		targets := make([]int, 0, 2)
+		isImmediate := OpCodeInputType[instruction.OpCode]
+		switch {
+		case (isImmediate.A || instruction.A == ipRegister) && (isImmediate.B || instruction.B == ipRegister):
+			registers := NewRegisters(ipRegister + 1)
+			registers[ipRegister] = ip
+			if value, err := OpCodeFunc[instruction.OpCode](instruction.A, instruction.B, registers); err == nil {
+				targets = append(targets, value+1)
+			}
+
+		case instruction.OpCode == AddR && (instruction.A == ipRegister || instruction.B == ipRegister):
+			targets = append(targets, ip+1, ip+2)
+		}
+
+		for _, target := range targets {
+			if target >= from && target <= to {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// A straight run of instructions which is only entered at the start and left at the end
+type BasicBlock struct {
+	Number       int   `json:"number"`       // The block number, blocks are numbered by their position in the program
+	Start        int   `json:"start"`        // The first instruction pointer of the block
+	End          int   `json:"end"`          // The last instruction pointer of the block
+	Successors   []int `json:"successors"`   // The blocks execution can move to after this block
+	Predecessors []int `json:"predecessors"` // The blocks which can move to this block
+	Exits        bool  `json:"exits"`        // Can the program halt after this block?
+	ComputedJump bool  `json:"computedJump"` // Does the block end in a jump with an unknown target?
+	Reachable    bool  `json:"reachable"`    // Can this block be reached from the start of the program?
+	Reads        []int `json:"reads"`        // Registers read by the block before it writes to them (excluding the IP register)
+	Writes       []int `json:"writes"`       // Registers written by the block (excluding the IP register)
+}
+
+// The basic blocks of a program and how execution moves between them
+type ControlFlowGraph struct {
+	Program       Program       `json:"-"`
+	IPRegister    int           `json:"ipRegister"`    // The register bound to the instruction pointer
+	NumRegisters  int           `json:"numRegisters"`  // The number of registers of the CPU
+	BooleanInputs []int         `json:"booleanInputs"` // The registers assumed to hold 0 or 1 when used in jumps
+	Blocks        []*BasicBlock `json:"blocks"`        // The blocks in program order, the first is the entry block
+
+	blockAt []int // Instruction pointer => block number
+}
+
+// Builds the control flow graph of the program loaded into the CPU
+func (cpu *CPU) ControlFlowGraph(booleanInputs ...int) *ControlFlowGraph {
+	return NewControlFlowGraph(cpu.Program, cpu.InstructionPointerRegister, len(cpu.Registers), booleanInputs...)
+}
+
+// Builds the control flow graph of the given program, see `Program.JumpAt` for `booleanInputs`
+func NewControlFlowGraph(program Program, ipRegister int, numRegisters int, booleanInputs ...int) *ControlFlowGraph {
+	return buildControlFlowGraph(program, ipRegister, numRegisters, booleanInputs, func(ip int) JumpInfo {
+		return program.JumpAt(ip, ipRegister, booleanInputs...)
+	})
+}
+
+// Builds the control flow graph with `jumpAt` giving where execution can go after each instruction
+func buildControlFlowGraph(program Program, ipRegister int, numRegisters int, booleanInputs []int, jumpAt func(ip int) JumpInfo) *ControlFlowGraph {
+	g := &ControlFlowGraph{
+		Program:       program,
+		IPRegister:    ipRegister,
+		NumRegisters:  numRegisters,
+		BooleanInputs: append(make([]int, 0), booleanInputs...),
+		Blocks:        make([]*BasicBlock, 0),
+		blockAt:       make([]int, len(program)),
+	}
+
+	if len(program) == 0 {
+		return g
+	}
+
+	// Find the instructions which start a block
+	leaders := make([]bool, len(program))
+	leaders[0] = true
+	jumps := make([]JumpInfo, len(program))
+	for ip := range program {
+		jumps[ip] = jumpAt(ip)
+		if jumps[ip].Kind == NoJump {
+			continue
+		}
+
+		if ip+1 < len(program) {
+			leaders[ip+1] = true
+		}
+
+		for _, target := range jumps[ip].Targets {
+			if g.inProgram(target) {
+				leaders[target] = true
+			}
+		}
+	}
+
+	// Split the program into blocks
+	for ip := range program {
+		if leaders[ip] {
+			g.Blocks = append(g.Blocks, &BasicBlock{
+				Number:       len(g.Blocks),
+				Start:        ip,
+				Successors:   make([]int, 0),
+				Predecessors: make([]int, 0),
+			})
+		}
+
+		block := g.Blocks[len(g.Blocks)-1]
+		block.End = ip
+		g.blockAt[ip] = block.Number
+	}
+
+	// Link the blocks together and work out the registers they use
+	for _, block := range g.Blocks {
+		jump := jumps[block.End]
+		block.ComputedJump = jump.Kind == ComputedJump
+
+		for _, target := range jump.Targets {
+			if g.inProgram(target) {
+				g.addEdge(block, g.Blocks[g.blockAt[target]])
+			} else {
+				block.Exits = true
+			}
+		}
+
+		block.Reads, block.Writes = g.registerUse(block)
+	}
+
+	g.markReachable(g.Blocks[0])
+
+	return g
+}
+
+func (g *ControlFlowGraph) inProgram(ip int) bool {
+	return ip >= 0 && ip < len(g.Program)
+}
+
+func (g *ControlFlowGraph) addEdge(from *BasicBlock, to *BasicBlock) {
+	for _, existing := range from.Successors {
+		if existing == to.Number {
+			return
+		}
+	}
+
+	from.Successors = append(from.Successors, to.Number)
+	to.Predecessors = append(to.Predecessors, from.Number)
+	sort.Ints(to.Predecessors)
+}
+
+func (g *ControlFlowGraph) markReachable(block *BasicBlock) {
+	if block.Reachable {
+		return
+	}
+	block.Reachable = true
+
+	for _, successor := range block.Successors {
+		g.markReachable(g.Blocks[successor])
+	}
+}
+
+// The registers read before they are written, and all registers written by the block
+func (g *ControlFlowGraph) registerUse(block *BasicBlock) (reads []int, writes []int) {
+	written := make([]bool, g.NumRegisters)
+	read := make([]bool, g.NumRegisters)
+	isRegister := func(register int) bool {
+		return register >= 0 && register < g.NumRegisters && register != g.IPRegister
+	}
+
+	for ip := block.Start; ip <= block.End; ip++ {
+		instruction := g.Program[ip]
+		isImmediate := OpCodeInputType[instruction.OpCode]
+
+		if !isImmediate.A && isRegister(instruction.A) && !written[instruction.A] {
+			read[instruction.A] = true
+		}
+
+		if !isImmediate.B && isRegister(instruction.B) && !written[instruction.B] {
+			read[instruction.B] = true
+		}
+
+		if isRegister(instruction.C) {
+			written[instruction.C] = true
+		}
+	}
+
+	reads, writes = make([]int, 0), make([]int, 0)
+	for register := 0; register < g.NumRegisters; register++ {
+		if read[register] {
+			reads = append(reads, register)
+		}
+
+		if written[register] {
+			writes = append(writes, register)
+		}
+	}
+
+	return
+}
+
+// The block containing the instruction pointer, or nil if it's outside the program
+func (g *ControlFlowGraph) BlockAt(ip int) *BasicBlock {
+	if !g.inProgram(ip) {
+		return nil
+	}
+
+	return g.Blocks[g.blockAt[ip]]
+}
+
+// The label for the edge between two blocks, for conditional jumps this is true or false
+func (g *ControlFlowGraph) edgeLabel(from *BasicBlock, targetIP int) string {
+	jump := g.Program.JumpAt(from.End, g.IPRegister, g.BooleanInputs...)
+	if jump.Kind != ConditionalJump {
+		return ""
+	}
+
+	if targetIP == jump.Targets[1] {
+		return fmt.Sprintf("R[%d] == 1", jump.ConditionRegister)
+	}
+
+	return fmt.Sprintf("R[%d] == 0", jump.ConditionRegister)
+}
+
+// Writes the graph out in Graphviz DOT format
+func (g *ControlFlowGraph) DOT() string {
+	var str strings.Builder
+
+	registerList := func(registers []int) string {
+		names := make([]string, len(registers))
+		for i, register := range registers {
+			names[i] = fmt.Sprintf("R[%d]", register)
+		}
+		return strings.Join(names, ", ")
+	}
+
+	str.WriteString("digraph elf_code {\n")
+	str.WriteString("    node [shape=box, fontname=\"monospace\"];\n")
+
+	for _, block := range g.Blocks {
+		var label strings.Builder
+		label.WriteString(fmt.Sprintf("block%d (IP %d-%d)\\l", block.Number, block.Start, block.End))
+		label.WriteString(fmt.Sprintf("reads: %s\\l", registerList(block.Reads)))
+		label.WriteString(fmt.Sprintf("writes: %s\\l", registerList(block.Writes)))
+		for ip := block.Start; ip <= block.End; ip++ {
+			label.WriteString(fmt.Sprintf("%d: %s\\l", ip, g.Program[ip]))
+		}
+
+		style := ""
+		if !block.Reachable {
+			style = ", style=dashed"
+		}
+		str.WriteString(fmt.Sprintf("    block%d [label=\"%s\"%s];\n", block.Number, label.String(), style))
+	}
+
+	str.WriteString("    exit [shape=doublecircle, label=\"halt\"];\n")
+
+	for _, block := range g.Blocks {
+		jump := g.Program.JumpAt(block.End, g.IPRegister, g.BooleanInputs...)
+
+		for _, target := range jump.Targets {
+			to := "exit"
+			if g.inProgram(target) {
+				to = fmt.Sprintf("block%d", g.blockAt[target])
+			}
+
+			if label := g.edgeLabel(block, target); label != "" {
+				str.WriteString(fmt.Sprintf("    block%d -> %s [label=\"%s\"];\n", block.Number, to, label))
+			} else {
+				str.WriteString(fmt.Sprintf("    block%d -> %s;\n", block.Number, to))
+			}
+		}
+
+		if block.ComputedJump {
+			str.WriteString(fmt.Sprintf("    block%d -> exit [style=dotted, label=\"computed\"];\n", block.Number))
+		}
+	}
+
+	str.WriteString("}\n")
+
+	return str.String()
+}
+
+// Writes the graph out as JSON
+func (g *ControlFlowGraph) JSON() ([]byte, error) {
+	return json.MarshalIndent(g, "", "  ")
+}
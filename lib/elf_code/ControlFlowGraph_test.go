@@ -0,0 +1,136 @@
+package elf_code
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProgram_JumpAt(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(day19Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		ip            int
+		booleanInputs []int
+		want          JumpInfo
+	}{
+		{"Not a jump", 1, nil, JumpInfo{NoJump, []int{2}, false, -1}},
+		{"Relative", 0, nil, JumpInfo{Jump, []int{17}, true, -1}},
+		{"Absolute", 11, nil, JumpInfo{Jump, []int{3}, false, -1}},
+		{"Squared", 16, nil, JumpInfo{Jump, []int{257}, true, -1}},
+		{"Conditional", 5, nil, JumpInfo{ConditionalJump, []int{6, 7}, true, 4}},
+		{"Conditional register first", 10, nil, JumpInfo{ConditionalJump, []int{11, 12}, true, 4}},
+		{"Computed", 25, nil, JumpInfo{ComputedJump, nil, true, -1}},
+		{"Boolean input", 25, []int{0}, JumpInfo{ConditionalJump, []int{26, 27}, true, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cpu.Program.JumpAt(tt.ip, cpu.InstructionPointerRegister, tt.booleanInputs...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Program.JumpAt() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The jump at 3 lands on the jump at 5 with R[1] still holding 2, skipping the comparison at 4
+const jumpAfterComparisonTestProgram = "#ip 4\nseti 2 0 1\ngtri 0 3 2\naddr 2 4 4\nseti 4 0 4\ngtri 0 10 1\naddr 1 4 4\naddi 3 1 3\naddi 3 10 3\nseti 99 0 0"
+
+func TestProgram_JumpAt_JumpAfterComparison(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(jumpAfterComparisonTestProgram)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	want := JumpInfo{ComputedJump, nil, true, -1}
+	if got := cpu.Program.JumpAt(5, cpu.InstructionPointerRegister); !reflect.DeepEqual(got, want) {
+		t.Errorf("Program.JumpAt() = %+v, want %+v", got, want)
+	}
+
+	want = JumpInfo{ConditionalJump, []int{3, 4}, true, 2}
+	if got := cpu.Program.JumpAt(2, cpu.InstructionPointerRegister); !reflect.DeepEqual(got, want) {
+		t.Errorf("Program.JumpAt() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCPU_ControlFlowGraph(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(day21Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	g := cpu.ControlFlowGraph()
+	if len(g.Blocks) != 16 {
+		t.Fatalf("ControlFlowGraph() has %d blocks, want %d", len(g.Blocks), 16)
+	}
+
+	// The block checking R[0] against the generated number is the only way out of the program
+	want := BasicBlock{
+		Number:       14,
+		Start:        28,
+		End:          29,
+		Successors:   []int{15},
+		Predecessors: []int{7},
+		Exits:        true,
+		Reachable:    true,
+		Reads:        []int{0, 4},
+		Writes:       []int{1},
+	}
+	if got := *g.BlockAt(29); !reflect.DeepEqual(got, want) {
+		t.Errorf("ControlFlowGraph().BlockAt(29) = %+v, want %+v", got, want)
+	}
+
+	for _, block := range g.Blocks {
+		if !block.Reachable || block.ComputedJump {
+			t.Errorf("ControlFlowGraph() block %d reachable = %v, computed jump = %v", block.Number, block.Reachable, block.ComputedJump)
+		}
+	}
+
+	dot := g.DOT()
+	for _, edge := range []string{"block14 -> exit [label=\"R[1] == 1\"];", "block14 -> block15 [label=\"R[1] == 0\"];", "block15 -> block4;"} {
+		if !strings.Contains(dot, edge) {
+			t.Errorf("ControlFlowGraph.DOT() = %s\nwant it to contain %q", dot, edge)
+		}
+	}
+
+	encoded, err := g.JSON()
+	if err != nil {
+		t.Fatalf("ControlFlowGraph.JSON() error = %v", err)
+	}
+
+	var decoded ControlFlowGraph
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(decoded.Blocks, g.Blocks) {
+		t.Errorf("ControlFlowGraph.JSON() blocks did not round trip")
+	}
+}
+
+func TestCPU_ControlFlowGraph_BooleanInputs(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(day19Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	// Without knowing R[0] is a flag, the setup code jumps somewhere unknown
+	if g := cpu.ControlFlowGraph(); g.BlockAt(1).Reachable {
+		t.Errorf("ControlFlowGraph() main loop should be unreachable without boolean inputs")
+	}
+
+	g := cpu.ControlFlowGraph(0)
+	for _, block := range g.Blocks {
+		if !block.Reachable {
+			t.Errorf("ControlFlowGraph(0) block %d is unreachable", block.Number)
+		}
+	}
+
+	if got := g.BlockAt(25).Successors; !reflect.DeepEqual(got, []int{12, 13}) {
+		t.Errorf("ControlFlowGraph(0).BlockAt(25).Successors = %v, want %v", got, []int{12, 13})
+	}
+}
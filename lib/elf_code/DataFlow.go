@@ -0,0 +1,498 @@
+package elf_code
+
+// The definition used by `DataFlow.ReachingDefinitions` for the value a register held when the program started
+const EntryDefinition = -1
+
+// What constant propagation knows about a register
+type constantKind int
+
+const (
+	constantUndefined constantKind = iota // Nothing reaches here yet
+	constantKnown                         // Always holds the same value
+	constantVarying                       // Can hold different values
+)
+
+type constant struct {
+	kind  constantKind
+	value int
+}
+
+// Combines the values of a register from two paths
+func (c constant) meet(other constant) constant {
+	switch {
+	case c.kind == constantUndefined:
+		return other
+	case other.kind == constantUndefined:
+		return c
+	case c.kind == constantKnown && other.kind == constantKnown && c.value == other.value:
+		return c
+	default:
+		return constant{constantVarying, 0}
+	}
+}
+
+// The results of liveness, reaching definitions and constant propagation for every instruction of a program.
+// Execution is assumed to start at instruction 0 with unknown registers. Jumps computed from registers which
+// always hold the same value go to that target, other computed jumps are assumed to be able to go anywhere
+type DataFlow struct {
+	Program       Program  // The program analysed
+	IPRegister    int      // The register bound to the instruction pointer
+	NumRegisters  int      // The number of registers of the CPU
+	BooleanInputs []int    // The registers assumed to hold 0 or 1 when used in jumps, see `Program.JumpAt`
+	Word          WordSize // The word size constants are wrapped to
+
+	successors   [][]int // Instruction pointer => the instructions which can run next
+	predecessors [][]int // Instruction pointer => the instructions which can run before
+	escapes      []bool  // Instruction pointer => can execution halt or go somewhere unknown after it?
+	reachable    []bool  // Instruction pointer => can it be reached from the start of the program?
+	computed     []bool  // Instruction pointer => is it a jump computed from registers?
+
+	liveIn  [][]bool // Instruction pointer => registers read before they are written from here
+	liveOut [][]bool // Instruction pointer => registers read before they are written after here
+
+	reachingIn  [][]bool // Instruction pointer => definitions reaching here, see `definitionIndex`
+	reachingOut [][]bool // Instruction pointer => definitions reaching the next instruction
+
+	constantsIn  [][]constant // Instruction pointer => register values before the instruction
+	constantsOut [][]constant // Instruction pointer => register values after the instruction
+}
+
+// Analyses how the program loaded into the CPU uses its registers, see `Program.DataFlow`
+func (cpu *CPU) DataFlow(booleanInputs ...int) (flow *DataFlow, err error) {
+	return analyseDataFlow(cpu.Program, cpu.InstructionPointerRegister, len(cpu.Registers), cpu.Word, booleanInputs)
+}
+
+// Analyses how the program uses its registers without running it, with native words.
+// `booleanInputs` are registers which can be assumed to hold 0 or 1, see `Program.JumpAt`
+func (p Program) DataFlow(ipRegister int, numRegisters int, booleanInputs ...int) (flow *DataFlow, err error) {
+	return analyseDataFlow(p, ipRegister, numRegisters, WordSize{}, booleanInputs)
+}
+
+func analyseDataFlow(program Program, ipRegister int, numRegisters int, word WordSize, booleanInputs []int) (flow *DataFlow, err error) {
+	if err = program.Validate(numRegisters); err != nil {
+		return nil, err
+	}
+
+	flow = &DataFlow{
+		Program:       program,
+		IPRegister:    ipRegister,
+		NumRegisters:  numRegisters,
+		BooleanInputs: booleanInputs,
+		Word:          word,
+	}
+
+	flow.buildEdges()
+	flow.solveLiveness()
+	flow.solveReachingDefinitions()
+
+	return flow, nil
+}
+
+// Works out where execution can go after each instruction. Computed jumps start off going nowhere, then are
+// resolved with the constants reaching them until no more targets are found, so the constants are solved too
+func (d *DataFlow) buildEdges() {
+	d.successors = make([][]int, len(d.Program))
+	d.escapes = make([]bool, len(d.Program))
+	d.computed = make([]bool, len(d.Program))
+
+	for ip := range d.Program {
+		jump := d.Program.JumpAt(ip, d.IPRegister, d.BooleanInputs...)
+		d.computed[ip] = jump.Kind == ComputedJump
+
+		for _, target := range jump.Targets {
+			if target >= 0 && target < len(d.Program) {
+				d.successors[ip] = append(d.successors[ip], target)
+			} else {
+				d.escapes[ip] = true
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		d.buildPredecessors()
+		d.solveConstants()
+
+		changed = false
+		for ip := range d.Program {
+			if d.computed[ip] && d.reachable[ip] && d.resolveComputedJump(ip) {
+				changed = true
+			}
+		}
+	}
+}
+
+// Sets the targets of the computed jump from the constants reaching it, returning true if they changed. As
+// constants only ever go from unknown to known to varying, the targets only ever grow
+func (d *DataFlow) resolveComputedJump(ip int) (changed bool) {
+	successors := make([]int, 0, 1)
+	escapes := false
+
+	if value := d.evaluate(ip, d.constantsIn[ip]); value.kind == constantKnown {
+		if target := value.value + 1; target >= 0 && target < len(d.Program) {
+			successors = append(successors, target)
+		} else {
+			escapes = true
+		}
+	} else {
+		escapes = true
+		for target := range d.Program {
+			successors = append(successors, target)
+		}
+	}
+
+	if escapes == d.escapes[ip] && len(successors) == len(d.successors[ip]) {
+		return false
+	}
+
+	d.successors[ip] = successors
+	d.escapes[ip] = escapes
+	return true
+}
+
+func (d *DataFlow) buildPredecessors() {
+	d.predecessors = make([][]int, len(d.Program))
+	for ip, successors := range d.successors {
+		for _, target := range successors {
+			d.predecessors[target] = append(d.predecessors[target], ip)
+		}
+	}
+
+	d.reachable = make([]bool, len(d.Program))
+	if len(d.Program) > 0 {
+		d.markReachable(0)
+	}
+}
+
+func (d *DataFlow) markReachable(ip int) {
+	if d.reachable[ip] {
+		return
+	}
+
+	d.reachable[ip] = true
+	for _, next := range d.successors[ip] {
+		d.markReachable(next)
+	}
+}
+
+// Recomputes the result of instructions until nothing changes. `update` recomputes the result of a single
+// instruction, returning true if it changed so the instructions depending on it are recomputed
+func (d *DataFlow) solve(backward bool, update func(ip int) (changed bool)) {
+	worklist := make([]int, 0, len(d.Program))
+	queued := make([]bool, len(d.Program))
+
+	for i := range d.Program {
+		ip := i
+		if backward {
+			ip = len(d.Program) - 1 - i
+		}
+
+		worklist = append(worklist, ip)
+		queued[ip] = true
+	}
+
+	for len(worklist) > 0 {
+		ip := worklist[0]
+		worklist = worklist[1:]
+		queued[ip] = false
+
+		if !update(ip) {
+			continue
+		}
+
+		dependants := d.successors[ip]
+		if backward {
+			dependants = d.predecessors[ip]
+		}
+
+		for _, next := range dependants {
+			if !queued[next] {
+				worklist = append(worklist, next)
+				queued[next] = true
+			}
+		}
+	}
+}
+
+// Does the instruction read the register? Reads of the instruction pointer register are ignored,
+// as its value is always known
+func (d *DataFlow) reads(ip int, register int) bool {
+	instruction := d.Program[ip]
+	isImmediate := OpCodeInputType[instruction.OpCode]
+
+	return register != d.IPRegister &&
+		((!isImmediate.A && instruction.A == register) || (!isImmediate.B && instruction.B == register))
+}
+
+// Does the instruction write to a register other than the instruction pointer?
+func (d *DataFlow) writes(ip int) bool {
+	return d.Program[ip].C != d.IPRegister
+}
+
+// A register is live if its value could be read before it is next written to. When the program halts,
+// or jumps somewhere unknown, every register could be read
+func (d *DataFlow) solveLiveness() {
+	d.liveIn = make([][]bool, len(d.Program))
+	d.liveOut = make([][]bool, len(d.Program))
+	for ip := range d.Program {
+		d.liveIn[ip] = make([]bool, d.NumRegisters)
+		d.liveOut[ip] = make([]bool, d.NumRegisters)
+	}
+
+	d.solve(true, func(ip int) (changed bool) {
+		for register := range d.liveOut[ip] {
+			if register == d.IPRegister {
+				continue
+			}
+
+			live := d.escapes[ip]
+			for _, next := range d.successors[ip] {
+				live = live || d.liveIn[next][register]
+			}
+			d.liveOut[ip][register] = live
+
+			live = d.reads(ip, register) || (live && (register != d.Program[ip].C))
+			if live != d.liveIn[ip][register] {
+				d.liveIn[ip][register] = live
+				changed = true
+			}
+		}
+
+		return
+	})
+}
+
+// Definitions are numbered by the instruction pointer writing the value, with the values registers
+// held at the start of the program numbered after the instructions
+func (d *DataFlow) definitionIndex(definition int, register int) int {
+	if definition == EntryDefinition {
+		return len(d.Program) + register
+	}
+
+	return definition
+}
+
+// The register a definition writes to
+func (d *DataFlow) definitionRegister(index int) int {
+	if index >= len(d.Program) {
+		return index - len(d.Program)
+	}
+
+	return d.Program[index].C
+}
+
+// A definition reaches an instruction if the register could still hold the value written by it
+func (d *DataFlow) solveReachingDefinitions() {
+	numDefinitions := len(d.Program) + d.NumRegisters
+
+	d.reachingIn = make([][]bool, len(d.Program))
+	d.reachingOut = make([][]bool, len(d.Program))
+	for ip := range d.Program {
+		d.reachingIn[ip] = make([]bool, numDefinitions)
+		d.reachingOut[ip] = make([]bool, numDefinitions)
+	}
+
+	d.solve(false, func(ip int) (changed bool) {
+		in := d.reachingIn[ip]
+		for index := range in {
+			in[index] = ip == 0 && index >= len(d.Program) && d.definitionRegister(index) != d.IPRegister
+			for _, previous := range d.predecessors[ip] {
+				in[index] = in[index] || (d.reachable[previous] && d.reachingOut[previous][index])
+			}
+		}
+
+		for index, reaches := range in {
+			if d.writes(ip) && d.definitionRegister(index) == d.Program[ip].C {
+				reaches = index == ip
+			}
+
+			if reaches != d.reachingOut[ip][index] {
+				d.reachingOut[ip][index] = reaches
+				changed = true
+			}
+		}
+
+		return
+	})
+}
+
+// Works out which registers always hold the same value at each instruction
+func (d *DataFlow) solveConstants() {
+	d.constantsIn = make([][]constant, len(d.Program))
+	d.constantsOut = make([][]constant, len(d.Program))
+	for ip := range d.Program {
+		d.constantsIn[ip] = make([]constant, d.NumRegisters)
+		d.constantsOut[ip] = make([]constant, d.NumRegisters)
+	}
+
+	d.solve(false, func(ip int) (changed bool) {
+		in := d.constantsIn[ip]
+		for register := range in {
+			in[register] = constant{}
+			if ip == 0 {
+				in[register] = constant{constantVarying, 0}
+			}
+
+			for _, previous := range d.predecessors[ip] {
+				if d.reachable[previous] {
+					in[register] = in[register].meet(d.constantsOut[previous][register])
+				}
+			}
+		}
+
+		for register, value := range in {
+			if register == d.Program[ip].C && d.writes(ip) {
+				value = d.evaluate(ip, in)
+			}
+
+			if value != d.constantsOut[ip][register] {
+				d.constantsOut[ip][register] = value
+				changed = true
+			}
+		}
+
+		return
+	})
+}
+
+// Evaluates the instruction if all the registers it reads are constant
+func (d *DataFlow) evaluate(ip int, in []constant) constant {
+	instruction := d.Program[ip]
+	if instruction.OpCode.talksToHost() {
+		return constant{constantVarying, 0}
+	}
+
+	registers := NewRegisters(d.NumRegisters)
+	registers[d.IPRegister] = ip
+	for register, value := range in {
+		if !d.reads(ip, register) {
+			continue
+		}
+
+		if value.kind != constantKnown {
+			return value
+		}
+		registers[register] = value.value
+	}
+
+	value, err := OpCodeFunc[instruction.OpCode](instruction.A, instruction.B, registers)
+	if err != nil {
+		return constant{constantVarying, 0}
+	}
+
+	return constant{constantKnown, d.Word.Wrap(value)}
+}
+
+// Where execution can go after the instruction at `ip`, like `Program.JumpAt` but with the jumps computed
+// from registers which always hold the same value going to that target
+func (d *DataFlow) JumpAt(ip int) JumpInfo {
+	jump := d.Program.JumpAt(ip, d.IPRegister, d.BooleanInputs...)
+	if jump.Kind != ComputedJump || !d.reachable[ip] {
+		return jump
+	}
+
+	if value := d.evaluate(ip, d.constantsIn[ip]); value.kind == constantKnown {
+		return JumpInfo{Jump, []int{value.value + 1}, jump.Relative, -1}
+	}
+
+	return jump
+}
+
+// Builds the control flow graph of the program, with the computed jumps resolved by `DataFlow.JumpAt`
+func (d *DataFlow) ControlFlowGraph() *ControlFlowGraph {
+	return buildControlFlowGraph(d.Program, d.IPRegister, d.NumRegisters, d.BooleanInputs, d.JumpAt)
+}
+
+// Can the instruction be reached from the start of the program?
+func (d *DataFlow) Reachable(ip int) bool {
+	return d.reachable[ip]
+}
+
+// The registers whose values could be read from this instruction onwards, before they are overwritten
+func (d *DataFlow) LiveIn(ip int) []int {
+	return registerList(d.liveIn[ip])
+}
+
+// The registers whose values could be read after this instruction, before they are overwritten
+func (d *DataFlow) LiveOut(ip int) []int {
+	return registerList(d.liveOut[ip])
+}
+
+// Could the value of the register after this instruction be read?
+func (d *DataFlow) IsLiveAfter(ip int, register int) bool {
+	return d.liveOut[ip][register]
+}
+
+// Is the value written by the instruction always overwritten before it is read? Writes to the
+// instruction pointer, and op codes which talk to the host, are never dead
+func (d *DataFlow) IsDeadWrite(ip int) bool {
+	instruction := d.Program[ip]
+
+	return d.reachable[ip] && d.writes(ip) && !instruction.OpCode.talksToHost() && !d.liveOut[ip][instruction.C]
+}
+
+// The instructions whose writes to the register could be the value it holds before this instruction,
+// including `EntryDefinition` if it could still hold the value it started with
+func (d *DataFlow) ReachingDefinitions(ip int, register int) (definitions []int) {
+	definitions = make([]int, 0)
+
+	if d.reachingIn[ip][d.definitionIndex(EntryDefinition, register)] {
+		definitions = append(definitions, EntryDefinition)
+	}
+
+	for definition := range d.Program {
+		if d.reachingIn[ip][definition] && d.Program[definition].C == register {
+			definitions = append(definitions, definition)
+		}
+	}
+
+	return
+}
+
+// The instructions which could read the value written by the instruction at `definition`
+func (d *DataFlow) Uses(definition int) (uses []int) {
+	uses = make([]int, 0)
+	if !d.writes(definition) {
+		return
+	}
+
+	register := d.Program[definition].C
+	for ip := range d.Program {
+		if d.reads(ip, register) && d.reachingIn[ip][definition] {
+			uses = append(uses, ip)
+		}
+	}
+
+	return
+}
+
+// The value the register always holds before the instruction, if it is constant
+func (d *DataFlow) ConstantBefore(ip int, register int) (value int, isConstant bool) {
+	if register == d.IPRegister {
+		return ip, true
+	}
+
+	return d.constantsIn[ip][register].value, d.constantsIn[ip][register].kind == constantKnown
+}
+
+// The value the register always holds after the instruction, if it is constant. The instruction
+// pointer register is never constant here, as it could have been jumped
+func (d *DataFlow) ConstantAfter(ip int, register int) (value int, isConstant bool) {
+	if register == d.IPRegister {
+		return 0, false
+	}
+
+	return d.constantsOut[ip][register].value, d.constantsOut[ip][register].kind == constantKnown
+}
+
+// The registers set in a register set
+func registerList(set []bool) (registers []int) {
+	registers = make([]int, 0)
+
+	for register, isSet := range set {
+		if isSet {
+			registers = append(registers, register)
+		}
+	}
+
+	return
+}
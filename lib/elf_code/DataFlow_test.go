@@ -0,0 +1,143 @@
+package elf_code
+
+import (
+	"reflect"
+	"testing"
+)
+
+// R[0] = 3 * (R[0] > 6 ? 7 : 8), with a dead write to R[2] at 1
+const dataFlowTestProgram = `#ip 5
+seti 3 0 1
+seti 4 0 2
+addr 1 1 3
+seti 7 0 2
+gtrr 0 3 4
+addr 4 5 5
+addi 2 1 2
+mulr 2 1 0`
+
+func newDataFlowTest(t *testing.T, program string) *DataFlow {
+	cpu, err := NewCPUFromProgramFile(program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	flow, err := cpu.DataFlow()
+	if err != nil {
+		t.Fatalf("CPU.DataFlow() error = %v", err)
+	}
+
+	return flow
+}
+
+func TestDataFlow_Liveness(t *testing.T) {
+	flow := newDataFlowTest(t, dataFlowTestProgram)
+
+	for ip := range flow.Program {
+		if got, want := flow.IsDeadWrite(ip), ip == 1; got != want {
+			t.Errorf("DataFlow.IsDeadWrite(%d) = %v, want %v", ip, got, want)
+		}
+	}
+
+	tests := []struct {
+		name string
+		got  []int
+		want []int
+	}{
+		{"LiveIn(0)", flow.LiveIn(0), []int{0}},
+		{"LiveOut(4)", flow.LiveOut(4), []int{1, 2, 3, 4}},
+		{"LiveOut(7)", flow.LiveOut(7), []int{0, 1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("DataFlow.%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestDataFlow_ReachingDefinitions(t *testing.T) {
+	flow := newDataFlowTest(t, dataFlowTestProgram)
+
+	tests := []struct {
+		ip       int
+		register int
+		want     []int
+	}{
+		{7, 2, []int{3, 6}},
+		{7, 1, []int{0}},
+		{4, 0, []int{EntryDefinition}},
+		{2, 2, []int{1}},
+	}
+	for _, tt := range tests {
+		if got := flow.ReachingDefinitions(tt.ip, tt.register); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("DataFlow.ReachingDefinitions(%d, %d) = %v, want %v", tt.ip, tt.register, got, tt.want)
+		}
+	}
+
+	if got := flow.Uses(0); !reflect.DeepEqual(got, []int{2, 7}) {
+		t.Errorf("DataFlow.Uses(0) = %v, want [2 7]", got)
+	}
+	if got := flow.Uses(1); !reflect.DeepEqual(got, []int{}) {
+		t.Errorf("DataFlow.Uses(1) = %v, want []", got)
+	}
+}
+
+func TestDataFlow_Constants(t *testing.T) {
+	flow := newDataFlowTest(t, dataFlowTestProgram)
+
+	tests := []struct {
+		name         string
+		constant     func(ip int, register int) (int, bool)
+		ip           int
+		register     int
+		want         int
+		wantConstant bool
+	}{
+		{"Before", flow.ConstantBefore, 4, 3, 6, true},
+		{"Before", flow.ConstantBefore, 7, 1, 3, true},
+		{"Before", flow.ConstantBefore, 7, 2, 0, false},
+		{"Before", flow.ConstantBefore, 4, 0, 0, false},
+		{"Before", flow.ConstantBefore, 5, 5, 5, true},
+		{"After", flow.ConstantAfter, 6, 2, 8, true},
+		{"After", flow.ConstantAfter, 5, 5, 0, false},
+	}
+	for _, tt := range tests {
+		if got, isConstant := tt.constant(tt.ip, tt.register); got != tt.want || isConstant != tt.wantConstant {
+			t.Errorf("DataFlow.Constant%s(%d, %d) = %v, %v, want %v, %v", tt.name, tt.ip, tt.register, got, isConstant, tt.want, tt.wantConstant)
+		}
+	}
+}
+
+func TestDataFlow_Loops(t *testing.T) {
+	// R[1] counts up forever, while R[2] is always 8 bits of 300
+	flow := newDataFlowTest(t, "#ip 5\n#word 8 unsigned\nseti 0 0 1\nseti 300 0 2\naddi 1 1 1\nseti 1 0 5")
+
+	if _, isConstant := flow.ConstantBefore(2, 1); isConstant {
+		t.Errorf("DataFlow.ConstantBefore(2, 1) is constant, want varying")
+	}
+	if got, isConstant := flow.ConstantBefore(2, 2); got != 44 || !isConstant {
+		t.Errorf("DataFlow.ConstantBefore(2, 2) = %v, %v, want 44, true", got, isConstant)
+	}
+	if got := flow.ReachingDefinitions(2, 1); !reflect.DeepEqual(got, []int{0, 2}) {
+		t.Errorf("DataFlow.ReachingDefinitions(2, 1) = %v, want [0 2]", got)
+	}
+
+	// Computed jumps could go anywhere, so everything after them is live
+	flow = newDataFlowTest(t, "#ip 5\nseti 1 0 1\nseti 2 0 2\naddr 0 5 5\nseti 3 0 1")
+	if !reflect.DeepEqual(flow.LiveOut(2), []int{0, 1, 2, 3, 4}) || flow.IsDeadWrite(0) {
+		t.Errorf("DataFlow.LiveOut(2) = %v, IsDeadWrite(0) = %v, want all registers live", flow.LiveOut(2), flow.IsDeadWrite(0))
+	}
+
+	// Unless the registers they are computed from are constant
+	flow = newDataFlowTest(t, "#ip 5\nseti 1 0 1\naddr 1 5 5\nseti 0 0 0\nseti 1 0 0")
+	if flow.Reachable(2) || !flow.Reachable(3) || flow.IsDeadWrite(3) {
+		t.Errorf("DataFlow.Reachable(2) = %v, Reachable(3) = %v, IsDeadWrite(3) = %v, want the jump to skip 2", flow.Reachable(2), flow.Reachable(3), flow.IsDeadWrite(3))
+	}
+	if got := flow.ReachingDefinitions(3, 0); !reflect.DeepEqual(got, []int{EntryDefinition}) {
+		t.Errorf("DataFlow.ReachingDefinitions(3, 0) = %v, want [%d]", got, EntryDefinition)
+	}
+
+	if _, err := (Program{{AddR, 9, 0, 0}}).DataFlow(5, 6); err == nil {
+		t.Errorf("Program.DataFlow() with an invalid register, want error")
+	}
+}
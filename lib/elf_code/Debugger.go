@@ -0,0 +1,193 @@
+package elf_code
+
+import (
+	"errors"
+	"sort"
+)
+
+// Why the debugger stopped executing the program
+type StopReason int
+
+const (
+	StepComplete  StopReason = iota // A single instruction was executed
+	BreakpointHit                   // The instruction pointer reached a breakpoint
+	WatchpointHit                   // A watched register met its condition
+	ProgramHalted                   // The instruction pointer has left the program
+)
+
+func (s StopReason) String() string {
+	switch s {
+	case StepComplete:
+		return "step complete"
+	case BreakpointHit:
+		return "breakpoint hit"
+	case WatchpointHit:
+		return "watchpoint hit"
+	case ProgramHalted:
+		return "program halted"
+	default:
+		return "unknown stop reason"
+	}
+}
+
+// A condition on a watched register, given its value before and after an instruction was executed
+type WatchCondition = func(previous int, current int) bool
+
+// Fires whenever the watched register changes value
+func RegisterChanged(previous int, current int) bool {
+	return previous != current
+}
+
+// Fires when the watched register starts to match the given `predicate`
+func RegisterMatches(predicate func(value int) bool) WatchCondition {
+	return func(previous int, current int) bool {
+		return predicate(current) && !predicate(previous)
+	}
+}
+
+// Fires when the watched register is set to `value`
+func RegisterEquals(value int) WatchCondition {
+	return RegisterMatches(func(v int) bool { return v == value })
+}
+
+// A watch on a register of the CPU
+type Watchpoint struct {
+	ID        int            // The ID of the watchpoint
+	Register  int            // The register being watched
+	Condition WatchCondition // The condition which stops the debugger
+}
+
+// A debugger which controls the execution of a CPU
+type Debugger struct {
+	CPU                  *CPU        // The CPU being debugged
+	InstructionsExecuted int         // The number of instructions executed by the debugger
+	LastWatchpoint       *Watchpoint // The watchpoint which caused the last WatchpointHit (if any)
+
+	breakpoints      map[int]bool  // The instruction pointers to stop on
+	watchpoints      []*Watchpoint // The registers being watched
+	nextWatchpointID int
+}
+
+// Creates a new debugger for the given CPU
+func NewDebugger(cpu *CPU) *Debugger {
+	return &Debugger{
+		CPU:              cpu,
+		breakpoints:      make(map[int]bool),
+		watchpoints:      make([]*Watchpoint, 0),
+		nextWatchpointID: 1,
+	}
+}
+
+// The current instruction pointer of the CPU
+func (d *Debugger) IP() int {
+	return d.CPU.Registers[d.CPU.InstructionPointerRegister]
+}
+
+// Stop execution when the instruction pointer reaches `ip`
+func (d *Debugger) SetBreakpoint(ip int) {
+	d.breakpoints[ip] = true
+}
+
+// Removes the breakpoint on `ip`, returning if there was one
+func (d *Debugger) ClearBreakpoint(ip int) bool {
+	_, found := d.breakpoints[ip]
+	delete(d.breakpoints, ip)
+	return found
+}
+
+// All instruction pointers with breakpoints on, in order
+func (d *Debugger) Breakpoints() []int {
+	result := make([]int, 0, len(d.breakpoints))
+	for ip := range d.breakpoints {
+		result = append(result, ip)
+	}
+	sort.Ints(result)
+
+	return result
+}
+
+// Stop execution when the `condition` is met by the given register
+func (d *Debugger) Watch(register int, condition WatchCondition) (watchpoint *Watchpoint, err error) {
+	if register < 0 || register >= len(d.CPU.Registers) {
+		return nil, errors.New("register index out of bounds")
+	}
+
+	if condition == nil {
+		condition = RegisterChanged
+	}
+
+	watchpoint = &Watchpoint{d.nextWatchpointID, register, condition}
+	d.nextWatchpointID++
+	d.watchpoints = append(d.watchpoints, watchpoint)
+
+	return
+}
+
+// Removes the watchpoint with the given ID, returning if it existed
+func (d *Debugger) Unwatch(id int) bool {
+	for i, watchpoint := range d.watchpoints {
+		if watchpoint.ID == id {
+			d.watchpoints = append(d.watchpoints[:i], d.watchpoints[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// All the current watchpoints
+func (d *Debugger) Watchpoints() []*Watchpoint {
+	result := make([]*Watchpoint, len(d.watchpoints))
+	copy(result, d.watchpoints)
+	return result
+}
+
+// Executes a single instruction. Unlike `CPU.Execute` the instruction pointer is left
+// outside of the program once it halts.
+func (d *Debugger) Step() (reason StopReason, err error) {
+	d.LastWatchpoint = nil
+
+	if d.CPU.Halted() {
+		return ProgramHalted, nil
+	}
+
+	// Record the watched values before the instruction runs
+	previous := make([]int, len(d.watchpoints))
+	for i, watchpoint := range d.watchpoints {
+		previous[i] = d.CPU.Registers[watchpoint.Register]
+	}
+
+	err = d.CPU.Step()
+	if err != nil {
+		return
+	}
+	d.InstructionsExecuted++
+
+	for i, watchpoint := range d.watchpoints {
+		if watchpoint.Condition(previous[i], d.CPU.Registers[watchpoint.Register]) {
+			d.LastWatchpoint = watchpoint
+			return WatchpointHit, nil
+		}
+	}
+
+	if d.CPU.Halted() {
+		return ProgramHalted, nil
+	}
+
+	if d.breakpoints[d.IP()] {
+		return BreakpointHit, nil
+	}
+
+	return StepComplete, nil
+}
+
+// Resumes execution until a breakpoint or watchpoint is hit, or the program halts.
+// At least one instruction is always executed, so continuing from a breakpoint moves past it.
+func (d *Debugger) Continue() (reason StopReason, err error) {
+	for {
+		reason, err = d.Step()
+		if err != nil || reason != StepComplete {
+			return
+		}
+	}
+}
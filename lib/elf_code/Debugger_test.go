@@ -0,0 +1,112 @@
+package elf_code
+
+import (
+	"reflect"
+	"testing"
+)
+
+const debuggerTestProgram = `#ip 0
+seti 5 0 1
+seti 6 0 2
+addi 0 1 0
+addr 1 2 3
+setr 1 0 0
+seti 8 0 4
+seti 9 0 5`
+
+func newDebuggerForTest(t *testing.T) *Debugger {
+	cpu, err := NewCPUFromProgramFile(debuggerTestProgram)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	return NewDebugger(cpu)
+}
+
+func TestDebugger_Step(t *testing.T) {
+	d := newDebuggerForTest(t)
+
+	// The jump at IP 2 skips IP 3, and the jump at IP 4 skips IP 5
+	wantIPs := []int{1, 2, 4, 6}
+	for _, want := range wantIPs {
+		reason, err := d.Step()
+		if err != nil {
+			t.Fatalf("Debugger.Step() error = %v", err)
+		}
+
+		if reason != StepComplete {
+			t.Errorf("Debugger.Step() reason = %v, want %v", reason, StepComplete)
+		}
+
+		if d.IP() != want {
+			t.Errorf("Debugger.Step() IP = %v, want %v", d.IP(), want)
+		}
+	}
+
+	reason, err := d.Step()
+	if err != nil || reason != ProgramHalted {
+		t.Errorf("Debugger.Step() = %v, %v, want %v", reason, err, ProgramHalted)
+	}
+
+	want := Registers{7, 5, 6, 0, 0, 9}
+	if !reflect.DeepEqual(d.CPU.Registers, want) {
+		t.Errorf("Debugger.Step() registers = %v, want %v", d.CPU.Registers, want)
+	}
+}
+
+func TestDebugger_Breakpoints(t *testing.T) {
+	d := newDebuggerForTest(t)
+	d.SetBreakpoint(4)
+	d.SetBreakpoint(3) // Never reached
+
+	reason, err := d.Continue()
+	if err != nil || reason != BreakpointHit || d.IP() != 4 {
+		t.Errorf("Debugger.Continue() = %v, %v at IP %d, want %v at IP 4", reason, err, d.IP(), BreakpointHit)
+	}
+
+	if !reflect.DeepEqual(d.Breakpoints(), []int{3, 4}) {
+		t.Errorf("Debugger.Breakpoints() = %v, want %v", d.Breakpoints(), []int{3, 4})
+	}
+
+	// Resuming moves past the breakpoint we are sat on
+	reason, err = d.Continue()
+	if err != nil || reason != ProgramHalted {
+		t.Errorf("Debugger.Continue() = %v, %v, want %v", reason, err, ProgramHalted)
+	}
+
+	if d.InstructionsExecuted != 5 {
+		t.Errorf("Debugger.InstructionsExecuted = %v, want %v", d.InstructionsExecuted, 5)
+	}
+}
+
+func TestDebugger_Watchpoints(t *testing.T) {
+	d := newDebuggerForTest(t)
+
+	changed, err := d.Watch(2, RegisterChanged)
+	if err != nil {
+		t.Fatalf("Debugger.Watch() error = %v", err)
+	}
+
+	equals, err := d.Watch(5, RegisterEquals(9))
+	if err != nil {
+		t.Fatalf("Debugger.Watch() error = %v", err)
+	}
+
+	reason, err := d.Continue()
+	if err != nil || reason != WatchpointHit || d.LastWatchpoint != changed || d.IP() != 2 {
+		t.Errorf("Debugger.Continue() = %v, %v at IP %d, want R[2] watchpoint at IP 2", reason, err, d.IP())
+	}
+
+	reason, err = d.Continue()
+	if err != nil || reason != WatchpointHit || d.LastWatchpoint != equals {
+		t.Errorf("Debugger.Continue() = %v, %v, want R[5] watchpoint", reason, err)
+	}
+
+	if !d.Unwatch(changed.ID) || d.Unwatch(changed.ID) {
+		t.Errorf("Debugger.Unwatch() should only remove the watchpoint once")
+	}
+
+	if _, err := d.Watch(6, nil); err == nil {
+		t.Errorf("Debugger.Watch() on an out of range register should error")
+	}
+}
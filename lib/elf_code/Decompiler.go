@@ -0,0 +1,1224 @@
+package elf_code
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// What a node of a decompiled program does
+type ASTKind int
+
+const (
+	ASTStatement ASTKind = iota // Runs a single instruction
+	ASTIf                       // Runs the body when the condition holds, otherwise the else body
+	ASTWhile                    // Runs the body while the condition holds, checking before each iteration
+	ASTDoWhile                  // Runs the body while the condition holds, checking after each iteration
+	ASTLoop                     // Runs the body until it breaks out of the loop
+	ASTBreak                    // Leaves the innermost loop
+	ASTContinue                 // Starts the next iteration of the innermost loop
+	ASTHalt                     // Stops the program
+	ASTGoto                     // Jumps to a label, only used for control flow which can't be structured
+	ASTLabel                    // Where a goto jumps to
+	ASTDispatch                 // Runs the blocks in its body, jumping between their labels with gotos, see `DecompiledProgram.Dispatch`
+)
+
+func (k ASTKind) String() string {
+	switch k {
+	case ASTStatement:
+		return "statement"
+	case ASTIf:
+		return "if"
+	case ASTWhile:
+		return "while"
+	case ASTDoWhile:
+		return "do-while"
+	case ASTLoop:
+		return "loop"
+	case ASTBreak:
+		return "break"
+	case ASTContinue:
+		return "continue"
+	case ASTHalt:
+		return "halt"
+	case ASTGoto:
+		return "goto"
+	case ASTLabel:
+		return "label"
+	case ASTDispatch:
+		return "dispatch"
+	default:
+		return "unknown"
+	}
+}
+
+// A node of a decompiled program
+type ASTNode struct {
+	Kind        ASTKind
+	IP          int          // The instruction the node comes from
+	Instruction Instruction  // The instruction of statements, with reads of the instruction pointer replaced by constants
+	Condition   ASTCondition // The condition of ifs and while loops
+	Body        []*ASTNode   // The body of loops, or what an if runs when the condition holds
+	Else        []*ASTNode   // What an if runs when the condition doesn't hold
+	Label       int          // The instruction pointer gotos and labels refer to, or the header of a loop
+	Labelled    bool         // Does the break or continue leave a loop other than the innermost one, or is the loop left that way?
+	Copy        int          // Tells apart labelled copies of the same loop, so every label is only used once
+}
+
+// The condition of an if or loop, which holds when the comparison is true (or false when negated)
+type ASTCondition struct {
+	Comparison Instruction // A comparison, output C is ignored
+	Negated    bool
+}
+
+// Tests whether `register` holds 1, for conditions on registers which weren't set by a comparison just before the jump
+func registerCondition(register int) ASTCondition {
+	return ASTCondition{Instruction{EqRI, register, 1, register}, false}
+}
+
+// The opposite condition
+func (c ASTCondition) Not() ASTCondition {
+	c.Negated = !c.Negated
+	return c
+}
+
+// Writes the condition as an expression, such as `R[1] > 5`
+func (c ASTCondition) String() string {
+	opCode := c.Comparison.OpCode
+	isImmediate := OpCodeInputType[opCode]
+	a := transpiledOperand(c.Comparison.A, isImmediate.A)
+	b := transpiledOperand(c.Comparison.B, isImmediate.B)
+
+	if registered, found := registeredOpCodes[opCode]; found {
+		if c.Negated {
+			return "!(" + registered.expression(a, b) + ")"
+		}
+		return registered.expression(a, b)
+	}
+
+	operator := " == "
+	switch {
+	case opCode >= GtIR && opCode <= GtRR && c.Negated:
+		operator = " <= "
+	case opCode >= GtIR && opCode <= GtRR:
+		operator = " > "
+	case c.Negated:
+		operator = " != "
+	}
+
+	return a + operator + b
+}
+
+// A program turned into structured control flow by `Program.Decompile`
+type DecompiledProgram struct {
+	Program      Program    // The program which was decompiled
+	IPRegister   int        // The register the program uses as its instruction pointer
+	NumRegisters int        // The number of registers the program runs with
+	Body         []*ASTNode // The structured program
+	Gotos        int        // How many gotos were needed for control flow which couldn't be structured
+	Dispatch     *ASTNode   // The program as a loop which jumps between its blocks, for backends without gotos. Only set when there are gotos
+}
+
+// Decompiles the program loaded into the CPU, see `Program.Decompile`
+func (cpu *CPU) Decompile(booleanInputs ...int) (*DecompiledProgram, error) {
+	return cpu.Program.Decompile(cpu.InstructionPointerRegister, len(cpu.Registers), booleanInputs...)
+}
+
+// Turns the program into nested ifs and loops. Reducible control flow is written with `while`, `do-while`,
+// `if-else` and `break`, with gotos only used for the regions which can't be. Programs with jumps computed from
+// registers which don't always hold the same value can't be decompiled. `booleanInputs` are registers which can
+// be assumed to hold 0 or 1, see `Program.JumpAt`
+func (p Program) Decompile(ipRegister int, numRegisters int, booleanInputs ...int) (decompiled *DecompiledProgram, err error) {
+	if len(p) == 0 {
+		return nil, errors.New("unable to decompile an empty program")
+	}
+
+	if ip, found := p.findHostOpCode(); found {
+		return nil, fmt.Errorf("unable to decompile %v at %d, as it talks to the host", p[ip].OpCode, ip)
+	}
+
+	flow, err := p.DataFlow(ipRegister, numRegisters, booleanInputs...)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := flow.ControlFlowGraph()
+	for _, block := range graph.Blocks {
+		if block.Reachable && block.ComputedJump {
+			return nil, fmt.Errorf("unable to decompile computed jump at %d", block.End)
+		}
+	}
+
+	d := &decompiler{
+		program:    p,
+		ipRegister: ipRegister,
+		flow:       flow,
+		graph:      graph,
+		exitNode:   len(graph.Blocks),
+		labels:     make(map[int]bool),
+	}
+	d.buildSuccessors()
+	d.findDominators()
+	d.findLoops()
+	d.findPostDominators()
+
+	// Gotos to blocks written before the goto are only found once the block has been written,
+	// so the program is written again with the labels they need
+	body := d.emit()
+	if len(d.labels) > 0 {
+		body = d.emit()
+	}
+
+	decompiled = &DecompiledProgram{
+		Program:      append(make(Program, 0, len(p)), p...),
+		IPRegister:   ipRegister,
+		NumRegisters: numRegisters,
+		Body:         tidyNodes(body, true),
+	}
+
+	decompiled.Walk(func(node *ASTNode) {
+		if node.Kind == ASTGoto {
+			decompiled.Gotos++
+		}
+	})
+	labelLoops(decompiled.Body, nil, make(map[int]int))
+
+	if decompiled.Gotos > 0 {
+		decompiled.Dispatch = d.emitDispatch()
+	}
+
+	return
+}
+
+// Calls `visit` with every node in the program, parents before their children
+func (d *DecompiledProgram) Walk(visit func(node *ASTNode)) {
+	walkNodes(d.Body, visit)
+}
+
+func walkNodes(nodes []*ASTNode, visit func(node *ASTNode)) {
+	for _, node := range nodes {
+		visit(node)
+		walkNodes(node.Body, visit)
+		walkNodes(node.Else, visit)
+	}
+}
+
+// Labels the loops which are broken out of or continued from inside another loop. Loops which were
+// written out more than once get a different copy number each, as every label is only used once.
+func labelLoops(nodes []*ASTNode, loops []*ASTNode, copies map[int]int) {
+	for _, node := range nodes {
+		switch node.Kind {
+		case ASTWhile, ASTDoWhile, ASTLoop:
+			node.Labelled = false
+			labelLoops(node.Body, append(loops, node), copies)
+
+		case ASTBreak, ASTContinue:
+			if !node.Labelled {
+				break
+			}
+
+			for i := len(loops) - 1; i >= 0; i-- {
+				if loop := loops[i]; loop.Label == node.Label {
+					if !loop.Labelled {
+						loop.Labelled = true
+						loop.Copy = copies[loop.Label]
+						copies[loop.Label]++
+					}
+					node.Copy = loop.Copy
+					break
+				}
+			}
+
+		default:
+			labelLoops(node.Body, loops, copies)
+			labelLoops(node.Else, loops, copies)
+		}
+	}
+}
+
+// A comment pointing back at the original instruction
+func (d *DecompiledProgram) ipComment(ip int) string {
+	return fmt.Sprintf("IP: %d (%s)", ip, d.Program[ip])
+}
+
+// How often a block can be written out before later paths to it use a goto instead
+const maxBlockCopies = 4
+
+// A sentinel for "no block", such as the follow of a loop without any exits
+const noNode = -1
+
+type decompiler struct {
+	program    Program
+	ipRegister int
+	flow       *DataFlow
+	graph      *ControlFlowGraph
+	exitNode   int // The node standing in for halting, after all the blocks
+
+	successors [][]int        // The successors of each block, false first for conditional jumps
+	conditions []ASTCondition // The condition the true successor of each conditional block is taken on
+	folded     []bool         // Is the comparison before the jump part of the condition?
+
+	dominators     [][]bool // The blocks which dominate each block
+	postDominators []int    // The immediate post dominator of each node
+	loops          map[int]*decompiledLoop
+
+	labels  map[int]bool // The blocks gotos jump to
+	written []int        // How many times each block has been written
+	onPath  []bool       // Blocks being written by the regions above the current one
+}
+
+// A natural loop, found from the back edges to its header
+type decompiledLoop struct {
+	kind    ASTKind
+	header  int
+	body    []bool
+	latches []int
+	exit    int // The block after the loop, which breaks jump to
+}
+
+// Where breaks and continues go while writing the body of a loop
+type loopContext struct {
+	header int
+	exit   int
+	body   []bool // nil for everything
+	parent *loopContext
+	escape bool // Writing an exit from a loop inline, so jumps to the header or exit need gotos
+}
+
+func (d *decompiler) inBody(ctx *loopContext, node int) bool {
+	return ctx == nil || ctx.body == nil || (node != noNode && node != d.exitNode && ctx.body[node])
+}
+
+func (d *decompiler) node(ip int) int {
+	if ip < 0 || ip >= len(d.program) {
+		return d.exitNode
+	}
+	return d.graph.BlockAt(ip).Number
+}
+
+// The first instruction of the node, which is past the end of the program for the exit
+func (d *decompiler) startOf(node int) int {
+	if node == d.exitNode {
+		return len(d.program)
+	}
+	return d.graph.Blocks[node].Start
+}
+
+func (d *decompiler) reachable(node int) bool {
+	return node == d.exitNode || d.graph.Blocks[node].Reachable
+}
+
+func (d *decompiler) buildSuccessors() {
+	d.successors = make([][]int, len(d.graph.Blocks))
+	d.conditions = make([]ASTCondition, len(d.graph.Blocks))
+	d.folded = make([]bool, len(d.graph.Blocks))
+
+	for _, block := range d.graph.Blocks {
+		jump := d.flow.JumpAt(block.End)
+
+		switch jump.Kind {
+		case NoJump:
+			d.successors[block.Number] = []int{d.node(block.End + 1)}
+		case Jump:
+			d.successors[block.Number] = []int{d.node(jump.Targets[0])}
+		case ConditionalJump:
+			d.successors[block.Number] = []int{d.node(jump.Targets[0]), d.node(jump.Targets[1])}
+			d.conditions[block.Number] = registerCondition(jump.ConditionRegister)
+
+			// Use the comparison before the jump as the condition, and drop its statement when nothing else reads the result
+			if prev := block.End - 1; prev >= block.Start {
+				comparison := d.withConstantIP(prev)
+				isImmediate := OpCodeInputType[comparison.OpCode]
+				overwritesInput := (!isImmediate.A && comparison.A == comparison.C) || (!isImmediate.B && comparison.B == comparison.C)
+
+				isDead := !d.flow.IsLiveAfter(block.End, jump.ConditionRegister)
+
+				if comparison.C == jump.ConditionRegister && comparison.OpCode.isComparator() && (isDead || !overwritesInput) {
+					d.conditions[block.Number] = ASTCondition{comparison, false}
+					d.folded[block.Number] = isDead
+				}
+			}
+		}
+	}
+}
+
+// Returns the instruction with reads of the instruction pointer replaced by its value
+func (d *decompiler) withConstantIP(ip int) (instruction Instruction) {
+	instruction = d.program[ip]
+	isImmediate := OpCodeInputType[instruction.OpCode]
+	readsA := !isImmediate.A && instruction.A == d.ipRegister
+	readsB := !isImmediate.B && instruction.B == d.ipRegister && instruction.OpCode != SetR
+
+	if !readsA && !readsB {
+		return
+	}
+
+	// Without any other inputs, the instruction always writes the same value
+	if (readsA || isImmediate.A) && (readsB || isImmediate.B || instruction.OpCode == SetR) {
+		registers := NewRegisters(d.ipRegister + 1)
+		registers[d.ipRegister] = ip
+		if value, err := OpCodeFunc[instruction.OpCode](instruction.A, instruction.B, registers); err == nil {
+			return Instruction{SetI, value, 0, instruction.C}
+		}
+		return
+	}
+
+	if readsA {
+		if swapped, found := constantAVersion[instruction.OpCode]; found {
+			instruction.OpCode = swapped
+			instruction.A = ip
+		} else if isCommutative(instruction.OpCode) {
+			instruction.OpCode = OpCodeImmedateVersion[instruction.OpCode]
+			instruction.A, instruction.B = instruction.B, ip
+		}
+		return
+	}
+
+	if immediate, found := OpCodeImmedateVersion[instruction.OpCode]; found {
+		instruction.OpCode = immediate
+		instruction.B = ip
+	}
+	return
+}
+
+// Works out the blocks which dominate each block, using the iterative data flow algorithm
+func (d *decompiler) findDominators() {
+	numBlocks := len(d.graph.Blocks)
+	predecessors := make([][]int, numBlocks)
+	for node, successors := range d.successors {
+		if !d.reachable(node) {
+			continue
+		}
+		for _, successor := range successors {
+			if successor != d.exitNode {
+				predecessors[successor] = append(predecessors[successor], node)
+			}
+		}
+	}
+
+	d.dominators = make([][]bool, numBlocks)
+	for node := range d.dominators {
+		d.dominators[node] = make([]bool, numBlocks)
+		for other := range d.dominators[node] {
+			d.dominators[node][other] = node != 0
+		}
+	}
+	d.dominators[0][0] = true
+
+	for changed := true; changed; {
+		changed = false
+
+		for node := 1; node < numBlocks; node++ {
+			if !d.reachable(node) {
+				continue
+			}
+
+			for other := 0; other < numBlocks; other++ {
+				dominates := other == node
+				if !dominates {
+					dominates = true
+					for _, predecessor := range predecessors[node] {
+						dominates = dominates && d.dominators[predecessor][other]
+					}
+				}
+
+				if dominates != d.dominators[node][other] {
+					d.dominators[node][other] = dominates
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+// Works out the immediate post dominator of each node, which is where the branches of an if join back
+// together. Loops which never halt are treated as if their header could, so branches can join at them
+func (d *decompiler) findPostDominators() {
+	numNodes := d.exitNode + 1
+	reachesExit := d.reachesExit(nil)
+
+	neverHalts := make([]bool, numNodes)
+	for header := range d.loops {
+		neverHalts[header] = !reachesExit[header]
+	}
+	reachesExit = d.reachesExit(neverHalts)
+
+	successors := func(node int) []int {
+		switch {
+		case node == d.exitNode:
+			return nil
+		case neverHalts[node]:
+			return append([]int{d.exitNode}, d.successors[node]...)
+		default:
+			return d.successors[node]
+		}
+	}
+
+	postDominators := make([][]bool, numNodes)
+	for node := range postDominators {
+		postDominators[node] = make([]bool, numNodes)
+		for other := range postDominators[node] {
+			postDominators[node][other] = node != d.exitNode || other == d.exitNode
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for node := d.exitNode - 1; node >= 0; node-- {
+			if !reachesExit[node] {
+				continue
+			}
+
+			for other := 0; other < numNodes; other++ {
+				postDominates := other == node
+				if !postDominates {
+					postDominates = true
+					for _, successor := range successors(node) {
+						postDominates = postDominates && (!reachesExit[successor] || postDominators[successor][other])
+					}
+				}
+
+				if postDominates != postDominators[node][other] {
+					postDominators[node][other] = postDominates
+					changed = true
+				}
+			}
+		}
+	}
+
+	// The immediate post dominator is the closest one, which is post dominated by all of the others
+	d.postDominators = make([]int, numNodes)
+	for node := range d.postDominators {
+		d.postDominators[node] = noNode
+		if !reachesExit[node] || node == d.exitNode {
+			continue
+		}
+
+		closest := 0
+		for other := 0; other < numNodes; other++ {
+			if other == node || !postDominators[node][other] {
+				continue
+			}
+
+			count := 0
+			for _, postDominates := range postDominators[other] {
+				if postDominates {
+					count++
+				}
+			}
+
+			if count > closest {
+				closest = count
+				d.postDominators[node] = other
+			}
+		}
+	}
+}
+
+// Finds the nodes which can reach the exit, with `halts` nodes treated as if they could
+func (d *decompiler) reachesExit(halts []bool) (reaches []bool) {
+	reaches = make([]bool, d.exitNode+1)
+	copy(reaches, halts)
+	reaches[d.exitNode] = true
+
+	for changed := true; changed; {
+		changed = false
+		for node := 0; node < d.exitNode; node++ {
+			for _, successor := range d.successors[node] {
+				if reaches[successor] && !reaches[node] {
+					reaches[node] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return
+}
+
+// Finds the natural loops from the back edges, which are edges to a block that dominates the edge's source
+func (d *decompiler) findLoops() {
+	d.loops = make(map[int]*decompiledLoop)
+
+	for node, successors := range d.successors {
+		if !d.reachable(node) {
+			continue
+		}
+
+		for _, header := range successors {
+			if header == d.exitNode || !d.dominators[node][header] {
+				continue
+			}
+
+			loop, found := d.loops[header]
+			if !found {
+				loop = &decompiledLoop{header: header, body: make([]bool, len(d.graph.Blocks))}
+				loop.body[header] = true
+				d.loops[header] = loop
+			}
+			loop.latches = append(loop.latches, node)
+
+			// Everything which can reach the latch without going through the header is in the loop
+			work := []int{node}
+			for len(work) > 0 {
+				current := work[len(work)-1]
+				work = work[:len(work)-1]
+				if loop.body[current] {
+					continue
+				}
+
+				loop.body[current] = true
+				for _, predecessor := range d.graph.Blocks[current].Predecessors {
+					if d.reachable(predecessor) {
+						work = append(work, predecessor)
+					}
+				}
+			}
+		}
+	}
+
+	for _, loop := range d.loops {
+		d.classifyLoop(loop)
+	}
+}
+
+// Picks how the loop is written and which block the loop breaks out to
+func (d *decompiler) classifyLoop(loop *decompiledLoop) {
+	loop.kind = ASTLoop
+	loop.exit = noNode
+
+	outside := func(node int) bool { return node == d.exitNode || !loop.body[node] }
+
+	header := d.successors[loop.header]
+	if len(header) == 2 && outside(header[0]) != outside(header[1]) {
+		if outside(header[0]) {
+			loop.exit = header[0]
+		} else {
+			loop.exit = header[1]
+		}
+
+		// A header which only holds the condition is a while loop
+		block := d.graph.Blocks[loop.header]
+		if block.End-block.Start == 0 || (d.folded[loop.header] && block.End-block.Start == 1) {
+			loop.kind = ASTWhile
+			return
+		}
+	}
+
+	// A loop which can only be left from its only latch is a do-while loop
+	if len(loop.latches) == 1 && loop.exit == noNode {
+		latch := d.successors[loop.latches[0]]
+		if len(latch) == 2 && outside(latch[0]) != outside(latch[1]) && d.exitsFrom(loop) == 1 {
+			loop.kind = ASTDoWhile
+			if outside(latch[0]) {
+				loop.exit = latch[0]
+			} else {
+				loop.exit = latch[1]
+			}
+			return
+		}
+	}
+
+	if loop.exit != noNode {
+		return
+	}
+
+	// Otherwise break out to the first block jumped to from inside the loop
+	for node, inLoop := range loop.body {
+		if !inLoop {
+			continue
+		}
+
+		for _, successor := range d.successors[node] {
+			if outside(successor) && (loop.exit == noNode || successor < loop.exit) {
+				loop.exit = successor
+			}
+		}
+	}
+}
+
+// How many edges leave the loop
+func (d *decompiler) exitsFrom(loop *decompiledLoop) (exits int) {
+	for node, inLoop := range loop.body {
+		if !inLoop {
+			continue
+		}
+
+		for _, successor := range d.successors[node] {
+			if successor == d.exitNode || !loop.body[successor] {
+				exits++
+			}
+		}
+	}
+	return
+}
+
+// Writes the whole program, starting with the first block
+func (d *decompiler) emit() []*ASTNode {
+	d.written = make([]int, len(d.graph.Blocks))
+	d.onPath = make([]bool, len(d.graph.Blocks))
+
+	return d.emitRegion(0, d.exitNode, nil)
+}
+
+// Writes the blocks from `node` until `follow` is reached, or every path has left the region
+func (d *decompiler) emitRegion(node int, follow int, ctx *loopContext) (nodes []*ASTNode) {
+	nodes = make([]*ASTNode, 0)
+
+	added := make([]int, 0)
+	defer func() {
+		for _, block := range added {
+			d.onPath[block] = false
+		}
+	}()
+
+	for node != follow {
+		if jump := d.loopJump(node, ctx); jump != nil {
+			return append(nodes, jump...)
+		}
+
+		if node == d.exitNode {
+			return append(nodes, &ASTNode{Kind: ASTHalt, IP: d.startOf(node)})
+		}
+
+		if d.onPath[node] || d.written[node] >= maxBlockCopies {
+			return append(nodes, d.gotoNode(node))
+		}
+		d.onPath[node] = true
+		added = append(added, node)
+
+		var written []*ASTNode
+		if loop, found := d.loops[node]; found {
+			written, node = d.emitLoop(loop, ctx)
+		} else {
+			written, node = d.emitBlock(node, ctx)
+		}
+
+		nodes = append(nodes, written...)
+		if node == noNode {
+			return
+		}
+	}
+
+	return
+}
+
+// Writes what happens when the body of a loop moves to `node`, or nil if the node should be written as normal.
+// Jumps to the header or exit of a loop continue or break out of it, labelled when it isn't the innermost loop
+func (d *decompiler) loopJump(node int, ctx *loopContext) []*ASTNode {
+	if ctx == nil {
+		return nil
+	}
+
+	labelled := false
+
+	for c := ctx; c != nil; c = c.parent {
+		// Escaped regions are written inside the loop they escaped from
+		labelled = labelled || c.escape
+
+		switch {
+		case node == c.header:
+			return []*ASTNode{{Kind: ASTContinue, IP: d.startOf(node), Label: d.startOf(c.header), Labelled: labelled}}
+		case node == c.exit && (node != d.exitNode || (c == ctx && !c.escape)):
+			return []*ASTNode{{Kind: ASTBreak, IP: d.startOf(node), Label: d.startOf(c.header), Labelled: labelled}}
+		case node == d.exitNode:
+			return nil
+		case d.inBody(c, node) && c == ctx:
+			return nil
+		case d.inBody(c, node):
+			break
+		case c.parent == nil || (d.inBody(c.parent, node) && node != c.parent.header && node != c.parent.exit):
+			return d.escapeLoop(node, c, labelled)
+		}
+
+		labelled = true
+	}
+
+	return []*ASTNode{d.gotoNode(node)}
+}
+
+// Writes another exit from the loop inline, which then breaks out to the usual exit if it gets there
+func (d *decompiler) escapeLoop(node int, ctx *loopContext, labelled bool) (nodes []*ASTNode) {
+	escape := &loopContext{header: noNode, exit: noNode, parent: nil, escape: true}
+	if ctx.parent != nil {
+		escape.header, escape.exit, escape.body, escape.parent = ctx.parent.header, ctx.parent.exit, ctx.parent.body, ctx.parent.parent
+	}
+
+	nodes = d.emitRegion(node, ctx.exit, escape)
+	if !endsWithJump(nodes) {
+		nodes = append(nodes, &ASTNode{Kind: ASTBreak, IP: d.startOf(ctx.exit), Label: d.startOf(ctx.header), Labelled: labelled})
+	}
+	return
+}
+
+func (d *decompiler) gotoNode(node int) *ASTNode {
+	d.labels[node] = true
+	ip := d.graph.Blocks[node].Start
+	return &ASTNode{Kind: ASTGoto, IP: ip, Label: ip}
+}
+
+// Writes every block after a label, ending with a goto to the block it carries on to. Languages without
+// gotos can run this as a loop which switches on the label to run next
+func (d *decompiler) emitDispatch() *ASTNode {
+	body := make([]*ASTNode, 0)
+
+	for node, block := range d.graph.Blocks {
+		if !block.Reachable || d.skipJumps(node) != node {
+			continue
+		}
+
+		body = append(body, &ASTNode{Kind: ASTLabel, IP: block.Start, Label: block.Start})
+		body = append(body, d.statements(node)...)
+
+		successors := d.successors[node]
+		if len(successors) == 1 {
+			body = append(body, d.dispatchJump(successors[0]))
+		} else {
+			body = append(body, &ASTNode{
+				Kind:      ASTIf,
+				IP:        block.End,
+				Condition: d.conditions[node],
+				Body:      []*ASTNode{d.dispatchJump(successors[1])},
+				Else:      []*ASTNode{d.dispatchJump(successors[0])},
+			})
+		}
+	}
+
+	return &ASTNode{Kind: ASTDispatch, IP: 0, Label: d.startOf(d.skipJumps(0)), Body: tidyNodes(body, false)}
+}
+
+// Jumps to the block from inside `DecompiledProgram.Dispatch`, or halts
+func (d *decompiler) dispatchJump(node int) *ASTNode {
+	node = d.skipJumps(node)
+	if node == d.exitNode {
+		return &ASTNode{Kind: ASTHalt, IP: d.startOf(node)}
+	}
+	return &ASTNode{Kind: ASTGoto, IP: d.startOf(node), Label: d.startOf(node)}
+}
+
+// Follows blocks which only jump to another block, so the dispatch can jump straight past them
+func (d *decompiler) skipJumps(node int) int {
+	for target, seen := node, 0; target != d.exitNode; seen++ {
+		if len(d.statements(target)) > 0 || len(d.successors[target]) != 1 {
+			return target
+		}
+
+		// Blocks which only jump around in circles are kept, as the program never gets out of them
+		if target = d.successors[target][0]; seen == len(d.graph.Blocks) {
+			return node
+		}
+	}
+	return d.exitNode
+}
+
+// Writes the label of the block if a goto jumps to it and this is the first time it has been written
+func (d *decompiler) startBlock(node int) (nodes []*ASTNode) {
+	nodes = make([]*ASTNode, 0)
+	if d.labels[node] && d.written[node] == 0 {
+		ip := d.graph.Blocks[node].Start
+		nodes = append(nodes, &ASTNode{Kind: ASTLabel, IP: ip, Label: ip})
+	}
+
+	d.written[node]++
+	return
+}
+
+// Writes the instructions of the block, apart from the jump at the end
+func (d *decompiler) statements(node int) (nodes []*ASTNode) {
+	block := d.graph.Blocks[node]
+	end := block.End
+	if d.program[block.End].C == d.ipRegister {
+		end--
+	}
+	if d.folded[node] {
+		end--
+	}
+
+	for ip := block.Start; ip <= end; ip++ {
+		nodes = append(nodes, &ASTNode{Kind: ASTStatement, IP: ip, Instruction: d.withConstantIP(ip)})
+	}
+	return
+}
+
+// Writes the block and any if it ends with, returning the node to carry on from
+func (d *decompiler) emitBlock(node int, ctx *loopContext) (nodes []*ASTNode, next int) {
+	nodes = append(d.startBlock(node), d.statements(node)...)
+
+	successors := d.successors[node]
+	if len(successors) == 1 {
+		return nodes, successors[0]
+	}
+
+	// The branches join back together at the post dominator, as long as it is still inside the loop.
+	// Otherwise each branch ends by jumping out of the loop
+	join := d.postDominators[node]
+	if ctx != nil && (join == ctx.exit || (join != d.exitNode && !d.inBody(ctx, join))) {
+		join = noNode
+	}
+
+	ifNode := &ASTNode{
+		Kind:      ASTIf,
+		IP:        d.graph.Blocks[node].End,
+		Condition: d.conditions[node],
+		Body:      d.emitRegion(successors[1], join, ctx),
+		Else:      d.emitRegion(successors[0], join, ctx),
+	}
+
+	if len(ifNode.Body) > 0 || len(ifNode.Else) > 0 {
+		nodes = append(nodes, ifNode)
+	}
+	return nodes, join
+}
+
+// Writes the loop starting at the header, returning the node after the loop
+func (d *decompiler) emitLoop(loop *decompiledLoop, outer *loopContext) (nodes []*ASTNode, next int) {
+	ctx := &loopContext{header: loop.header, exit: loop.exit, body: loop.body, parent: outer}
+	header := d.graph.Blocks[loop.header]
+	latch := loop.latches[0]
+
+	switch loop.kind {
+	case ASTWhile:
+		nodes = d.startBlock(loop.header)
+
+		// The loop runs while the branch into the body is taken
+		condition := d.conditions[loop.header]
+		inside := d.successors[loop.header][1]
+		if inside == loop.exit {
+			inside = d.successors[loop.header][0]
+			condition = condition.Not()
+		}
+
+		return append(nodes, &ASTNode{
+			Kind:      ASTWhile,
+			IP:        header.End,
+			Label:     header.Start,
+			Condition: condition,
+			Body:      d.emitRegion(inside, loop.header, ctx),
+		}), loop.exit
+
+	case ASTDoWhile:
+		condition := d.conditions[latch]
+		if d.successors[latch][1] == loop.exit {
+			condition = condition.Not()
+		}
+
+		// Everything in the body leads to the latch, which only holds the statements before the condition
+		body := d.emitLoopBody(loop, latch, ctx)
+		if latch != loop.header {
+			body = append(body, d.startBlock(latch)...)
+			body = append(body, d.statements(latch)...)
+		}
+
+		return []*ASTNode{{
+			Kind:      ASTDoWhile,
+			IP:        d.graph.Blocks[latch].End,
+			Label:     header.Start,
+			Condition: condition,
+			Body:      body,
+		}}, loop.exit
+
+	default:
+		return []*ASTNode{{
+			Kind:  ASTLoop,
+			IP:    header.Start,
+			Label: header.Start,
+			Body:  d.emitLoopBody(loop, noNode, ctx),
+		}}, loop.exit
+	}
+}
+
+// Writes the body of a loop starting with its header, up to `end`
+func (d *decompiler) emitLoopBody(loop *decompiledLoop, end int, ctx *loopContext) (nodes []*ASTNode) {
+	if loop.header == end {
+		return append(d.startBlock(end), d.statements(end)...)
+	}
+
+	follow := end
+	if follow == noNode {
+		follow = loop.header
+	}
+
+	nodes, next := d.emitBlock(loop.header, ctx)
+	if next != noNode && next != loop.header && next != end {
+		nodes = append(nodes, d.emitRegion(next, follow, ctx)...)
+	}
+	return
+}
+
+// Is the last node one which never carries on to the next node?
+func endsWithJump(nodes []*ASTNode) bool {
+	if len(nodes) == 0 {
+		return false
+	}
+
+	last := nodes[len(nodes)-1]
+	switch last.Kind {
+	case ASTBreak, ASTContinue, ASTHalt, ASTGoto:
+		return true
+	case ASTIf:
+		return endsWithJump(last.Body) && endsWithJump(last.Else)
+	default:
+		return false
+	}
+}
+
+// Tidies up the nodes to read more like hand written code
+func tidyNodes(nodes []*ASTNode, isProgram bool) []*ASTNode {
+	tidied := make([]*ASTNode, 0, len(nodes))
+
+	for _, node := range nodes {
+		node.Body = tidyNodes(node.Body, false)
+		node.Else = tidyNodes(node.Else, false)
+
+		switch node.Kind {
+		case ASTIf:
+			tidied = append(tidied, tidyIf(node)...)
+			continue
+
+		case ASTWhile, ASTLoop:
+			node.Body = removeTrailingContinues(node.Body)
+			node = tidyLoop(node)
+
+		case ASTDoWhile:
+			node.Body = removeTrailingContinues(node.Body)
+			node = tidyDoWhile(node)
+		}
+
+		tidied = append(tidied, node)
+	}
+
+	tidied = removeUnreachable(tidied)
+
+	// Falling off the end of the program halts it anyway
+	if isProgram && len(tidied) > 0 && tidied[len(tidied)-1].Kind == ASTHalt {
+		tidied = tidied[:len(tidied)-1]
+	}
+
+	return tidied
+}
+
+// Removes what follows a break, continue, halt, goto or endless loop, up to the next label a goto could reach
+func removeUnreachable(nodes []*ASTNode) (reachable []*ASTNode) {
+	reachable = nodes[:0]
+	jumped := false
+
+	for _, node := range nodes {
+		if hasLabel(node) {
+			jumped = false
+		}
+		if !jumped {
+			reachable = append(reachable, node)
+		}
+		if endsWithJump([]*ASTNode{node}) || node.Kind == ASTLoop && !jumpsOutOf(node.Body, ASTBreak, node.Label, true) {
+			jumped = true
+		}
+	}
+
+	return
+}
+
+func hasLabel(node *ASTNode) (found bool) {
+	walkNodes([]*ASTNode{node}, func(node *ASTNode) {
+		found = found || node.Kind == ASTLabel
+	})
+	return
+}
+
+// Puts the shorter branch first and moves what follows a branch which jumps away out of the if
+func tidyIf(node *ASTNode) (nodes []*ASTNode) {
+	if len(node.Body) == 0 || (endsWithJump(node.Else) && !endsWithJump(node.Body)) || (isExit(node.Else) && !isExit(node.Body)) {
+		node.Body, node.Else = node.Else, node.Body
+		node.Condition = node.Condition.Not()
+	}
+
+	if endsWithJump(node.Body) && len(node.Else) > 0 {
+		rest := node.Else
+		node.Else = nil
+		return append([]*ASTNode{node}, rest...)
+	}
+
+	return []*ASTNode{node}
+}
+
+// Turns loops which start or end by breaking out of them into while and do-while loops
+func tidyLoop(node *ASTNode) *ASTNode {
+	if node.Kind != ASTLoop || len(node.Body) == 0 {
+		return node
+	}
+
+	if first := node.Body[0]; isConditionalBreak(first) {
+		return &ASTNode{Kind: ASTWhile, IP: first.IP, Label: node.Label, Condition: first.Condition.Not(), Body: node.Body[1:]}
+	}
+
+	if last := node.Body[len(node.Body)-1]; isConditionalBreak(last) && !jumpsOutOf(node.Body, ASTContinue, node.Label, true) {
+		return &ASTNode{Kind: ASTDoWhile, IP: last.IP, Label: node.Label, Condition: last.Condition.Not(), Body: node.Body[:len(node.Body)-1]}
+	}
+
+	return node
+}
+
+// Drops a check at the end of a do-while loop's body which the loop's own condition repeats, and turns
+// do-while loops whose body never reaches the condition into endless loops
+func tidyDoWhile(node *ASTNode) *ASTNode {
+	if len(node.Body) == 0 {
+		return node
+	}
+
+	if last := node.Body[len(node.Body)-1]; isConditionalBreak(last) && last.Condition == node.Condition.Not() {
+		node.Body = node.Body[:len(node.Body)-1]
+	}
+
+	if endsWithJump(node.Body) && !jumpsOutOf(node.Body, ASTContinue, node.Label, true) {
+		return &ASTNode{Kind: ASTLoop, IP: node.Label, Label: node.Label, Body: node.Body}
+	}
+
+	return node
+}
+
+// Is the body a lone break or halt?
+func isExit(nodes []*ASTNode) bool {
+	return len(nodes) == 1 && (nodes[0].Kind == ASTBreak || nodes[0].Kind == ASTHalt)
+}
+
+func isConditionalBreak(node *ASTNode) bool {
+	return node.Kind == ASTIf && len(node.Else) == 0 && len(node.Body) == 1 && node.Body[0].Kind == ASTBreak && !node.Body[0].Labelled
+}
+
+// Does anything in the body break out of or continue (`kind`) the loop with the label? `innermost` is false
+// inside nested loops, where only labelled breaks and continues can
+func jumpsOutOf(nodes []*ASTNode, kind ASTKind, label int, innermost bool) bool {
+	for _, node := range nodes {
+		switch node.Kind {
+		case kind:
+			if innermost && !node.Labelled || node.Labelled && node.Label == label {
+				return true
+			}
+		case ASTIf:
+			if jumpsOutOf(node.Body, kind, label, innermost) || jumpsOutOf(node.Else, kind, label, innermost) {
+				return true
+			}
+		case ASTWhile, ASTDoWhile, ASTLoop:
+			if jumpsOutOf(node.Body, kind, label, false) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Removes continues which are the last thing a loop body does, as the loop carries on anyway
+func removeTrailingContinues(nodes []*ASTNode) []*ASTNode {
+	if len(nodes) == 0 {
+		return nodes
+	}
+
+	last := nodes[len(nodes)-1]
+	switch {
+	case last.Kind == ASTContinue && !last.Labelled:
+		return nodes[:len(nodes)-1]
+
+	case last.Kind == ASTIf:
+		last.Body = removeTrailingContinues(last.Body)
+		last.Else = removeTrailingContinues(last.Else)
+
+		if len(last.Body) == 0 && len(last.Else) == 0 {
+			return nodes[:len(nodes)-1]
+		}
+		if len(last.Body) == 0 {
+			last.Body, last.Else = last.Else, nil
+			last.Condition = last.Condition.Not()
+		}
+	}
+
+	return nodes
+}
+
+// A language a decompiled program can be written out in. `DecompiledProgram.Write` walks the nodes
+// and calls the backend for each one, with `indent` already built for the line
+type ASTBackend interface {
+	Indent() string // The string used for a single level of indentation
+
+	// Starts and ends the program, with the body of the program between them
+	WriteASTStart(d *DecompiledProgram, str *strings.Builder)
+	WriteASTEnd(d *DecompiledProgram, str *strings.Builder)
+
+	// Can the backend write gotos? If not, programs which need them are written as `DecompiledProgram.Dispatch`
+	WritesGotos() bool
+
+	// Writes the start of the node, which is the whole node for nodes without a body
+	WriteASTNodeStart(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder)
+
+	// Writes the line between the body of an if and its else body
+	WriteASTElse(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder)
+
+	// Writes the end of ifs and loops, after their bodies
+	WriteASTNodeEnd(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder)
+
+	// Post processes the complete output
+	Finish(source string) (string, error)
+}
+
+// Writes the program out using the backend
+func (d *DecompiledProgram) Write(backend ASTBackend) (source string, err error) {
+	var str strings.Builder
+
+	body := d.Body
+	if d.Gotos > 0 && !backend.WritesGotos() {
+		body = []*ASTNode{d.Dispatch}
+	}
+
+	backend.WriteASTStart(d, &str)
+	d.writeNodes(backend, body, 1, &str)
+	backend.WriteASTEnd(d, &str)
+
+	return backend.Finish(str.String())
+}
+
+func (d *DecompiledProgram) writeNodes(backend ASTBackend, nodes []*ASTNode, depth int, str *strings.Builder) {
+	indent := strings.Repeat(backend.Indent(), depth)
+
+	for _, node := range nodes {
+		backend.WriteASTNodeStart(d, node, indent, str)
+
+		switch node.Kind {
+		case ASTIf:
+			d.writeNodes(backend, node.Body, depth+1, str)
+			if len(node.Else) > 0 {
+				backend.WriteASTElse(d, node, indent, str)
+				d.writeNodes(backend, node.Else, depth+1, str)
+			}
+			backend.WriteASTNodeEnd(d, node, indent, str)
+
+		case ASTWhile, ASTDoWhile, ASTLoop:
+			d.writeNodes(backend, node.Body, depth+1, str)
+			backend.WriteASTNodeEnd(d, node, indent, str)
+
+		case ASTDispatch:
+			// The body goes inside both the loop and the switch, with the labels as its cases
+			d.writeNodes(backend, node.Body, depth+2, str)
+			backend.WriteASTNodeEnd(d, node, indent, str)
+		}
+	}
+}
+
+// The program line for a statement, so the backends can reuse `ProgramLine.WriteInstruction`
+func (n *ASTNode) programLine() *ProgramLine {
+	instruction := n.Instruction
+	return NewProgramLine(n.IP, &instruction, nil)
+}
+
+// The name labelled loops and the breaks and continues which leave them use, such as `loop8` or `loop8_1` for a copy
+func (n *ASTNode) loopName() string {
+	if n.Copy > 0 {
+		return fmt.Sprintf("loop%d_%d", n.Label, n.Copy)
+	}
+	return fmt.Sprintf("loop%d", n.Label)
+}
+
+// The comment written after a node, pointing back at the original instruction
+func (d *DecompiledProgram) nodeComment(node *ASTNode) string {
+	if node.IP >= len(d.Program) {
+		return ""
+	}
+	return " // " + d.ipComment(node.IP)
+}
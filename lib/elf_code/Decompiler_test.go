@@ -0,0 +1,489 @@
+package elf_code
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// R[0] and R[1] both jump into the middle of the loop, so neither dominates the other
+const irreducibleTestProgram = `#ip 5
+gtri 0 5 1
+addr 1 5 5
+seti 3 0 5
+addi 2 1 2
+addi 2 2 2
+gtri 2 50 3
+addr 3 5 5
+seti 2 0 5`
+
+// A loop which can be left from its header, or from the end of its body
+const multipleExitTestProgram = `#ip 5
+seti 0 0 1
+addi 1 1 1
+eqrr 1 4 2
+addr 2 5 5
+seti 6 0 5
+seti 100 0 0
+seti 10 0 5
+gtri 1 9 2
+addr 2 5 5
+seti 0 0 5
+addi 0 1 0
+muli 0 2 3`
+
+func TestCPU_Decompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		want    string
+	}{
+		{"Do while", ssaTestProgram, `registers R[0..5]
+
+function main
+    R[1] = 0 // IP: 0 (seti 0 0 1)
+    do
+        R[1]++ // IP: 1 (addi 1 1 1)
+        R[2] = (R[1] > 9) ? 1 : 0 // IP: 2 (gtri 1 9 2)
+    while R[1] <= 9 // IP: 3 (addr 2 5 5)
+    R[0] = R[1] * R[1] // IP: 5 (mulr 1 1 0)
+end
+`},
+		{"If", dataFlowTestProgram, `registers R[0..5]
+
+function main
+    R[1] = 3 // IP: 0 (seti 3 0 1)
+    R[2] = 4 // IP: 1 (seti 4 0 2)
+    R[3] = R[1] + R[1] // IP: 2 (addr 1 1 3)
+    R[2] = 7 // IP: 3 (seti 7 0 2)
+    R[4] = (R[0] > R[3]) ? 1 : 0 // IP: 4 (gtrr 0 3 4)
+    if R[0] <= R[3] then // IP: 5 (addr 4 5 5)
+        R[2]++ // IP: 6 (addi 2 1 2)
+    end
+    R[0] = R[2] * R[1] // IP: 7 (mulr 2 1 0)
+end
+`},
+		{"Multiple exits", multipleExitTestProgram, `registers R[0..5]
+
+function main
+    R[1] = 0 // IP: 0 (seti 0 0 1)
+    loop
+        R[1]++ // IP: 1 (addi 1 1 1)
+        R[2] = (R[1] == R[4]) ? 1 : 0 // IP: 2 (eqrr 1 4 2)
+        if R[1] == R[4] then // IP: 3 (addr 2 5 5)
+            break
+        end
+        R[2] = (R[1] > 9) ? 1 : 0 // IP: 7 (gtri 1 9 2)
+        if R[1] > 9 then // IP: 8 (addr 2 5 5)
+            R[0]++ // IP: 10 (addi 0 1 0)
+            R[3] = R[0] * 2 // IP: 11 (muli 0 2 3)
+            halt
+        end
+    end
+    R[0] = 100 // IP: 5 (seti 100 0 0)
+    R[3] = R[0] * 2 // IP: 11 (muli 0 2 3)
+end
+`},
+		{"Constant computed jump", "#ip 5\nseti 1 0 0\naddr 0 5 5\naddi 1 1 1\nmuli 1 2 1", `registers R[0..5]
+
+function main
+    R[0] = 1 // IP: 0 (seti 1 0 0)
+    R[1] *= 2 // IP: 3 (muli 1 2 1)
+end
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			decompiled, err := cpu.Decompile()
+			if err != nil {
+				t.Fatalf("CPU.Decompile() error = %v", err)
+			}
+
+			got, err := decompiled.Write(PseudoCodeBackend{})
+			if err != nil {
+				t.Fatalf("DecompiledProgram.Write() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("DecompiledProgram.Write() = \n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCPU_Decompile_Structure(t *testing.T) {
+	tests := []struct {
+		name          string
+		program       string
+		booleanInputs []int
+		want          map[ASTKind]int
+	}{
+		{"Day 19", day19Program, []int{0}, map[ASTKind]int{ASTIf: 2, ASTDoWhile: 2}},
+		{"Day 21", day21Program, nil, map[ASTKind]int{ASTIf: 2, ASTDoWhile: 2, ASTLoop: 2}},
+		{"Loop", loopTestProgram, nil, map[ASTKind]int{ASTDoWhile: 1}},
+		{"Irreducible", irreducibleTestProgram, nil, map[ASTKind]int{ASTIf: 2, ASTGoto: 1, ASTLabel: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			decompiled, err := cpu.Decompile(tt.booleanInputs...)
+			if err != nil {
+				t.Fatalf("CPU.Decompile() error = %v", err)
+			}
+
+			got := make(map[ASTKind]int)
+			decompiled.Walk(func(node *ASTNode) {
+				if node.Kind != ASTStatement {
+					got[node.Kind]++
+				}
+			})
+
+			for _, kind := range []ASTKind{ASTIf, ASTWhile, ASTDoWhile, ASTLoop, ASTGoto, ASTLabel} {
+				if got[kind] != tt.want[kind] {
+					source, _ := decompiled.Write(PseudoCodeBackend{})
+					t.Errorf("CPU.Decompile() has %d %s nodes, want %d\n%s", got[kind], kind, tt.want[kind], source)
+				}
+			}
+
+			if decompiled.Gotos != tt.want[ASTGoto] {
+				t.Errorf("DecompiledProgram.Gotos = %v, want %v", decompiled.Gotos, tt.want[ASTGoto])
+			}
+		})
+	}
+}
+
+func TestCPU_Decompile_Semantics(t *testing.T) {
+	day21Halt := day21HaltValue(t)
+
+	tests := []struct {
+		name          string
+		program       string
+		booleanInputs []int
+		inputs        []Registers
+	}{
+		{"Data flow", dataFlowTestProgram, nil, []Registers{{0, 0, 0, 0, 0, 0}, {7, 0, 0, 0, 0, 0}}},
+		{"Loop", loopTestProgram, nil, []Registers{{0, 0, 0, 0, 0, 0}}},
+		{"Multiple exits", multipleExitTestProgram, nil, []Registers{{0, 0, 0, 0, 3, 0}, {0, 0, 0, 0, 20, 0}}},
+		{"Day 19", smallDay19Program, []int{0}, []Registers{{0, 0, 0, 0, 0, 0}, {1, 0, 0, 0, 0, 0}}},
+		{"Day 21", day21Program, nil, []Registers{{day21Halt, 0, 0, 0, 0, 0}}},
+		{"Irreducible", irreducibleTestProgram, nil, []Registers{{0, 0, 0, 0, 0, 0}, {6, 0, 0, 0, 0, 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			decompiled, err := cpu.Decompile(tt.booleanInputs...)
+			if err != nil {
+				t.Fatalf("CPU.Decompile() error = %v", err)
+			}
+
+			for _, input := range tt.inputs {
+				want, _ := NewCPUFromProgramFile(tt.program)
+				copy(want.Registers, input)
+				if err := want.Execute(); err != nil {
+					t.Fatalf("CPU.Execute() error = %v", err)
+				}
+
+				// Gotos are run through the dispatch loop, as they can jump into the middle of other nodes
+				body := decompiled.Body
+				if decompiled.Gotos > 0 {
+					body = []*ASTNode{decompiled.Dispatch}
+				}
+
+				got := append(make(Registers, 0), input...)
+				if exit, err := runDecompiled(body, got); err != nil || exit.kind == ASTGoto {
+					t.Fatalf("runDecompiled() = %v, %v", exit.kind, err)
+				}
+
+				// The instruction pointer isn't kept up to date by the structured program
+				got[decompiled.IPRegister] = want.Registers[decompiled.IPRegister]
+				if got.String() != want.Registers.String() {
+					source, _ := decompiled.Write(PseudoCodeBackend{})
+					t.Errorf("runDecompiled(%v) = %v, want %v\n%s", input, got, want.Registers, source)
+				}
+			}
+		})
+	}
+}
+
+func TestCPU_Decompile_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		program Program
+		wantErr string
+	}{
+		{"Empty", Program{}, "unable to decompile an empty program"},
+		{"Computed jump", Program{{AddI, 0, 0, 0}, {AddR, 0, 5, 5}}, "unable to decompile computed jump at 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.program.Decompile(5, 6)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Program.Decompile() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTidyNodes_DoWhile(t *testing.T) {
+	done := ASTCondition{Instruction{GtRI, 1, 9, 2}, false}
+	statement := func() *ASTNode { return &ASTNode{Kind: ASTStatement, Instruction: Instruction{AddI, 1, 1, 1}} }
+	breakIf := func(condition ASTCondition) *ASTNode {
+		return &ASTNode{Kind: ASTIf, Condition: condition, Body: []*ASTNode{{Kind: ASTBreak}}}
+	}
+
+	tests := []struct {
+		name     string
+		body     []*ASTNode
+		wantKind ASTKind
+		wantBody int
+	}{
+		{"Repeated check", []*ASTNode{statement(), breakIf(done), {Kind: ASTContinue}}, ASTDoWhile, 1},
+		{"Other check", []*ASTNode{statement(), breakIf(registerCondition(0))}, ASTDoWhile, 2},
+		{"Condition never reached", []*ASTNode{statement(), {Kind: ASTHalt}}, ASTLoop, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loop := &ASTNode{Kind: ASTDoWhile, Condition: done.Not(), Body: tt.body}
+
+			got := tidyNodes([]*ASTNode{loop}, false)
+			if len(got) != 1 || got[0].Kind != tt.wantKind || len(got[0].Body) != tt.wantBody {
+				t.Errorf("tidyNodes() = %v with a body of %d, want %v with a body of %d", got[0].Kind, len(got[0].Body), tt.wantKind, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestDecompiledProgram_Write(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend ASTBackend
+		want    []string
+	}{
+		{"Pseudo code", PseudoCodeBackend{}, []string{
+			"goto L4 // irreducible control flow",
+			"L4:",
+		}},
+		{"Go", GoBackend{}, []string{
+			"func Run(registers []int) []int {",
+			"pc := 0 // irreducible control flow",
+			"\tcase 4:\n",
+			"pc = 4\n",
+			"return R",
+		}},
+		{"JavaScript", JavaScriptBackend{}, []string{
+			"function main() {",
+			"var pc = 0; // irreducible control flow",
+			"    case 4:\n",
+			"pc = 4;\n",
+		}},
+		{"C", CBackend{}, []string{
+			"static void run(void) {",
+			"goto L4; // irreducible control flow",
+			"L4:;",
+			"    run();",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(irreducibleTestProgram)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			decompiled, err := cpu.Decompile()
+			if err != nil {
+				t.Fatalf("CPU.Decompile() error = %v", err)
+			}
+
+			source, err := decompiled.Write(tt.backend)
+			if err != nil {
+				t.Fatalf("DecompiledProgram.Write() error = %v\n%s", err, source)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(source, want) {
+					t.Errorf("DecompiledProgram.Write() = %s\nwant it to contain %q", source, want)
+				}
+			}
+		})
+	}
+}
+
+func TestGoBackend_Decompiled(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiles the generated code")
+	}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	tests := []struct {
+		name          string
+		program       string
+		booleanInputs []int
+		input         Registers
+	}{
+		{"Day 19", smallDay19Program, []int{0}, Registers{0, 0, 0, 0, 0, 0}},
+		{"Irreducible", irreducibleTestProgram, nil, Registers{6, 0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			decompiled, err := cpu.Decompile(tt.booleanInputs...)
+			if err != nil {
+				t.Fatalf("CPU.Decompile() error = %v", err)
+			}
+
+			source, err := decompiled.Write(GoBackend{PackageName: "main"})
+			if err != nil {
+				t.Fatalf("DecompiledProgram.Write() error = %v\n%s", err, source)
+			}
+
+			copy(cpu.Registers, tt.input)
+			if err := cpu.Execute(); err != nil {
+				t.Fatalf("CPU.Execute() error = %v", err)
+			}
+
+			if got, want := runGeneratedGo(t, source, tt.input), strconv.Itoa(cpu.Registers[0]); got != want {
+				t.Errorf("decompiled Run()[0] = %v, want %v\n%s", got, want, source)
+			}
+		})
+	}
+}
+
+// Finds the value of R[0] which makes day 21 halt as soon as possible
+func day21HaltValue(t *testing.T) int {
+	day21, err := NewCPUFromProgramFile(day21Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	debugger := NewDebugger(day21)
+	debugger.SetBreakpoint(28)
+	if reason, err := debugger.Continue(); err != nil || reason != BreakpointHit {
+		t.Fatalf("Debugger.Continue() = %v, %v, want %v", reason, err, BreakpointHit)
+	}
+
+	return day21.Registers[4]
+}
+
+// How a run of decompiled nodes finished
+type decompiledExit struct {
+	kind     ASTKind // ASTStatement when the nodes ran to the end, or the break, continue or halt which stopped them
+	label    int
+	labelled bool
+}
+
+// Runs the decompiled nodes on the registers, so they can be checked against the CPU
+func runDecompiled(nodes []*ASTNode, registers Registers) (exit decompiledExit, err error) {
+	for _, node := range nodes {
+		switch node.Kind {
+		case ASTStatement:
+			value, err := OpCodeFunc[node.Instruction.OpCode](node.Instruction.A, node.Instruction.B, registers)
+			if err != nil {
+				return exit, err
+			}
+			registers[node.Instruction.C] = value
+
+		case ASTIf:
+			holds, err := decompiledCondition(node.Condition, registers)
+			if err != nil {
+				return exit, err
+			}
+
+			branch := node.Else
+			if holds {
+				branch = node.Body
+			}
+			if exit, err = runDecompiled(branch, registers); exit.kind != ASTStatement || err != nil {
+				return exit, err
+			}
+
+		case ASTWhile, ASTDoWhile, ASTLoop:
+			if exit, err = runDecompiledLoop(node, registers); exit.kind != ASTStatement || err != nil {
+				return exit, err
+			}
+
+		case ASTDispatch:
+			if exit, err = runDecompiledDispatch(node, registers); exit.kind != ASTStatement || err != nil {
+				return exit, err
+			}
+
+		case ASTBreak, ASTContinue, ASTHalt, ASTGoto:
+			return decompiledExit{node.Kind, node.Label, node.Labelled}, nil
+		}
+	}
+
+	return decompiledExit{}, nil
+}
+
+func runDecompiledLoop(node *ASTNode, registers Registers) (exit decompiledExit, err error) {
+	for {
+		if node.Kind == ASTWhile {
+			if holds, err := decompiledCondition(node.Condition, registers); err != nil || !holds {
+				return decompiledExit{}, err
+			}
+		}
+
+		if exit, err = runDecompiled(node.Body, registers); err != nil {
+			return exit, err
+		}
+
+		// Labelled breaks and continues for other loops carry on up to them
+		if exit.labelled && exit.label != node.Label || exit.kind == ASTHalt {
+			return exit, nil
+		} else if exit.kind == ASTBreak {
+			return decompiledExit{}, nil
+		}
+
+		if node.Kind == ASTDoWhile {
+			if holds, err := decompiledCondition(node.Condition, registers); err != nil || !holds {
+				return decompiledExit{}, err
+			}
+		}
+	}
+}
+
+// Runs the body of the dispatch loop from each label gone to, until it halts
+func runDecompiledDispatch(node *ASTNode, registers Registers) (exit decompiledExit, err error) {
+	for pc := node.Label; ; pc = exit.label {
+		start := -1
+		for i, child := range node.Body {
+			if child.Kind == ASTLabel && child.Label == pc {
+				start = i
+			}
+		}
+		if start < 0 {
+			return exit, fmt.Errorf("no label for %d", pc)
+		}
+
+		if exit, err = runDecompiled(node.Body[start+1:], registers); exit.kind != ASTGoto || err != nil {
+			return exit, err
+		}
+	}
+}
+
+func decompiledCondition(condition ASTCondition, registers Registers) (holds bool, err error) {
+	value, err := OpCodeFunc[condition.Comparison.OpCode](condition.Comparison.A, condition.Comparison.B, registers)
+	return (value == 1) != condition.Negated, err
+}
@@ -0,0 +1,183 @@
+package elf_code
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An instruction of a disassembled program, with what it does worked out
+type DisassembledLine struct {
+	IP              int         // The instruction pointer of the instruction
+	Instruction     Instruction // The instruction itself
+	Jump            JumpInfo    // Where execution goes after the instruction
+	BranchCondition bool        // Is this the comparison which a conditional jump after it uses?
+	JumpTarget      bool        // Does another instruction jump here (other than by moving onto the next instruction)?
+	Comment         string      // What the instruction does, such as `r3 = r1 * r5` or `jmp 17`
+}
+
+// An annotated listing of a program
+type Disassembly struct {
+	IPRegister int
+	Lines      []DisassembledLine
+}
+
+// Disassembles the program loaded into the CPU, see `Program.JumpAt` for `booleanInputs`
+func (cpu *CPU) Disassemble(booleanInputs ...int) *Disassembly {
+	return cpu.Program.Disassemble(cpu.InstructionPointerRegister, booleanInputs...)
+}
+
+// Disassembles the program, working out where each write to the instruction pointer jumps to
+// and describing what every other instruction does
+func (p Program) Disassemble(ipRegister int, booleanInputs ...int) *Disassembly {
+	d := &Disassembly{
+		IPRegister: ipRegister,
+		Lines:      make([]DisassembledLine, len(p)),
+	}
+
+	for ip, instruction := range p {
+		d.Lines[ip] = DisassembledLine{
+			IP:          ip,
+			Instruction: instruction,
+			Jump:        p.JumpAt(ip, ipRegister, booleanInputs...),
+		}
+	}
+
+	// Mark the targets of jumps and the comparisons conditional jumps use
+	for ip, line := range d.Lines {
+		for _, target := range line.Jump.Targets {
+			if target != ip+1 && target >= 0 && target < len(p) {
+				d.Lines[target].JumpTarget = true
+			}
+		}
+
+		if line.Jump.Kind == ConditionalJump {
+			for prev := ip - 1; prev >= 0 && p[prev].C != ipRegister; prev-- {
+				if p[prev].C == line.Jump.ConditionRegister {
+					d.Lines[prev].BranchCondition = true
+					break
+				}
+			}
+		}
+	}
+
+	for ip := range d.Lines {
+		d.Lines[ip].Comment = d.comment(&d.Lines[ip], len(p))
+	}
+
+	return d
+}
+
+// Describes what the instruction does
+func (d *Disassembly) comment(line *DisassembledLine, programLength int) string {
+	instruction := line.Instruction
+	target := func(ip int) string {
+		if ip < 0 || ip >= programLength {
+			return strconv.Itoa(ip) + " (halt)"
+		}
+		return strconv.Itoa(ip)
+	}
+
+	switch line.Jump.Kind {
+	case Jump:
+		if line.Jump.Relative {
+			return fmt.Sprintf("jmp rel %+d -> %s", line.Jump.Targets[0]-line.IP, target(line.Jump.Targets[0]))
+		}
+		return "jmp " + target(line.Jump.Targets[0])
+
+	case ConditionalJump:
+		condition := line.Jump.ConditionRegister
+		return fmt.Sprintf(
+			"jmp rel r%d, branch to %s if r%d else %s",
+			condition, target(line.Jump.Targets[1]), condition, target(line.Jump.Targets[0]),
+		)
+
+	case ComputedJump:
+		// Show the most common case of adding a register to the instruction pointer as a relative jump
+		if instruction.OpCode == AddR && (instruction.A == d.IPRegister) != (instruction.B == d.IPRegister) {
+			offset := instruction.A
+			if offset == d.IPRegister {
+				offset = instruction.B
+			}
+			return fmt.Sprintf("jmp rel r%d (computed)", offset)
+		}
+		return fmt.Sprintf("ip = %s (computed jump)", d.expression(line))
+
+	default:
+		comment := fmt.Sprintf("r%d = %s", instruction.C, d.expression(line))
+		if line.BranchCondition {
+			comment += " (branch condition)"
+		}
+		return comment
+	}
+}
+
+// The expression of the value the instruction writes, reads of the instruction pointer are replaced by its value
+func (d *Disassembly) expression(line *DisassembledLine) string {
+	instruction := line.Instruction
+	isImmediate := OpCodeInputType[instruction.OpCode]
+
+	operand := func(value int, isImmediate bool) string {
+		switch {
+		case isImmediate:
+			return strconv.Itoa(value)
+		case value == d.IPRegister:
+			return strconv.Itoa(line.IP)
+		default:
+			return "r" + strconv.Itoa(value)
+		}
+	}
+	a := operand(instruction.A, isImmediate.A)
+	b := operand(instruction.B, isImmediate.B)
+
+	switch instruction.OpCode {
+	case AddR, AddI:
+		return a + " + " + b
+	case MulR, MulI:
+		return a + " * " + b
+	case BanR, BanI:
+		return a + " & " + b
+	case BorR, BorI:
+		return a + " | " + b
+	case SetR, SetI:
+		return a
+	case GtIR, GtRI, GtRR:
+		return a + " > " + b
+	case EqIR, EqRI, EqRR:
+		return a + " == " + b
+	default:
+		if registered, found := registeredOpCodes[instruction.OpCode]; found {
+			return registered.expression(a, b)
+		}
+		return "?"
+	}
+}
+
+// Writes the listing as assembly which `Assemble` reads back into the same program, with the instruction
+// pointer and what each instruction does in comments. Jump targets are labelled as `l<ip>:`
+func (d *Disassembly) String() string {
+	var str strings.Builder
+
+	str.WriteString("#ip ")
+	str.WriteString(strconv.Itoa(d.IPRegister))
+	str.WriteRune('\n')
+
+	// Line up the comments after the longest instruction
+	width := 0
+	for _, line := range d.Lines {
+		if length := len(line.Instruction.String()); length > width {
+			width = length
+		}
+	}
+
+	for _, line := range d.Lines {
+		label := ""
+		if line.JumpTarget {
+			label = "l" + strconv.Itoa(line.IP) + ":"
+		}
+
+		str.WriteString(fmt.Sprintf("%-6s %-*s ; %3d: %s\n", label, width, line.Instruction, line.IP, line.Comment))
+	}
+
+	return str.String()
+}
@@ -0,0 +1,86 @@
+package elf_code
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProgram_Disassemble(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(day19Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	disassembly := cpu.Disassemble(0)
+
+	tests := []struct {
+		ip              int
+		wantComment     string
+		branchCondition bool
+		jumpTarget      bool
+	}{
+		{0, "jmp rel +17 -> 17", false, false},
+		{3, "r4 = r1 * r5", false, true},
+		{4, "r4 = r4 == r2 (branch condition)", true, false},
+		{5, "jmp rel r4, branch to 7 if r4 else 6", false, false},
+		{11, "jmp 3", false, false},
+		{16, "jmp rel +241 -> 257 (halt)", false, true},
+		{19, "r2 = 19 * r2", false, false},
+		{25, "jmp rel r0, branch to 27 if r0 else 26", false, false},
+		{27, "r4 = 27", false, true},
+	}
+	for _, tt := range tests {
+		line := disassembly.Lines[tt.ip]
+		if line.Comment != tt.wantComment || line.BranchCondition != tt.branchCondition || line.JumpTarget != tt.jumpTarget {
+			t.Errorf(
+				"Program.Disassemble() line %d = %q (condition %v, target %v), want %q (condition %v, target %v)",
+				tt.ip, line.Comment, line.BranchCondition, line.JumpTarget, tt.wantComment, tt.branchCondition, tt.jumpTarget,
+			)
+		}
+	}
+
+	// Without knowing R[0] is a boolean, the jump at 25 could go anywhere
+	if got := cpu.Disassemble().Lines[25].Comment; got != "jmp rel r0 (computed)" {
+		t.Errorf("Program.Disassemble() line 25 = %q, want %q", got, "jmp rel r0 (computed)")
+	}
+
+	if got := (Program{{SetR, 4, 0, 0}}).Disassemble(0).Lines[0].Comment; got != "ip = r4 (computed jump)" {
+		t.Errorf("Program.Disassemble() = %q, want %q", got, "ip = r4 (computed jump)")
+	}
+}
+
+func TestDisassembly_String(t *testing.T) {
+	for _, program := range []string{day19Program, day21Program, loopTestProgram} {
+		cpu, err := NewCPUFromProgramFile(program)
+		if err != nil {
+			t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+		}
+
+		listing := cpu.Disassemble(0).String()
+		if !strings.Contains(listing, "; ") {
+			t.Errorf("Disassembly.String() = %s, want comments", listing)
+		}
+
+		// The listing should assemble back into the same program
+		assembly, err := Assemble(listing)
+		if err != nil {
+			t.Fatalf("Assemble() error = %v\n%s", err, listing)
+		}
+
+		if !reflect.DeepEqual(assembly.Program, cpu.Program) || assembly.IPRegister != cpu.InstructionPointerRegister {
+			t.Errorf("Assemble(Disassembly.String()) = %v, want %v", assembly.Program, cpu.Program)
+		}
+	}
+
+	cpu, _ := NewCPUFromProgramFile(day21Program)
+	listing := cpu.Disassemble().String()
+	for _, want := range []string{
+		"l28:   eqrr 4 0 1        ;  28: r1 = r4 == r0 (branch condition)\n",
+		"       addr 1 2 2        ;  29: jmp rel r1, branch to 31 (halt) if r1 else 30\n",
+	} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("Disassembly.String() = %s\nwant it to contain %q", listing, want)
+		}
+	}
+}
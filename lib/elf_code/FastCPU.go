@@ -0,0 +1,179 @@
+package elf_code
+
+import (
+	"fmt"
+)
+
+// A program which has been checked against a register layout, so it can be run without
+// looking up op codes or checking register bounds for every instruction
+type DecodedProgram struct {
+	instructions []Instruction
+	ipRegister   int
+	numRegisters int
+	host         *Host        // Given to op codes which talk to the host, if nil they fail with ErrNoHost
+	word         WordSize     // The size of the registers, which results are wrapped to
+	idioms       []*idiomLoop // Instruction pointer => loop starting there which can be run as an idiom
+}
+
+// Validates the program for the given registers, ready to be run by `DecodedProgram.Run`
+func DecodeProgram(program Program, ipRegister int, numRegisters int) (decoded *DecodedProgram, err error) {
+	if ipRegister < 0 || ipRegister >= numRegisters {
+		return nil, fmt.Errorf("ip register %d out of range of %d registers", ipRegister, numRegisters)
+	}
+
+	if err = program.Validate(numRegisters); err != nil {
+		return nil, err
+	}
+
+	return &DecodedProgram{
+		instructions: append(make([]Instruction, 0, len(program)), program...),
+		ipRegister:   ipRegister,
+		numRegisters: numRegisters,
+		idioms:       findIdiomLoops(program, ipRegister),
+	}, nil
+}
+
+// Decodes the program loaded into the CPU, which keeps talking to the CPU's host and uses its word size
+func (cpu *CPU) Decode() (decoded *DecodedProgram, err error) {
+	if err = cpu.Word.validate(); err != nil {
+		return
+	}
+
+	decoded, err = DecodeProgram(cpu.Program, cpu.InstructionPointerRegister, len(cpu.Registers))
+	if err != nil {
+		return
+	}
+
+	decoded.host = &cpu.Host
+	decoded.word = cpu.Word
+	return
+}
+
+// Runs the program from the instruction pointer in the registers until it halts, or `maxInstructions` have
+// been executed (if greater than zero) in which case an *ExecutionStoppedError is returned. Like `CPU.Execute`
+// the instruction pointer register is left pointing at the last instruction run when the program halts.
+// Loops matching an idiom are run in closed form, counting the instructions the loop would have run
+func (d *DecodedProgram) Run(registers Registers, maxInstructions int) (executed int, err error) {
+	if len(registers) != d.numRegisters {
+		return 0, fmt.Errorf("expected %d registers, got %d", d.numRegisters, len(registers))
+	}
+
+	r := registers
+	program := d.instructions
+	ipRegister := d.ipRegister
+	ip := r[ipRegister]
+
+	for ip >= 0 && ip < len(program) {
+		if maxInstructions > 0 && executed >= maxInstructions {
+			r[ipRegister] = ip
+			return executed, &ExecutionStoppedError{ErrInstructionLimit, ip, registers.Copy(), executed}
+		}
+
+		budget := -1
+		if maxInstructions > 0 {
+			budget = maxInstructions - executed
+		}
+
+		// Loops matching an idiom are skipped to their result
+		if next, ran := d.runIdiom(ip, r, budget); ran > 0 {
+			ip = next
+			executed += ran
+			continue
+		}
+
+		instruction := &program[ip]
+		r[ipRegister] = ip
+
+		if err = d.execute(instruction, r); err != nil {
+			return
+		}
+
+		ip = r[ipRegister] + 1
+		executed++
+	}
+
+	// Leave the instruction pointer on the last instruction, as CPU.Execute does
+	r[ipRegister] = ip - 1
+
+	return executed, nil
+}
+
+// Executes a single instruction of the program, wrapping the result to the word size
+func (d *DecodedProgram) execute(instruction *Instruction, r Registers) error {
+	if err := executeDecoded(instruction, r, d.host); err != nil {
+		return err
+	}
+
+	if d.word.Bits != 0 {
+		r[instruction.C] = d.word.Wrap(r[instruction.C])
+	}
+
+	return nil
+}
+
+// Executes a single validated instruction, without touching the instruction pointer. Only registered op codes
+// can return an error, with `host` given to those which talk to the host
+func executeDecoded(instruction *Instruction, r Registers, host *Host) (err error) {
+	switch instruction.OpCode {
+	case AddR:
+		r[instruction.C] = r[instruction.A] + r[instruction.B]
+	case AddI:
+		r[instruction.C] = r[instruction.A] + instruction.B
+	case MulR:
+		r[instruction.C] = r[instruction.A] * r[instruction.B]
+	case MulI:
+		r[instruction.C] = r[instruction.A] * instruction.B
+	case BanR:
+		r[instruction.C] = r[instruction.A] & r[instruction.B]
+	case BanI:
+		r[instruction.C] = r[instruction.A] & instruction.B
+	case BorR:
+		r[instruction.C] = r[instruction.A] | r[instruction.B]
+	case BorI:
+		r[instruction.C] = r[instruction.A] | instruction.B
+	case SetR:
+		r[instruction.C] = r[instruction.A]
+	case SetI:
+		r[instruction.C] = instruction.A
+	case GtIR:
+		r[instruction.C] = boolToRegister(instruction.A > r[instruction.B])
+	case GtRI:
+		r[instruction.C] = boolToRegister(r[instruction.A] > instruction.B)
+	case GtRR:
+		r[instruction.C] = boolToRegister(r[instruction.A] > r[instruction.B])
+	case EqIR:
+		r[instruction.C] = boolToRegister(instruction.A == r[instruction.B])
+	case EqRI:
+		r[instruction.C] = boolToRegister(r[instruction.A] == instruction.B)
+	case EqRR:
+		r[instruction.C] = boolToRegister(r[instruction.A] == r[instruction.B])
+	default:
+		var value int
+		if value, err = executeRegistered(instruction, r, host); err == nil {
+			r[instruction.C] = value
+		}
+	}
+
+	return
+}
+
+func boolToRegister(value bool) int {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+// Executes the program loaded into the CPU using the decoded fast path. The result is the same as
+// `CPU.Execute`, however the tracer isn't called and invalid programs are rejected before any
+// instruction is run
+func (cpu *CPU) ExecuteFast() error {
+	decoded, err := cpu.Decode()
+	if err != nil {
+		return err
+	}
+
+	_, err = decoded.Run(cpu.Registers, 0)
+	return err
+}
@@ -0,0 +1,132 @@
+package elf_code
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCPU_ExecuteFast(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		r0      int
+	}{
+		{"Debugger program", debuggerTestProgram, 0},
+		{"Loop", loopTestProgram, 0},
+		{"Day 19 part 1", smallDay19Program, 0},
+		{"Day 19 part 2", smallDay19Program, 1},
+		{"Already halted", "#ip 0\nseti 5 0 0", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+			got, _ := NewCPUFromProgramFile(tt.program)
+
+			want.Registers[0] = tt.r0
+			got.Registers[0] = tt.r0
+			if tt.name == "Already halted" {
+				want.Registers[0], got.Registers[0] = 5, 5
+			}
+
+			if err := want.Execute(); err != nil {
+				t.Fatalf("CPU.Execute() error = %v", err)
+			}
+
+			if err := got.ExecuteFast(); err != nil {
+				t.Fatalf("CPU.ExecuteFast() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got.Registers, want.Registers) {
+				t.Errorf("CPU.ExecuteFast() = %v, want %v", got.Registers, want.Registers)
+			}
+		})
+	}
+}
+
+func TestDecodedProgram_Run(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(day21Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	decoded, err := cpu.Decode()
+	if err != nil {
+		t.Fatalf("CPU.Decode() error = %v", err)
+	}
+
+	// Day 21 never halts with R[0] = 0, so both paths should stop in the same place
+	registers := cpu.Registers.Copy()
+	executed, err := decoded.Run(registers, 10000)
+	got, ok := err.(*ExecutionStoppedError)
+	if !ok || executed != 10000 {
+		t.Fatalf("DecodedProgram.Run() = %v, %v, want the instruction limit to be reached", executed, err)
+	}
+
+	err = cpu.ExecuteWithLimits(context.Background(), 10000)
+	want, ok := err.(*ExecutionStoppedError)
+	if !ok {
+		t.Fatalf("CPU.ExecuteWithLimits() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodedProgram.Run() error = %v, want %v", got, want)
+	}
+
+	if _, err := decoded.Run(NewRegisters(4), 0); err == nil {
+		t.Errorf("DecodedProgram.Run() with 4 registers, want error")
+	}
+}
+
+func TestDecodeProgram(t *testing.T) {
+	tests := []struct {
+		name    string
+		program Program
+		wantErr bool
+	}{
+		{"Valid", Program{{AddR, 0, 5, 1}, {SetI, 100, 100, 2}, {GtIR, 100, 3, 4}}, false},
+		{"Input A out of range", Program{{AddR, 6, 0, 1}}, true},
+		{"Input B out of range", Program{{EqRR, 0, -1, 1}}, true},
+		{"Output out of range", Program{{SetI, 0, 0, 6}}, true},
+		{"Unknown op code", Program{{NumOpCodes, 0, 0, 0}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeProgram(tt.program, 0, 6)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DecodeProgram() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func BenchmarkCPU_Execute(b *testing.B) {
+	cpu, err := NewCPUFromProgramFile(smallDay19Program)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < b.N; i++ {
+		cpu.Registers = NewRegisters(len(cpu.Registers))
+		if err := cpu.Execute(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCPU_ExecuteFast(b *testing.B) {
+	cpu, err := NewCPUFromProgramFile(smallDay19Program)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < b.N; i++ {
+		cpu.Registers = NewRegisters(len(cpu.Registers))
+		if err := cpu.ExecuteFast(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
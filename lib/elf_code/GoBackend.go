@@ -0,0 +1,214 @@
+package elf_code
+
+import (
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+// Writes the program out as a Go package, with a `Run(registers []int) []int` function which
+// executes the program on a copy of the given registers
+type GoBackend struct {
+	PackageName string // The package to generate (defaults to `elfcode`)
+}
+
+func (GoBackend) Indent() string {
+	return "\t"
+}
+
+func (g GoBackend) WriteProgramStart(t *TranspileState, functions []*ProgramBlock, str *strings.Builder) {
+	g.writeRunStart("transpiler", len(t.Registers), str)
+	str.WriteString(fmt.Sprintf("\tblock%d(R)\n", t.mainBlock.blockNum))
+	str.WriteString("\treturn R\n")
+	str.WriteString("}\n\n")
+}
+
+func (GoBackend) WriteProgramEnd(t *TranspileState, functions []*ProgramBlock, str *strings.Builder) {
+	writeGoBoolToInt(str)
+
+	if t.idioms > 0 {
+		str.WriteString("\nfunc maxInt(a, b int) int {\n\tif a > b {\n\t\treturn a\n\t}\n\treturn b\n}\n\n")
+		str.WriteString("func floorDiv(a, b int) int {\n\tq := a / b\n\tif a%b != 0 && (a < 0) != (b < 0) {\n\t\tq--\n\t}\n\treturn q\n}\n\n")
+		str.WriteString("func shiftLeft(value, places int) int {\n\treturn value << uint(places)\n}\n\n")
+		str.WriteString("func divisorHit(factor, from, target int) int {\n")
+		str.WriteString("\tif factor == 0 || target%factor != 0 {\n\t\treturn 0\n\t}\n")
+		str.WriteString("\tif j := target / factor; j >= from && j <= maxInt(from, target) {\n\t\treturn factor\n\t}\n")
+		str.WriteString("\treturn 0\n}\n")
+	}
+}
+
+// Writes the package clause and the start of the `Run` function, up to copying the registers
+func (g GoBackend) writeRunStart(generator string, numRegisters int, str *strings.Builder) {
+	packageName := g.PackageName
+	if packageName == "" {
+		packageName = "elfcode"
+	}
+
+	str.WriteString(fmt.Sprintf("// Code generated by the elf_code %s. DO NOT EDIT.\n\n", generator))
+	str.WriteString(fmt.Sprintf("package %s\n\n", packageName))
+	str.WriteString("// Run executes the program on a copy of the given registers, returning them once the program halts\n")
+	str.WriteString("func Run(registers []int) []int {\n")
+	str.WriteString(fmt.Sprintf("\tR := make([]int, %d)\n", numRegisters))
+	str.WriteString("\tcopy(R, registers)\n")
+}
+
+func writeGoBoolToInt(str *strings.Builder) {
+	str.WriteString("func boolToInt(b bool) int {\n")
+	str.WriteString("\tif b {\n")
+	str.WriteString("\t\treturn 1\n")
+	str.WriteString("\t}\n")
+	str.WriteString("\treturn 0\n")
+	str.WriteString("}\n")
+}
+
+func (GoBackend) WriteFunctionStart(t *TranspileState, b *ProgramBlock, isMain bool, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("func block%d(R []int) {\n", b.blockNum))
+}
+
+func (GoBackend) WriteFunctionEnd(t *TranspileState, b *ProgramBlock, isMain bool, str *strings.Builder) {
+	str.WriteString("}\n\n")
+}
+
+func (GoBackend) WriteStatement(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent)
+
+	if line.instruction.OpCode.isComparator() {
+		// Go comparisons are booleans, so need converting back to an int
+		str.WriteString(fmt.Sprintf("R[%d] = boolToInt(", line.instruction.C))
+		line.WriteExpression(str)
+		str.WriteRune(')')
+	} else {
+		line.WriteInstruction(str)
+	}
+
+	str.WriteString(fmt.Sprintf(" // %s\n", t.ipComment(line.ip)))
+}
+
+func (GoBackend) WriteCall(t *TranspileState, line *ProgramLine, target *ProgramBlock, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%sblock%d(R)", indent, target.blockNum))
+	if line != nil {
+		str.WriteString(fmt.Sprintf(" // %s", t.ipComment(line.ip)))
+	}
+	str.WriteRune('\n')
+}
+
+func (GoBackend) WriteIfStart(t *TranspileState, line *ProgramLine, register int, value int, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%sif R[%d] == %d { // %s\n", indent, register, value, t.ipComment(line.ip)))
+}
+
+func (GoBackend) WriteElse(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent + "} else {\n")
+}
+
+func (GoBackend) WriteIfEnd(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent + "}\n")
+}
+
+func (GoBackend) WriteDoWhileStart(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent + "for {\n")
+}
+
+func (GoBackend) WriteDoWhileEnd(t *TranspileState, line *ProgramLine, register int, value int, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%s\tif R[%d] != %d { // %s\n", indent, register, value, t.ipComment(line.ip)))
+	str.WriteString(indent + "\t\tbreak\n")
+	str.WriteString(indent + "\t}\n")
+	str.WriteString(indent + "}\n")
+}
+
+func (GoBackend) WriteIdiom(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	writeIdiomAssignments(t, line, indent, "", str)
+}
+
+func (GoBackend) WriteComment(t *TranspileState, comment string, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%s// %s\n", indent, comment))
+}
+
+func (g GoBackend) WriteASTStart(d *DecompiledProgram, str *strings.Builder) {
+	g.writeRunStart("decompiler", d.NumRegisters, str)
+	str.WriteRune('\n')
+}
+
+// Go can't jump into blocks, so control flow which can't be structured is written as a loop switching on the block to run
+func (GoBackend) WritesGotos() bool {
+	return false
+}
+
+func (GoBackend) WriteASTEnd(d *DecompiledProgram, str *strings.Builder) {
+	// The dispatch loop only ever returns from inside it
+	if d.Gotos == 0 {
+		str.WriteString("\treturn R\n")
+	}
+	str.WriteString("}\n\n")
+	writeGoBoolToInt(str)
+}
+
+func (GoBackend) WriteASTNodeStart(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder) {
+	if node.Kind == ASTLabel {
+		// Labels are the cases of the switch in `ASTDispatch`, so line up with it
+		indent = strings.TrimSuffix(indent, "\t")
+	}
+	if node.Labelled && node.Kind != ASTBreak && node.Kind != ASTContinue {
+		str.WriteString(fmt.Sprintf("%s%s:\n", indent, node.loopName()))
+	}
+	str.WriteString(indent)
+
+	switch node.Kind {
+	case ASTStatement:
+		line := node.programLine()
+		if node.Instruction.OpCode.isComparator() {
+			str.WriteString(fmt.Sprintf("R[%d] = boolToInt(", node.Instruction.C))
+			line.WriteExpression(str)
+			str.WriteRune(')')
+		} else {
+			line.WriteInstruction(str)
+		}
+		str.WriteString(d.nodeComment(node))
+	case ASTIf:
+		str.WriteString(fmt.Sprintf("if %s {%s", node.Condition, d.nodeComment(node)))
+	case ASTWhile:
+		str.WriteString(fmt.Sprintf("for %s {%s", node.Condition, d.nodeComment(node)))
+	case ASTDoWhile, ASTLoop:
+		str.WriteString("for {")
+	case ASTBreak, ASTContinue:
+		str.WriteString(node.Kind.String())
+		if node.Labelled {
+			str.WriteString(" " + node.loopName())
+		}
+	case ASTHalt:
+		str.WriteString("return R")
+	case ASTGoto:
+		str.WriteString(fmt.Sprintf("pc = %d\n%scontinue", node.Label, indent))
+	case ASTLabel:
+		str.WriteString(fmt.Sprintf("case %d:", node.Label))
+	case ASTDispatch:
+		str.WriteString(fmt.Sprintf("pc := %d // irreducible control flow\n%sfor {\n%s\tswitch pc {", node.Label, indent, indent))
+	}
+
+	str.WriteRune('\n')
+}
+
+func (GoBackend) WriteASTElse(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder) {
+	str.WriteString(indent + "} else {\n")
+}
+
+func (GoBackend) WriteASTNodeEnd(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder) {
+	if node.Kind == ASTDoWhile {
+		str.WriteString(fmt.Sprintf("%s\tif %s {%s\n", indent, node.Condition.Not(), d.nodeComment(node)))
+		str.WriteString(indent + "\t\tbreak\n")
+		str.WriteString(indent + "\t}\n")
+	}
+	if node.Kind == ASTDispatch {
+		str.WriteString(indent + "\t}\n")
+	}
+	str.WriteString(indent + "}\n")
+}
+
+// Runs the generated code through gofmt
+func (GoBackend) Finish(source string) (string, error) {
+	formatted, err := format.Source([]byte(source))
+	if err != nil {
+		return source, fmt.Errorf("generated go code is invalid: %v", err)
+	}
+
+	return string(formatted), nil
+}
@@ -0,0 +1,116 @@
+package elf_code
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const goBackendTestMain = `package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+func main() {
+	registers := make([]int, 0)
+	for _, arg := range os.Args[1:] {
+		value, _ := strconv.Atoi(arg)
+		registers = append(registers, value)
+	}
+
+	fmt.Println(Run(registers)[0])
+}
+`
+
+func TestGoBackend(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiles the generated code")
+	}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	tests := []struct {
+		name    string
+		program string
+		unknown []int
+		input   Registers
+	}{
+		{"Day 19", day19Program, []int{0}, Registers{0, 0, 0, 0, 0, 0}},
+		{"Loop", loopTestProgram, nil, Registers{0, 0, 0, 0, 0, 0}},
+		{"Jump to start", jumpToStartTestProgram, nil, Registers{0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Work out what the interpreter thinks the answer is
+			cpu, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+			copy(cpu.Registers, tt.input)
+			if err := cpu.Execute(); err != nil {
+				t.Fatalf("CPU.Execute() error = %v", err)
+			}
+			want := strconv.Itoa(cpu.Registers[0])
+
+			// Then transpile it to Go
+			cpu, _ = NewCPUFromProgramFile(tt.program)
+			options := allTranspileOptions()
+			options.Backend = GoBackend{PackageName: "main"}
+			transpiler := cpu.StartTranspiler(options)
+			for _, register := range tt.unknown {
+				transpiler.Registers[register].SetUnknownBool()
+			}
+
+			source, err := transpiler.Run()
+			if err != nil {
+				t.Fatalf("TranspileState.Run() error = %v", err)
+			}
+
+			got := runGeneratedGo(t, source, tt.input)
+			if got != want {
+				t.Errorf("transpiled Run()[0] = %v, want %v\n%s", got, want, source)
+			}
+		})
+	}
+}
+
+// Builds and runs the generated code, returning the value of R[0] it printed
+func runGeneratedGo(t *testing.T, source string, input Registers) string {
+	dir, err := ioutil.TempDir("", "elf_code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(goBackendTestMain), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "program.go"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"run", "main.go", "program.go"}
+	for _, value := range input {
+		args = append(args, strconv.Itoa(value))
+	}
+
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GO111MODULE=on", "GOFLAGS=")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run error = %v\n%s\n%s", err, output, source)
+	}
+
+	return strings.TrimSpace(string(output))
+}
@@ -0,0 +1,208 @@
+package elf_code
+
+import (
+	"fmt"
+
+	"github.com/DomBlack/advent-of-code-2018/lib/algos"
+)
+
+// The values of an input register which make a program halt, found by `CPU.FindHaltingValues`
+type HaltingValues struct {
+	IP           int   // The comparison instruction which was watched
+	Register     int   // The input register the comparison reads
+	Values       []int // The unique values the input was compared against, in the order they were first compared
+	Instructions []int // For each value, the number of instructions executed before it was first compared
+	CycleStart   int   // The number of comparisons before the program started repeating itself
+	CycleLength  int   // The number of comparisons in each repeat
+}
+
+// The value which halts the program after the fewest instructions
+func (h *HaltingValues) First() int {
+	return h.Values[0]
+}
+
+// The value which halts the program after the most instructions (while still halting)
+func (h *HaltingValues) Last() int {
+	return h.Values[len(h.Values)-1]
+}
+
+// Finds the instructions which compare the register for equality against another value, for `CPU.FindHaltingValues`
+func (p Program) EqualityComparisonsReading(register int) (ips []int) {
+	for ip, instruction := range p {
+		if _, _, found := comparedOperand(instruction, register); found {
+			ips = append(ips, ip)
+		}
+	}
+
+	return
+}
+
+// Finds which values of `register` make the program halt, for programs which only halt when the equality comparison
+// at `ip` between `register` and another value is true (such as day 21). The program is run with the comparison
+// always being false, recording the values it is compared against, until the registers repeat when reaching the
+// comparison. `maxInstructions` limits the instructions between comparisons, if zero there is no limit
+func (cpu *CPU) FindHaltingValues(ip int, register int, maxInstructions int) (res *HaltingValues, err error) {
+	if ip < 0 || ip >= len(cpu.Program) {
+		return nil, fmt.Errorf("instruction %d is outside of the program", ip)
+	}
+
+	operand, isImmediate, found := comparedOperand(cpu.Program[ip], register)
+	if !found {
+		return nil, fmt.Errorf("instruction %d (%s) doesn't compare r%d for equality", ip, cpu.Program[ip], register)
+	}
+
+	decoded, err := cpu.Decode()
+	if err != nil {
+		return
+	}
+
+	search := &haltSearch{decoded, ip, maxInstructions, nil}
+	start := &haltSearchState{search, cpu.Registers.Copy(), 0}
+	start.runToComparison()
+	if search.err != nil {
+		return nil, search.err
+	}
+
+	cycleLength, cycleStart := algos.FloydCycleDetection(start)
+	if search.err != nil {
+		return nil, search.err
+	}
+
+	res = &HaltingValues{
+		IP:          ip,
+		Register:    register,
+		CycleStart:  cycleStart,
+		CycleLength: cycleLength,
+	}
+
+	// Go through the comparisons once more, as the cycle detection doesn't keep track of them
+	seen := make(map[int]bool)
+	state := start.CopyTickable().(*haltSearchState)
+	for i := 0; i < cycleStart+cycleLength; i++ {
+		value := operand
+		if !isImmediate {
+			value = state.registers[operand]
+		}
+
+		if !seen[value] {
+			seen[value] = true
+			res.Values = append(res.Values, value)
+			res.Instructions = append(res.Instructions, state.executed)
+		}
+
+		state.Tick()
+	}
+
+	return res, search.err
+}
+
+// The operand compared against `register` by an equality comparison
+func comparedOperand(instruction Instruction, register int) (operand int, isImmediate bool, found bool) {
+	switch instruction.OpCode {
+	case EqRR:
+		if instruction.A == register {
+			return instruction.B, false, true
+		}
+		if instruction.B == register {
+			return instruction.A, false, true
+		}
+	case EqRI:
+		if instruction.A == register {
+			return instruction.B, true, true
+		}
+	case EqIR:
+		if instruction.B == register {
+			return instruction.A, true, true
+		}
+	}
+
+	return 0, false, false
+}
+
+// Shared by all the states of a search
+type haltSearch struct {
+	program         *DecodedProgram
+	ip              int   // The comparison being watched
+	maxInstructions int   // The maximum number of instructions between comparisons
+	err             error // The first error found, after which states stop moving
+}
+
+// The registers when the program reaches the comparison, which `algos.FloydCycleDetection` ticks to the next comparison
+type haltSearchState struct {
+	search    *haltSearch
+	registers Registers
+	executed  int // The number of instructions executed to get here
+}
+
+// Runs the comparison as false, then carries on until the comparison is reached again
+func (s *haltSearchState) Tick() {
+	if s.search.err != nil {
+		return
+	}
+
+	d := s.search.program
+	ip := s.search.ip
+	s.registers[d.ipRegister] = ip
+	s.registers[d.instructions[ip].C] = 0
+	s.registers[d.ipRegister]++
+	s.executed++
+
+	s.runToComparison()
+}
+
+func (s *haltSearchState) runToComparison() {
+	d := s.search.program
+	r := s.registers
+	ip := r[d.ipRegister]
+
+	for executed := 0; ip != s.search.ip; {
+		if ip < 0 || ip >= len(d.instructions) {
+			s.search.err = fmt.Errorf("program halted without reaching the comparison at %d", s.search.ip)
+			return
+		}
+
+		if s.search.maxInstructions > 0 && executed >= s.search.maxInstructions {
+			s.search.err = &ExecutionStoppedError{ErrInstructionLimit, ip, r.Copy(), s.executed}
+			return
+		}
+
+		// Loops matching an idiom are skipped to their result, unless they hold the comparison
+		if loop := d.idioms[ip]; loop != nil && (s.search.ip < ip || s.search.ip >= ip+loop.length) {
+			budget := -1
+			if s.search.maxInstructions > 0 {
+				budget = s.search.maxInstructions - executed
+			}
+
+			if next, ran := d.runIdiom(ip, r, budget); ran > 0 {
+				ip = next
+				executed += ran
+				s.executed += ran
+				continue
+			}
+		}
+
+		r[d.ipRegister] = ip
+		if err := d.execute(&d.instructions[ip], r); err != nil {
+			s.search.err = err
+			return
+		}
+		ip = r[d.ipRegister] + 1
+		executed++
+		s.executed++
+	}
+
+	r[d.ipRegister] = ip
+}
+
+func (s *haltSearchState) CopyTickable() algos.Tickable {
+	return &haltSearchState{s.search, s.registers.Copy(), s.executed}
+}
+
+// After an error all states are the same, so the cycle detection finishes
+func (s *haltSearchState) String() string {
+	if s.search.err != nil {
+		return ""
+	}
+
+	return s.registers.String()
+}
@@ -0,0 +1,111 @@
+package elf_code
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Halts when R[0] matches the next value of R[1] = (R[1] * 5 + 3) & 15, which goes through all 16 values
+const haltSearchTestProgram = `#ip 4
+seti 0 0 1
+muli 1 5 1
+addi 1 3 1
+bani 1 15 1
+eqrr 1 0 2
+addr 2 4 4
+seti 0 0 4`
+
+func TestCPU_FindHaltingValues(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(haltSearchTestProgram)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	if got := cpu.Program.EqualityComparisonsReading(0); !reflect.DeepEqual(got, []int{4}) {
+		t.Fatalf("Program.EqualityComparisonsReading() = %v, want %v", got, []int{4})
+	}
+
+	got, err := cpu.FindHaltingValues(4, 0, 0)
+	if err != nil {
+		t.Fatalf("CPU.FindHaltingValues() error = %v", err)
+	}
+
+	wantValues := []int{3, 2, 13, 4, 7, 6, 1, 8, 11, 10, 5, 12, 15, 14, 9, 0}
+	if !reflect.DeepEqual(got.Values, wantValues) {
+		t.Errorf("CPU.FindHaltingValues() values = %v, want %v", got.Values, wantValues)
+	}
+
+	if got.First() != 3 || got.Last() != 0 || got.CycleStart != 0 || got.CycleLength != 16 {
+		t.Errorf("CPU.FindHaltingValues() = %+v, want first 3, last 0 and a cycle of 16 from the start", got)
+	}
+
+	// Each value should halt the program after the comparison and the jump after it
+	for i, value := range got.Values {
+		if got.Instructions[i] != 4+6*i {
+			t.Errorf("CPU.FindHaltingValues() instructions[%d] = %d, want %d", i, got.Instructions[i], 4+6*i)
+		}
+
+		run, _ := NewCPUFromProgramFile(haltSearchTestProgram)
+		run.Registers[0] = value
+		decoded, _ := run.Decode()
+		if executed, err := decoded.Run(run.Registers, 1000); err != nil || executed != got.Instructions[i]+2 {
+			t.Errorf("DecodedProgram.Run() with r0 = %d = %v, %v, want %d instructions", value, executed, err, got.Instructions[i]+2)
+		}
+	}
+
+	// The registers aren't changed by the search
+	if !reflect.DeepEqual(cpu.Registers, NewRegisters(6)) {
+		t.Errorf("CPU.FindHaltingValues() changed the registers to %v", cpu.Registers)
+	}
+}
+
+func TestCPU_FindHaltingValues_Errors(t *testing.T) {
+	tests := []struct {
+		name            string
+		program         string
+		ip              int
+		register        int
+		maxInstructions int
+	}{
+		{"Not a comparison", haltSearchTestProgram, 3, 0, 0},
+		{"Compares another register", haltSearchTestProgram, 4, 3, 0},
+		{"Outside of the program", haltSearchTestProgram, 7, 0, 0},
+		{"Never reaches the comparison", "#ip 4\nseti 10 0 4\neqrr 1 0 2", 1, 0, 0},
+		{"Halts after the comparison", "#ip 4\nseti 0 0 4\neqrr 1 0 2", 1, 0, 0},
+		{"Instruction limit", "#ip 4\nseti 0 0 4\neqrr 1 0 2\nseti 1 0 4", 1, 0, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			if _, err := cpu.FindHaltingValues(tt.ip, tt.register, tt.maxInstructions); err == nil {
+				t.Errorf("CPU.FindHaltingValues() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestCPU_FindHaltingValues_Day21(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(day21Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	// The division loop is run as an idiom, which must count the instructions it would have run
+	got, err := cpu.FindHaltingValues(28, 0, 0)
+	if err != nil {
+		t.Fatalf("CPU.FindHaltingValues() error = %v", err)
+	}
+
+	for _, i := range []int{0, 1, len(got.Values) - 1} {
+		run, _ := NewCPUFromProgramFile(day21Program)
+		run.Registers[0] = got.Values[i]
+		decoded, _ := run.Decode()
+		if executed, err := decoded.Run(run.Registers, 0); err != nil || executed != got.Instructions[i]+2 {
+			t.Errorf("DecodedProgram.Run() with r0 = %d = %v, %v, want %d instructions", got.Values[i], executed, err, got.Instructions[i]+2)
+		}
+	}
+}
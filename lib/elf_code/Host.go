@@ -0,0 +1,228 @@
+package elf_code
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Where a CPU reads input from and writes output to, for the `in`, `outr` and `outi` op codes
+type IO interface {
+	Input() (value int, err error) // Reads the next input, returning io.EOF once there is no more
+	Output(value int) error        // Writes a value out
+}
+
+// Handles the `sys` op code, given the syscall number A and the value of register B. The result is stored in output C
+type SyscallHandler = func(number int, argument int, registers Registers) (result int, err error)
+
+// The outside world a CPU can talk to, supplied by the program running the CPU
+type Host struct {
+	IO      IO             // Used by the I/O op codes, which fail with ErrNoIO if nil
+	Syscall SyscallHandler // Used by the `sys` op code, which fails with ErrNoSyscall if nil
+}
+
+// The function which executes an op code that talks to the host, used in place of an `OpFunc`
+type HostOpFunc = func(host *Host, a int, b int, registers Registers) (value int, err error)
+
+// Returned when an op code which talks to the host is run without one, such as when evaluated by the transpiler
+var ErrNoHost = errors.New("op code needs a host")
+
+// Returned by the I/O op codes when the host has no IO
+var ErrNoIO = errors.New("host has no IO")
+
+// Returned by the `sys` op code when the host has no syscall handler
+var ErrNoSyscall = errors.New("host has no syscall handler")
+
+// Creates a new CPU which talks to the host, and loads it with the given program
+func NewCPUWithHost(program Program, ipRegister int, numRegisters int, host Host) (res *CPU) {
+	res = NewCPU(program, ipRegister, numRegisters)
+	res.Host = host
+
+	return
+}
+
+// The op codes added by `RegisterIOOpCodes`
+type IOOpCodes struct {
+	In   OpCode // `in` stores the next input into register `C`. (Inputs `A` and `B` are ignored.)
+	OutR OpCode // `outr` writes register `A` out and stores it into register `C`, so `outr 1 0 1` changes nothing. (Input `B` is ignored.)
+	OutI OpCode // `outi` writes value `A` out and stores it into register `C`. (Input `B` is ignored.)
+	Sys  OpCode // `sys` calls the host's syscall handler with value `A` and register `B`, storing the result into register `C`
+}
+
+var (
+	ioOpCodes      IOOpCodes
+	ioOpCodesMutex sync.Mutex
+)
+
+// Registers the I/O op codes, which are optional as they change the numbers of op codes registered after them.
+// It is safe to call more than once, returning the op codes already registered
+func RegisterIOOpCodes() (opCodes IOOpCodes, err error) {
+	ioOpCodesMutex.Lock()
+	defer ioOpCodesMutex.Unlock()
+
+	if ioOpCodes.registered() {
+		return ioOpCodes, nil
+	}
+
+	definitions := []struct {
+		opCode     *OpCode
+		definition OpCodeDefinition
+	}{
+		{&opCodes.In, OpCodeDefinition{Name: "in", Inputs: InputIsImmediate{true, true}, HostFunc: hostIn}},
+		{&opCodes.OutR, OpCodeDefinition{Name: "outr", Inputs: InputIsImmediate{false, true}, HostFunc: hostOutR}},
+		{&opCodes.OutI, OpCodeDefinition{Name: "outi", Inputs: InputIsImmediate{true, true}, HostFunc: hostOutI}},
+		{&opCodes.Sys, OpCodeDefinition{Name: "sys", Inputs: InputIsImmediate{true, false}, HostFunc: hostSys}},
+	}
+
+	for i, d := range definitions {
+		if *d.opCode, err = RegisterOpCode(d.definition); err != nil {
+			// Don't leave some of them registered
+			for _, registered := range definitions[:i] {
+				_ = UnregisterOpCode(*registered.opCode)
+			}
+
+			return IOOpCodes{}, err
+		}
+	}
+
+	ioOpCodes = opCodes
+	return opCodes, nil
+}
+
+// If the op codes are all still registered as the I/O op codes, as they can be unregistered by `UnregisterOpCode`
+func (o IOOpCodes) registered() bool {
+	for opCode, name := range map[OpCode]string{o.In: "in", o.OutR: "outr", o.OutI: "outi", o.Sys: "sys"} {
+		if definition, found := registeredOpCodes[opCode]; !found || definition.Name != name {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hostIn(host *Host, a int, b int, registers Registers) (value int, err error) {
+	if host.IO == nil {
+		return 0, ErrNoIO
+	}
+
+	return host.IO.Input()
+}
+
+func hostOutR(host *Host, a int, b int, registers Registers) (value int, err error) {
+	aV, err := registers.Get(a)
+	if err != nil {
+		return 0, err
+	}
+
+	return hostOutI(host, aV, b, registers)
+}
+
+func hostOutI(host *Host, a int, b int, registers Registers) (value int, err error) {
+	if host.IO == nil {
+		return 0, ErrNoIO
+	}
+
+	return a, host.IO.Output(a)
+}
+
+func hostSys(host *Host, a int, b int, registers Registers) (value int, err error) {
+	if host.Syscall == nil {
+		return 0, ErrNoSyscall
+	}
+
+	bV, err := registers.Get(b)
+	if err != nil {
+		return 0, err
+	}
+
+	return host.Syscall(a, bV, registers)
+}
+
+// Runs a registered op code, giving op codes which talk to the host the host (which can be nil)
+func executeRegistered(instruction *Instruction, registers Registers, host *Host) (value int, err error) {
+	registered := registeredOpCodes[instruction.OpCode]
+	if registered.HostFunc == nil {
+		return registered.Func(instruction.A, instruction.B, registers)
+	}
+
+	if host == nil {
+		return 0, ErrNoHost
+	}
+
+	return registered.HostFunc(host, instruction.A, instruction.B, registers)
+}
+
+// Finds the first instruction which talks to the host, which transpiled and decompiled code can't do
+func (p Program) findHostOpCode() (ip int, found bool) {
+	for ip, instruction := range p {
+		if instruction.OpCode.talksToHost() {
+			return ip, true
+		}
+	}
+
+	return 0, false
+}
+
+// Does the op code talk to the host? If so it can't be evaluated ahead of time
+func (o OpCode) talksToHost() bool {
+	registered, found := registeredOpCodes[o]
+	return found && registered.HostFunc != nil
+}
+
+// IO from a fixed list of inputs, which records everything written out. Useful for testing programs
+type ScriptedIO struct {
+	Inputs  []int // The inputs not yet read
+	Outputs []int // Everything written out so far
+}
+
+func (s *ScriptedIO) Input() (value int, err error) {
+	if len(s.Inputs) == 0 {
+		return 0, io.EOF
+	}
+
+	value, s.Inputs = s.Inputs[0], s.Inputs[1:]
+	return value, nil
+}
+
+func (s *ScriptedIO) Output(value int) error {
+	s.Outputs = append(s.Outputs, value)
+	return nil
+}
+
+// IO which reads whitespace separated numbers and writes a number per line, such as for running programs interactively
+type streamIO struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+// Creates IO reading from `r` and writing to `w`
+func NewStreamIO(r io.Reader, w io.Writer) IO {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanWords)
+
+	return &streamIO{scanner, w}
+}
+
+func (s *streamIO) Input() (value int, err error) {
+	if !s.in.Scan() {
+		if err = s.in.Err(); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+
+	value, err = strconv.Atoi(s.in.Text())
+	if err != nil {
+		return 0, fmt.Errorf("invalid input %q", s.in.Text())
+	}
+
+	return value, nil
+}
+
+func (s *streamIO) Output(value int) error {
+	_, err := fmt.Fprintln(s.out, value)
+	return err
+}
@@ -0,0 +1,188 @@
+package elf_code
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Writes out double every input, until a zero is read. R[0] counts the values written
+const echoTestProgram = `#ip 5
+seti 0 0 0
+in 0 0 1
+eqri 1 0 2
+addr 2 5 5
+seti 5 0 5
+seti 99 0 5
+muli 1 2 1
+outr 1 0 1
+addi 0 1 0
+seti 0 0 5`
+
+// Registers the I/O op codes until the test finishes
+func registerIOTestOpCodes(t *testing.T) IOOpCodes {
+	alreadyRegistered := ioOpCodes.registered()
+
+	opCodes, err := RegisterIOOpCodes()
+	if err != nil {
+		t.Fatalf("RegisterIOOpCodes() error = %v", err)
+	}
+
+	if alreadyRegistered {
+		return opCodes
+	}
+
+	t.Cleanup(func() {
+		for _, opCode := range []OpCode{opCodes.In, opCodes.OutR, opCodes.OutI, opCodes.Sys} {
+			if err := UnregisterOpCode(opCode); err != nil {
+				t.Errorf("UnregisterOpCode() error = %v", err)
+			}
+		}
+	})
+
+	return opCodes
+}
+
+func newHostTestCPU(t *testing.T, program string, host Host) *CPU {
+	registerIOTestOpCodes(t)
+
+	cpu, err := NewCPUFromProgramFile(program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+	cpu.Host = host
+
+	return cpu
+}
+
+func TestRegisterIOOpCodes(t *testing.T) {
+	first := registerIOTestOpCodes(t)
+
+	second, err := RegisterIOOpCodes()
+	if err != nil || second != first {
+		t.Errorf("RegisterIOOpCodes() again = %v, %v, want %v", second, err, first)
+	}
+
+	if first.In.String() != "in" || first.OutR.String() != "outr" || first.OutI.String() != "outi" || first.Sys.String() != "sys" {
+		t.Errorf("RegisterIOOpCodes() = %v %v %v %v, want in outr outi sys", first.In, first.OutR, first.OutI, first.Sys)
+	}
+}
+
+func TestCPU_Host_IO(t *testing.T) {
+	tests := []struct {
+		name    string
+		execute func(cpu *CPU) error
+	}{
+		{"Interpreter", func(cpu *CPU) error { return cpu.Execute() }},
+		{"Fast", func(cpu *CPU) error { return cpu.ExecuteFast() }},
+		{"JIT", func(cpu *CPU) error {
+			_, err := cpu.ExecuteJIT(JITOptions{HotLoopThreshold: 1})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scripted := &ScriptedIO{Inputs: []int{3, 5, 0}}
+			cpu := newHostTestCPU(t, echoTestProgram, Host{IO: scripted})
+
+			if err := tt.execute(cpu); err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(scripted.Outputs, []int{6, 10}) || cpu.Registers[0] != 2 {
+				t.Errorf("Execute() outputs = %v, R[0] = %v, want [6 10] and 2", scripted.Outputs, cpu.Registers[0])
+			}
+		})
+	}
+}
+
+func TestCPU_Host_Errors(t *testing.T) {
+	cpu := newHostTestCPU(t, echoTestProgram, Host{IO: &ScriptedIO{Inputs: []int{3}}})
+	if err := cpu.Execute(); err != io.EOF {
+		t.Errorf("CPU.Execute() error = %v, want %v", err, io.EOF)
+	}
+
+	cpu = newHostTestCPU(t, echoTestProgram, Host{})
+	if err := cpu.Execute(); err != ErrNoIO {
+		t.Errorf("CPU.Execute() error = %v, want %v", err, ErrNoIO)
+	}
+
+	cpu = newHostTestCPU(t, "#ip 5\nsys 1 0 0", Host{})
+	if err := cpu.Execute(); err != ErrNoSyscall {
+		t.Errorf("CPU.Execute() error = %v, want %v", err, ErrNoSyscall)
+	}
+
+	// Programs decoded without a CPU have no host
+	decoded, err := DecodeProgram(cpu.Program, 5, 6)
+	if err != nil {
+		t.Fatalf("DecodeProgram() error = %v", err)
+	}
+	if _, err := decoded.Run(NewRegisters(6), 0); err != ErrNoHost {
+		t.Errorf("DecodedProgram.Run() error = %v, want %v", err, ErrNoHost)
+	}
+}
+
+func TestCPU_Host_Syscall(t *testing.T) {
+	var calls []int
+	host := Host{Syscall: func(number int, argument int, registers Registers) (result int, err error) {
+		calls = append(calls, number, argument)
+		return number * argument, nil
+	}}
+
+	cpu := newHostTestCPU(t, "#ip 5\nseti 7 0 1\nsys 3 1 2\nsys 4 2 3", host)
+	if err := cpu.Execute(); err != nil {
+		t.Fatalf("CPU.Execute() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(calls, []int{3, 7, 4, 21}) || cpu.Registers[3] != 84 {
+		t.Errorf("CPU.Execute() syscalls = %v, R[3] = %v, want [3 7 4 21] and 84", calls, cpu.Registers[3])
+	}
+}
+
+func TestNewStreamIO(t *testing.T) {
+	var out bytes.Buffer
+	cpu := newHostTestCPU(t, echoTestProgram, Host{IO: NewStreamIO(strings.NewReader("3 5\n-4\n0\n"), &out)})
+
+	if err := cpu.Execute(); err != nil {
+		t.Fatalf("CPU.Execute() error = %v", err)
+	}
+
+	if got, want := out.String(), "6\n10\n-8\n"; got != want {
+		t.Errorf("CPU.Execute() output = %q, want %q", got, want)
+	}
+
+	cpu = newHostTestCPU(t, echoTestProgram, Host{IO: NewStreamIO(strings.NewReader("3 x"), &out)})
+	if err := cpu.Execute(); err == nil || err.Error() != `invalid input "x"` {
+		t.Errorf("CPU.Execute() error = %v, want invalid input", err)
+	}
+}
+
+func TestCPU_Host_Tools(t *testing.T) {
+	cpu := newHostTestCPU(t, echoTestProgram, Host{})
+
+	// Symbolic execution stops at the first host call
+	paths, err := cpu.ExecuteSymbolic(SymbolicOptions{})
+	if err != nil {
+		t.Fatalf("CPU.ExecuteSymbolic() error = %v", err)
+	}
+	if len(paths) != 1 || paths[0].String() != "host call at 1 after 1 instructions" {
+		t.Errorf("CPU.ExecuteSymbolic() = %v, want a host call at 1", paths)
+	}
+
+	if got := cpu.Disassemble().Lines[7].Comment; got != "r1 = outr(r1, 0)" {
+		t.Errorf("CPU.Disassemble() line 7 = %q, want %q", got, "r1 = outr(r1, 0)")
+	}
+
+	// None of the transpiler backends can give the program a host
+	_, err = cpu.StartTranspiler(allTranspileOptions()).Run()
+	if want := "unable to transpile in at 1, as it talks to the host"; err == nil || err.Error() != want {
+		t.Errorf("TranspileState.Run() error = %v, want %v", err, want)
+	}
+
+	_, err = cpu.Decompile()
+	if want := "unable to decompile in at 1, as it talks to the host"; err == nil || err.Error() != want {
+		t.Errorf("CPU.Decompile() error = %v, want %v", err, want)
+	}
+}
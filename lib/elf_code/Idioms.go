@@ -0,0 +1,564 @@
+package elf_code
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A high level operation which replaces a loop in the transpiled program, giving the same
+// registers once it completes as the loop would have
+type Idiom interface {
+	Name() string                   // A short description of the idiom
+	Apply(registers Registers)      // Updates the registers as the loop would
+	Assignments() []IdiomAssignment // The register updates the idiom makes, in order
+}
+
+// A register update made by an idiom. The expressions only use `R[n]`, integers, `+`, `-` and
+// calls to the helper functions `maxInt`, `floorDiv`, `divisorHit` and `shiftLeft`, which each backend
+// writes out when a program uses idioms
+type IdiomAssignment struct {
+	Register   int
+	Expression string
+}
+
+// Sums `Factor` into `Sum` when `Factor * Counter == Target` for any value of the counter from
+// its starting value up to `Target`. This is the inner loop of a naive sum of divisors:
+//
+//	do {
+//	    R[t] = R[i] * R[j]
+//	    R[t] = R[t] == R[n]
+//	    if (R[t] == 1) { R[s] += R[i] }
+//	    R[j]++
+//	    R[t] = R[j] > R[n]
+//	} while (R[t] == 0)
+type DivisorSumIdiom struct {
+	Factor, Counter, Target, Sum, Condition int
+}
+
+func (DivisorSumIdiom) Name() string {
+	return "divisor sum"
+}
+
+func (i DivisorSumIdiom) Apply(r Registers) {
+	r[i.Sum] += divisorHit(r[i.Factor], r[i.Counter], r[i.Target])
+	r[i.Counter] = maxInt(r[i.Counter], r[i.Target]) + 1
+	r[i.Condition] = 1
+}
+
+func (i DivisorSumIdiom) Assignments() []IdiomAssignment {
+	return []IdiomAssignment{
+		{i.Sum, fmt.Sprintf("R[%d] + divisorHit(R[%d], R[%d], R[%d])", i.Sum, i.Factor, i.Counter, i.Target)},
+		{i.Counter, fmt.Sprintf("maxInt(R[%d], R[%d]) + 1", i.Counter, i.Target)},
+		{i.Condition, "1"},
+	}
+}
+
+// Finds the smallest quotient, no less than its starting value, where `(Quotient + 1) * Divisor > Dividend`
+// by counting up one at a time. The result is the dividend divided by the divisor:
+//
+//	R[t] = R[q] + 1
+//	R[t] *= 256
+//	R[t] = R[t] > R[x]
+//	if (R[t] == 1) { ... } else { R[q]++; loop }
+type RepeatedAdditionDivisionIdiom struct {
+	Quotient, Dividend, Condition int
+	Divisor                       int // The constant divisor, which is always positive
+}
+
+func (RepeatedAdditionDivisionIdiom) Name() string {
+	return "division by repeated addition"
+}
+
+func (i RepeatedAdditionDivisionIdiom) Apply(r Registers) {
+	r[i.Quotient] = maxInt(r[i.Quotient], floorDiv(r[i.Dividend], i.Divisor))
+	r[i.Condition] = 1
+}
+
+func (i RepeatedAdditionDivisionIdiom) Assignments() []IdiomAssignment {
+	return []IdiomAssignment{
+		{i.Quotient, fmt.Sprintf("maxInt(R[%d], floorDiv(R[%d], %d))", i.Quotient, i.Dividend, i.Divisor)},
+		{i.Condition, "1"},
+	}
+}
+
+// Doubles `Value` while counting up `Counter` until it is greater than the limit, which is a
+// shift left by the number of iterations:
+//
+//	do {
+//	    R[x] *= 2
+//	    R[c]++
+//	    R[t] = R[c] > 10
+//	} while (R[t] == 0)
+type ShiftLeftIdiom struct {
+	Value, Counter, Condition int
+	Limit                     int  // The limit the counter is compared to
+	LimitIsRegister           bool // Is the limit a register rather than a constant?
+}
+
+func (ShiftLeftIdiom) Name() string {
+	return "shift left"
+}
+
+func (i ShiftLeftIdiom) limit(r Registers) int {
+	if i.LimitIsRegister {
+		return r[i.Limit]
+	}
+
+	return i.Limit
+}
+
+func (i ShiftLeftIdiom) Apply(r Registers) {
+	iterations := maxInt(1, i.limit(r)-r[i.Counter]+1)
+	r[i.Value] = shiftLeft(r[i.Value], iterations)
+	r[i.Counter] += iterations
+	r[i.Condition] = 1
+}
+
+func (i ShiftLeftIdiom) Assignments() []IdiomAssignment {
+	limit := fmt.Sprintf("%d", i.Limit)
+	if i.LimitIsRegister {
+		limit = fmt.Sprintf("R[%d]", i.Limit)
+	}
+	iterations := fmt.Sprintf("maxInt(1, %s - R[%d] + 1)", limit, i.Counter)
+
+	return []IdiomAssignment{
+		{i.Value, fmt.Sprintf("shiftLeft(R[%d], %s)", i.Value, iterations)},
+		{i.Counter, fmt.Sprintf("R[%d] + %s", i.Counter, iterations)},
+		{i.Condition, "1"},
+	}
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+// Division rounding towards negative infinity
+func floorDiv(a int, b int) int {
+	quotient := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		quotient--
+	}
+
+	return quotient
+}
+
+// Returns `factor` if `factor * j == target` for some j between `from` and max(from, target), otherwise 0
+func divisorHit(factor int, from int, target int) int {
+	if factor == 0 || target%factor != 0 {
+		return 0
+	}
+
+	j := target / factor
+	if j >= from && j <= maxInt(from, target) {
+		return factor
+	}
+
+	return 0
+}
+
+func shiftLeft(value int, places int) int {
+	return value << uint(places)
+}
+
+// Replaces loops in the program which match a known idiom with an idiom statement
+func (t *TranspileState) recogniseIdioms() (changes int) {
+	for _, b := range t.blocks {
+		for line := b.firstLine; line != nil; line = line.next {
+			if line.lineType != DoWhileStatement {
+				continue
+			}
+
+			body := line.jumpToBlock.statements()
+
+			var idiom Idiom
+			if i, ok := t.matchDivisorSum(line, body); ok {
+				idiom = i
+			} else if i, ok := t.matchShiftLeft(line, body); ok {
+				idiom = i
+			}
+
+			if idiom != nil {
+				line.forgetCallsOut()
+				line.lineType = IdiomStatement
+				line.idiom = idiom
+				changes++
+			}
+		}
+
+		if t.rewriteRepeatedAdditionDivision(b) {
+			changes++
+		}
+	}
+
+	return
+}
+
+// The lines within the block
+func (b *ProgramBlock) statements() (lines []*ProgramLine) {
+	for line := b.firstLine; line != nil; line = line.next {
+		lines = append(lines, line)
+	}
+
+	return
+}
+
+// Is the line a statement running the op code, which doesn't use the instruction pointer?
+func (t *TranspileState) isStatement(line *ProgramLine, opCode OpCode) bool {
+	if line.lineType != Statement || line.instruction.OpCode != opCode {
+		return false
+	}
+
+	// The instruction pointer changes between statements, so idioms can't use it
+	ipRegister := t.cpu.InstructionPointerRegister
+	isImmediate := OpCodeInputType[opCode]
+	return (isImmediate.A || line.instruction.A != ipRegister) &&
+		(isImmediate.B || line.instruction.B != ipRegister) &&
+		line.instruction.C != ipRegister
+}
+
+// Are all of the registers different from each other?
+func distinctRegisters(registers ...int) bool {
+	for i := range registers {
+		for j := i + 1; j < len(registers); j++ {
+			if registers[i] == registers[j] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Returns the other input of a two register instruction which has `register` as an input
+func otherInput(instruction *Instruction, register int) (other int, ok bool) {
+	switch register {
+	case instruction.A:
+		return instruction.B, true
+	case instruction.B:
+		return instruction.A, true
+	default:
+		return 0, false
+	}
+}
+
+func (t *TranspileState) matchDivisorSum(loop *ProgramLine, body []*ProgramLine) (idiom DivisorSumIdiom, ok bool) {
+	if len(body) != 5 || !loop.invertCondition ||
+		!t.isStatement(body[0], MulR) || !t.isStatement(body[1], EqRR) ||
+		body[2].lineType != IfStatement || !t.isStatement(body[3], AddI) || !t.isStatement(body[4], GtRR) {
+		return
+	}
+
+	// R[j]++ then R[t] = R[j] > R[n]
+	counter, target, condition := body[3].instruction.C, body[4].instruction.B, body[4].instruction.C
+	if body[3].instruction.A != counter || body[3].instruction.B != 1 || body[4].instruction.A != counter ||
+		loop.instruction.A != condition {
+		return
+	}
+
+	// R[t] = R[i] * R[j]
+	factor, found := otherInput(body[0].instruction, counter)
+	if !found || body[0].instruction.C != condition {
+		return
+	}
+
+	// R[t] = R[t] == R[n]
+	if other, found := otherInput(body[1].instruction, condition); !found || other != target || body[1].instruction.C != condition {
+		return
+	}
+
+	// if (R[t] == 1) { R[s] += R[i] }
+	branch := body[2]
+	if branch.instruction.A != condition || branch.jumpToBlock == nil || branch.jumpToBlock.blockType != InlineBlock ||
+		(branch.elseBlock != nil && branch.elseBlock.firstLine != nil) {
+		return
+	}
+
+	add := branch.jumpToBlock.statements()
+	if len(add) != 1 || !t.isStatement(add[0], AddR) {
+		return
+	}
+
+	sum := add[0].instruction.C
+	if other, found := otherInput(add[0].instruction, sum); !found || other != factor {
+		return
+	}
+
+	if !distinctRegisters(factor, counter, target, sum, condition) {
+		return
+	}
+
+	return DivisorSumIdiom{factor, counter, target, sum, condition}, true
+}
+
+func (t *TranspileState) matchShiftLeft(loop *ProgramLine, body []*ProgramLine) (idiom ShiftLeftIdiom, ok bool) {
+	if len(body) != 3 || !loop.invertCondition {
+		return
+	}
+
+	// The doubling and the count can be in either order
+	double, count := body[0], body[1]
+	if t.isStatement(double, AddI) {
+		double, count = count, double
+	}
+
+	isDouble := (t.isStatement(double, MulI) && double.instruction.B == 2 && double.instruction.A == double.instruction.C) ||
+		(t.isStatement(double, AddR) && double.instruction.A == double.instruction.C && double.instruction.B == double.instruction.C)
+	if !isDouble || !t.isStatement(count, AddI) || count.instruction.A != count.instruction.C || count.instruction.B != 1 {
+		return
+	}
+
+	idiom.Value, idiom.Counter = double.instruction.C, count.instruction.C
+
+	// R[t] = R[c] > limit
+	compare := body[2]
+	switch {
+	case t.isStatement(compare, GtRI):
+		idiom.Limit = compare.instruction.B
+	case t.isStatement(compare, GtRR):
+		idiom.Limit, idiom.LimitIsRegister = compare.instruction.B, true
+	default:
+		return
+	}
+
+	idiom.Condition = compare.instruction.C
+	if compare.instruction.A != idiom.Counter || loop.instruction.A != idiom.Condition {
+		return
+	}
+
+	registers := []int{idiom.Value, idiom.Counter, idiom.Condition}
+	if idiom.LimitIsRegister {
+		registers = append(registers, idiom.Limit)
+	}
+
+	return idiom, distinctRegisters(registers...)
+}
+
+// Looks for a block which counts up a quotient until it's found, then rewrites it to work out
+// the quotient and carry on with the rest of the program
+func (t *TranspileState) rewriteRepeatedAdditionDivision(b *ProgramBlock) bool {
+	lines := b.statements()
+	if len(lines) != 4 || !t.isStatement(lines[0], AddI) || !t.isStatement(lines[1], MulI) ||
+		!t.isStatement(lines[2], GtRR) || lines[3].lineType != IfStatement {
+		return false
+	}
+
+	// R[t] = R[q] + 1, R[t] *= D, R[t] = R[t] > R[x]
+	quotient, condition := lines[0].instruction.A, lines[0].instruction.C
+	divisor, dividend := lines[1].instruction.B, lines[2].instruction.B
+	if lines[0].instruction.B != 1 || lines[1].instruction.A != condition || lines[1].instruction.C != condition ||
+		lines[2].instruction.A != condition || lines[2].instruction.C != condition || divisor <= 0 ||
+		!distinctRegisters(quotient, condition, dividend) {
+		return false
+	}
+
+	// if (R[t] == 1) { found } else { R[q]++; loop }
+	branch := lines[3]
+	if branch.instruction.A != condition || branch.jumpToBlock == nil || branch.elseBlock == nil ||
+		branch.elseBlock.blockType != InlineBlock {
+		return false
+	}
+
+	increment := branch.elseBlock.statements()
+	if len(increment) != 2 || !t.isStatement(increment[0], AddI) || increment[0].instruction.A != quotient ||
+		increment[0].instruction.C != quotient || increment[0].instruction.B != 1 ||
+		increment[1].lineType != JumpStatement || increment[1].jumpToBlock != b {
+		return false
+	}
+
+	// The idiom replaces the first statement, then the branch always continues with the rest of the program
+	idiom := lines[0]
+	idiom.lineType = IdiomStatement
+	idiom.idiom = RepeatedAdditionDivisionIdiom{quotient, dividend, condition, divisor}
+	lines[1].RemoveUnusedLine()
+	lines[2].RemoveUnusedLine()
+
+	for _, line := range increment {
+		line.forgetCallsOut()
+	}
+	branch.elseBlock.removeCallFrom(branch)
+	branch.elseBlock = nil
+	branch.lineType = JumpStatement
+
+	return true
+}
+
+// Writes the idiom's assignments as `R[n] = expression`, followed by `end` on each line
+func writeIdiomAssignments(t *TranspileState, line *ProgramLine, indent string, end string, str *strings.Builder) {
+	for index, assignment := range line.idiom.Assignments() {
+		str.WriteString(fmt.Sprintf("%sR[%d] = %s%s", indent, assignment.Register, assignment.Expression, end))
+		if index == 0 {
+			str.WriteString(fmt.Sprintf(" // %s: %s", t.ipComment(line.ip), line.idiom.Name()))
+		}
+		str.WriteRune('\n')
+	}
+}
+
+// A loop in the program which can be run as an idiom by the decoded fast path and the JIT
+type idiomLoop struct {
+	idiom        Idiom
+	length       int                   // The number of instructions in the loop, from where it starts
+	exit         int                   // The instruction pointer the loop carries on from
+	instructions func(r Registers) int // The number of instructions the loop would run, from the registers at its start
+}
+
+// Finds the loops in the program matching an idiom, indexed by the instruction pointer they start at. Unlike
+// `TranspileState.recogniseIdioms` these match the instructions as written, so the idiom gives exactly the
+// same registers (and instruction count) as running the loop from its first instruction
+func findIdiomLoops(program Program, ipRegister int) []*idiomLoop {
+	loops := make([]*idiomLoop, len(program))
+
+	for start := range program {
+		if loop, ok := matchDivisorSumLoop(program, start, ipRegister); ok {
+			loops[start] = loop
+		} else if loop, ok := matchShiftLeftLoop(program, start, ipRegister); ok {
+			loops[start] = loop
+		} else if loop, ok := matchDivisionLoop(program, start, ipRegister); ok {
+			loops[start] = loop
+		}
+	}
+
+	return loops
+}
+
+// Returns the instructions from `start` if the program has `length` of them there
+func loopInstructions(program Program, start int, length int) []Instruction {
+	if start+length > len(program) {
+		return nil
+	}
+
+	return program[start : start+length]
+}
+
+// Is the instruction `op a b c` with `a` and `b` in either order?
+func isCommutedInstruction(instruction Instruction, opCode OpCode, a int, b int, c int) bool {
+	return instruction.OpCode == opCode && instruction.C == c &&
+		((instruction.A == a && instruction.B == b) || (instruction.A == b && instruction.B == a))
+}
+
+// Is the instruction `addr register ip ip`, skipping the next instruction when the register holds 1?
+func isSkip(instruction Instruction, register int, ipRegister int) bool {
+	return isCommutedInstruction(instruction, AddR, register, ipRegister, ipRegister)
+}
+
+// Is the instruction a jump back to `start`?
+func isJumpTo(instruction Instruction, start int, ipRegister int) bool {
+	return instruction.OpCode == SetI && instruction.A == start-1 && instruction.C == ipRegister
+}
+
+// mulr i j t, eqrr t n t, addr t ip ip, addi ip 1 ip, addr i s s, addi j 1 j, gtrr j n t, addr ip t ip, seti start-1 _ ip
+func matchDivisorSumLoop(program Program, start int, ipRegister int) (loop *idiomLoop, ok bool) {
+	code := loopInstructions(program, start, 9)
+	if code == nil || code[0].OpCode != MulR || code[5].OpCode != AddI {
+		return
+	}
+
+	counter, condition := code[5].C, code[0].C
+	factor, found := otherInput(&code[0], counter)
+	if !found || code[6].OpCode != GtRR || code[6].A != counter || code[6].C != condition {
+		return
+	}
+
+	target, sum := code[6].B, code[4].C
+	if !isCommutedInstruction(code[1], EqRR, condition, target, condition) || !isSkip(code[2], condition, ipRegister) ||
+		code[3] != (Instruction{AddI, ipRegister, 1, ipRegister}) || !isCommutedInstruction(code[4], AddR, factor, sum, sum) ||
+		code[5] != (Instruction{AddI, counter, 1, counter}) || !isSkip(code[7], condition, ipRegister) ||
+		!isJumpTo(code[8], start, ipRegister) || !distinctRegisters(factor, counter, target, sum, condition, ipRegister) {
+		return
+	}
+
+	idiom := DivisorSumIdiom{factor, counter, target, sum, condition}
+	return &idiomLoop{idiom, 9, start + 9, func(r Registers) int {
+		// Every iteration runs 8 instructions, apart from the last which doesn't jump back
+		iterations := maxInt(r[counter], r[target]) + 1 - r[counter]
+		return 8*iterations - 1
+	}}, true
+}
+
+// muli x 2 x (or addr x x x), addi c 1 c (in either order), gtri c limit t (or gtrr), addr t ip ip, seti start-1 _ ip
+func matchShiftLeftLoop(program Program, start int, ipRegister int) (loop *idiomLoop, ok bool) {
+	code := loopInstructions(program, start, 5)
+	if code == nil {
+		return
+	}
+
+	double, count := code[0], code[1]
+	if double.OpCode == AddI {
+		double, count = count, double
+	}
+
+	isDouble := double == (Instruction{MulI, double.C, 2, double.C}) || double == (Instruction{AddR, double.C, double.C, double.C})
+	if !isDouble || count != (Instruction{AddI, count.C, 1, count.C}) {
+		return
+	}
+
+	idiom := ShiftLeftIdiom{Value: double.C, Counter: count.C, Condition: code[2].C, Limit: code[2].B}
+	switch code[2].OpCode {
+	case GtRI:
+	case GtRR:
+		idiom.LimitIsRegister = true
+	default:
+		return
+	}
+
+	registers := []int{idiom.Value, idiom.Counter, idiom.Condition, ipRegister}
+	if idiom.LimitIsRegister {
+		registers = append(registers, idiom.Limit)
+	}
+
+	if code[2].A != idiom.Counter || !isSkip(code[3], idiom.Condition, ipRegister) || !isJumpTo(code[4], start, ipRegister) ||
+		!distinctRegisters(registers...) {
+		return
+	}
+
+	return &idiomLoop{idiom, 5, start + 5, func(r Registers) int {
+		// Every iteration runs 5 instructions, apart from the last which doesn't jump back
+		return 5*maxInt(1, idiom.limit(r)-r[idiom.Counter]+1) - 1
+	}}, true
+}
+
+// addi q 1 t, muli t D t, gtrr t x t, addr t ip ip, addi ip 1 ip, seti exit-1 _ ip, addi q 1 q, seti start-1 _ ip
+func matchDivisionLoop(program Program, start int, ipRegister int) (loop *idiomLoop, ok bool) {
+	code := loopInstructions(program, start, 8)
+	if code == nil || code[0].OpCode != AddI || code[0].B != 1 {
+		return
+	}
+
+	quotient, condition, divisor, dividend := code[0].A, code[0].C, code[1].B, code[2].B
+	exit := code[5].A + 1
+	if code[1] != (Instruction{MulI, condition, divisor, condition}) || divisor <= 0 ||
+		code[2] != (Instruction{GtRR, condition, dividend, condition}) || !isSkip(code[3], condition, ipRegister) ||
+		code[4] != (Instruction{AddI, ipRegister, 1, ipRegister}) || code[5].OpCode != SetI || code[5].C != ipRegister ||
+		code[6] != (Instruction{AddI, quotient, 1, quotient}) || !isJumpTo(code[7], start, ipRegister) ||
+		(exit >= start && exit < start+8) || !distinctRegisters(quotient, condition, dividend, ipRegister) {
+		return
+	}
+
+	idiom := RepeatedAdditionDivisionIdiom{quotient, dividend, condition, divisor}
+	return &idiomLoop{idiom, 8, exit, func(r Registers) int {
+		// Every time the quotient is counted up runs 7 instructions, then 5 more once it's found
+		return 7*(maxInt(r[quotient], floorDiv(r[dividend], divisor))-r[quotient]) + 5
+	}}, true
+}
+
+// Runs the loop starting at `ip` as an idiom if there is one, and it fits in the budget (if not negative). Returns
+// the instruction pointer to carry on from and the number of instructions the loop would have run, or 0 if the
+// idiom wasn't used. Idioms aren't used when the registers wrap, as their closed forms don't
+func (d *DecodedProgram) runIdiom(ip int, r Registers, budget int) (next int, executed int) {
+	loop := d.idioms[ip]
+	if loop == nil || d.word.Bits != 0 {
+		return ip, 0
+	}
+
+	executed = loop.instructions(r)
+	if budget >= 0 && executed > budget {
+		return ip, 0
+	}
+
+	loop.idiom.Apply(r)
+	r[d.ipRegister] = loop.exit - 1
+	return loop.exit, executed
+}
@@ -0,0 +1,258 @@
+package elf_code
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The test programs start with an instruction which does nothing, as the transpiler can't
+// rewrite loops which jump back to the start of the program
+
+// The inner loop of day 19, summing R[1] into R[0] if R[1] * R[5] == R[2] for any R[5] up to R[2]
+const divisorSumProgram = `#ip 3
+setr 5 0 5
+mulr 1 5 4
+eqrr 4 2 4
+addr 4 3 3
+addi 3 1 3
+addr 1 0 0
+addi 5 1 5
+gtrr 5 2 4
+addr 3 4 3
+seti 0 0 3`
+
+// Divides R[5] by 7 into R[1] by counting up, as day 21 does, then copies the result into R[4]
+const divisionProgram = `#ip 2
+seti 0 0 1
+addi 1 1 3
+muli 3 7 3
+gtrr 3 5 3
+addr 3 2 2
+addi 2 1 2
+seti 8 0 2
+addi 1 1 1
+seti 0 0 2
+setr 1 0 4`
+
+// Doubles R[0] until R[1] counts past R[3]
+const shiftLeftProgram = `#ip 5
+setr 0 0 0
+muli 0 2 0
+addi 1 1 1
+gtrr 1 3 2
+addr 2 5 5
+seti 0 0 5`
+
+func idiomTranspileOptions() TranspileOptions {
+	options := allTranspileOptions()
+	options.RecogniseIdioms = true
+	return options
+}
+
+func TestTranspileState_RecogniseIdioms(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		unknown []int
+		boolean []int
+		want    []string
+	}{
+		{"Day 19", day19Program, nil, []int{0}, []string{
+			"R[0] = R[0] + divisorHit(R[1], R[5], R[2]) // IP: 3 (mulr 1 5 4): divisor sum",
+			"R[5] = maxInt(R[5], R[2]) + 1",
+		}},
+		{"Day 21", day21Program, []int{0}, nil, []string{
+			"R[1] = maxInt(R[1], floorDiv(R[5], 256)) // IP: 18 (addi 1 1 3): division by repeated addition",
+		}},
+		{"Divisor sum", divisorSumProgram, []int{0, 1, 2, 4, 5}, nil, []string{"R[0] = R[0] + divisorHit(R[1], R[5], R[2]) // IP: 1 (mulr 1 5 4): divisor sum"}},
+		{"Division", divisionProgram, []int{1, 3, 4, 5}, nil, []string{"R[1] = maxInt(R[1], floorDiv(R[5], 7))"}},
+		{"Shift left", shiftLeftProgram, []int{0, 1, 2, 3}, nil, []string{
+			"R[0] = shiftLeft(R[0], maxInt(1, R[3] - R[1] + 1)) // IP: 1 (muli 0 2 0): shift left",
+			"R[1] = R[1] + maxInt(1, R[3] - R[1] + 1)",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			options := idiomTranspileOptions()
+			options.Backend = PseudoCodeBackend{}
+			transpiler := cpu.StartTranspiler(options)
+			for _, register := range tt.unknown {
+				transpiler.Registers[register].SetUnknownInt()
+			}
+			for _, register := range tt.boolean {
+				transpiler.Registers[register].SetUnknownBool()
+			}
+
+			source, err := transpiler.Run()
+			if err != nil {
+				t.Fatalf("TranspileState.Run() error = %v", err)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(source, want) {
+					t.Errorf("TranspileState.Run() = %s\nwant it to contain %q", source, want)
+				}
+			}
+		})
+	}
+}
+
+// Random registers between -10 and 30, with the instruction pointer at the start of the program
+func randomSignedInputs(seed int64, count int, ipRegister int) []Registers {
+	random := rand.New(rand.NewSource(seed))
+	inputs := make([]Registers, count)
+
+	for i := range inputs {
+		inputs[i] = NewRegisters(6)
+		for register := range inputs[i] {
+			if register != ipRegister {
+				inputs[i][register] = random.Intn(41) - 10
+			}
+		}
+	}
+
+	return inputs
+}
+
+func TestTranspileState_RecogniseIdioms_Verify(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		unknown []int
+	}{
+		{"Divisor sum", divisorSumProgram, []int{0, 1, 2, 4, 5}},
+		{"Division", divisionProgram, []int{1, 3, 4, 5}},
+		{"Shift left", shiftLeftProgram, []int{0, 1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			result, err := Verify(cpu.Program, cpu.InstructionPointerRegister, len(cpu.Registers), VerifyOptions{
+				Transpile:        idiomTranspileOptions(),
+				Inputs:           randomSignedInputs(12, 200, cpu.InstructionPointerRegister),
+				UnknownRegisters: tt.unknown,
+				MaxInstructions:  100000,
+			})
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+
+			if result.Divergence != nil {
+				t.Errorf("Verify() divergence = %v", result.Divergence)
+			}
+
+			if result.Checked == 0 {
+				t.Errorf("Verify() checked = 0, skipped = %d, want inputs to be checked", result.Skipped)
+			}
+		})
+	}
+}
+
+func TestTranspileState_Execute_Day19Part2(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the full day 19 part 2")
+	}
+
+	cpu, err := NewCPUFromProgramFile(day19Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+	cpu.Registers[0] = 1
+
+	transpiler := cpu.StartTranspiler(idiomTranspileOptions())
+	registers := cpu.Registers.Copy()
+	if _, err := transpiler.Execute(registers, 0); err != nil {
+		t.Fatalf("TranspileState.Execute() error = %v", err)
+	}
+
+	// The program sums the divisors of 10551282
+	want := 0
+	for i := 1; i <= 10551282; i++ {
+		if 10551282%i == 0 {
+			want += i
+		}
+	}
+
+	if registers[0] != want {
+		t.Errorf("TranspileState.Execute() R[0] = %d, want %d", registers[0], want)
+	}
+}
+
+func TestDecodedProgram_Run_Idioms(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		start   int
+	}{
+		{"Divisor sum", divisorSumProgram, 1},
+		{"Division", divisionProgram, 1},
+		{"Shift left", shiftLeftProgram, 1},
+		{"Day 19", smallDay19Program, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			decoded, err := cpu.Decode()
+			if err != nil {
+				t.Fatalf("CPU.Decode() error = %v", err)
+			}
+
+			if decoded.idioms[tt.start] == nil {
+				t.Fatalf("DecodeProgram() found no idiom at %d", tt.start)
+			}
+
+			// Without the idioms every instruction is interpreted
+			interpreted, _ := cpu.Decode()
+			interpreted.idioms = make([]*idiomLoop, len(cpu.Program))
+			jit := decoded.NewJIT(JITOptions{HotLoopThreshold: 1})
+
+			for _, input := range randomSignedInputs(7, 100, cpu.InstructionPointerRegister) {
+				want := input.Copy()
+				wantExecuted, wantErr := interpreted.Run(want, 100000)
+				if wantErr != nil {
+					continue
+				}
+
+				got := input.Copy()
+				gotExecuted, err := decoded.Run(got, 100000)
+				if err != nil || !reflect.DeepEqual(got, want) || gotExecuted != wantExecuted {
+					t.Fatalf("DecodedProgram.Run(%v) = %v, %d, %v, want %v, %d", input, got, gotExecuted, err, want, wantExecuted)
+				}
+
+				got = input.Copy()
+				gotExecuted, err = jit.Run(got, 0)
+				if err != nil || !reflect.DeepEqual(got, want) || gotExecuted != wantExecuted {
+					t.Fatalf("JIT.Run(%v) = %v, %d, %v, want %v, %d", input, got, gotExecuted, err, want, wantExecuted)
+				}
+
+				// Stopping part way through an idiom's loop runs it instruction by instruction instead
+				limit := wantExecuted/2 + 1
+				want, got = input.Copy(), input.Copy()
+				_, wantErr = interpreted.Run(want, limit)
+				_, err = decoded.Run(got, limit)
+				if !reflect.DeepEqual(err, wantErr) {
+					t.Fatalf("DecodedProgram.Run(%v, %d) error = %v, want %v", input, limit, err, wantErr)
+				}
+			}
+
+			if jit.Stats.IdiomLoops == 0 {
+				t.Errorf("JIT.Run() stats = %+v, want loops to be run as idioms", jit.Stats)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package elf_code
+
+import (
+	"fmt"
+)
+
+// The default number of times a loop has to jump back to its start before it is compiled
+const DefaultHotLoopThreshold = 1000
+
+// Options for running a program with the JIT
+type JITOptions struct {
+	HotLoopThreshold int // The number of back jumps to a loop start before it is compiled (DefaultHotLoopThreshold if zero)
+}
+
+// Counters for what the JIT has done
+type JITStats struct {
+	CompiledLoops           int // The number of loops compiled
+	CompiledBlocks          int // The number of fused blocks compiled within those loops
+	CompiledInstructions    int // The number of instructions executed by compiled blocks
+	InterpretedInstructions int // The number of instructions executed by the interpreter
+	IdiomLoops              int // The number of times a loop was run in closed form as an idiom
+	IdiomInstructions       int // The number of instructions those loops would have run
+}
+
+// A fused run of instructions, which ends at the first instruction that writes to the
+// instruction pointer (or the end of the loop). Running it returns the next instruction pointer
+type fusedBlock struct {
+	run    func(r Registers) int
+	length int // The number of instructions in the block
+}
+
+// A hot loop which has been compiled
+type compiledLoop struct {
+	start, end int          // The instruction pointers the loop covers, inclusive
+	blocks     []fusedBlock // Blocks by the instruction pointer they start at (relative to `start`), built on first entry
+}
+
+// Runs a decoded program, counting how often each instruction pointer is jumped back to. Once a loop
+// gets hot, the instructions it covers are compiled into chains of Go closures which run without
+// going back through the interpreter until execution leaves the loop.
+//
+// This speeds up every iteration, but doesn't change how many iterations there are. Loops which are
+// algorithmically slow (such as the factor summing loop in day 19 part 2) are only fast when they match
+// an idiom, which is run in closed form instead
+type JIT struct {
+	Stats JITStats // What the JIT has done so far
+
+	program   *DecodedProgram
+	threshold int
+	backJumps []int           // Instruction pointer => number of times it's been jumped back to
+	loops     []*compiledLoop // Instruction pointer => compiled loop starting there
+}
+
+// Creates a JIT for the decoded program, which can be run many times keeping the compiled loops
+func (d *DecodedProgram) NewJIT(options JITOptions) *JIT {
+	threshold := options.HotLoopThreshold
+	if threshold <= 0 {
+		threshold = DefaultHotLoopThreshold
+	}
+
+	return &JIT{
+		program:   d,
+		threshold: threshold,
+		backJumps: make([]int, len(d.instructions)),
+		loops:     make([]*compiledLoop, len(d.instructions)),
+	}
+}
+
+// Executes the program loaded into the CPU using the JIT. The result is the same as `CPU.Execute`,
+// however the tracer isn't called and invalid programs are rejected before any instruction is run
+func (cpu *CPU) ExecuteJIT(options JITOptions) (stats JITStats, err error) {
+	decoded, err := cpu.Decode()
+	if err != nil {
+		return
+	}
+
+	jit := decoded.NewJIT(options)
+	_, err = jit.Run(cpu.Registers, 0)
+	return jit.Stats, err
+}
+
+// Runs the program with the same behaviour as `DecodedProgram.Run`
+func (j *JIT) Run(registers Registers, maxInstructions int) (executed int, err error) {
+	d := j.program
+	if len(registers) != d.numRegisters {
+		return 0, fmt.Errorf("expected %d registers, got %d", d.numRegisters, len(registers))
+	}
+
+	r := registers
+	program := d.instructions
+	ipRegister := d.ipRegister
+	ip := r[ipRegister]
+
+	for ip >= 0 && ip < len(program) {
+		if maxInstructions > 0 && executed >= maxInstructions {
+			r[ipRegister] = ip
+			return executed, &ExecutionStoppedError{ErrInstructionLimit, ip, registers.Copy(), executed}
+		}
+
+		budget := -1
+		if maxInstructions > 0 {
+			budget = maxInstructions - executed
+		}
+
+		// Idioms are tried first, as they skip the whole loop rather than speeding up each iteration
+		if next, ran := d.runIdiom(ip, r, budget); ran > 0 {
+			ip = next
+			executed += ran
+			j.Stats.IdiomLoops++
+			j.Stats.IdiomInstructions += ran
+			continue
+		}
+
+		if loop := j.loops[ip]; loop != nil {
+			next, ran := j.runLoop(loop, ip, r, budget)
+			if ran > 0 {
+				ip = next
+				executed += ran
+				continue
+			}
+		}
+
+		instruction := &program[ip]
+		r[ipRegister] = ip
+		if err = d.execute(instruction, r); err != nil {
+			return
+		}
+
+		next := r[ipRegister] + 1
+		if next <= ip && next >= 0 && j.loops[next] == nil {
+			j.backJumps[next]++
+			if j.backJumps[next] >= j.threshold {
+				if j.hasRegisteredOpCodes(next, ip) {
+					// Check again later, rather than on every jump back
+					j.backJumps[next] = 0
+				} else {
+					j.loops[next] = &compiledLoop{next, ip, make([]fusedBlock, ip-next+1)}
+					j.Stats.CompiledLoops++
+				}
+			}
+		}
+
+		ip = next
+		executed++
+		j.Stats.InterpretedInstructions++
+	}
+
+	// Leave the instruction pointer on the last instruction, as CPU.Execute does
+	r[ipRegister] = ip - 1
+
+	return executed, nil
+}
+
+// Runs compiled blocks while execution stays within the loop and the budget (if not negative) allows.
+// Inner loops matching an idiom are run as the idiom
+func (j *JIT) runLoop(loop *compiledLoop, ip int, r Registers, budget int) (next int, executed int) {
+	for ip >= loop.start && ip <= loop.end {
+		remaining := -1
+		if budget >= 0 {
+			remaining = budget - executed
+		}
+
+		if next, ran := j.program.runIdiom(ip, r, remaining); ran > 0 {
+			ip = next
+			executed += ran
+			j.Stats.IdiomLoops++
+			j.Stats.IdiomInstructions += ran
+			continue
+		}
+
+		block := &loop.blocks[ip-loop.start]
+		if block.run == nil {
+			*block = j.compileBlock(ip, loop.end)
+			j.Stats.CompiledBlocks++
+		}
+
+		if budget >= 0 && executed+block.length > budget {
+			break
+		}
+
+		ip = block.run(r)
+		executed += block.length
+		j.Stats.CompiledInstructions += block.length
+	}
+
+	return ip, executed
+}
+
+// Loops with registered op codes are left to the interpreter, as they could fail part way through a compiled block
+func (j *JIT) hasRegisteredOpCodes(start int, end int) bool {
+	for _, instruction := range j.program.instructions[start : end+1] {
+		if _, found := registeredOpCodes[instruction.OpCode]; found {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fuses the instructions from `start` up to the first jump (or `end`) into a chain of closures
+func (j *JIT) compileBlock(start int, end int) fusedBlock {
+	program := j.program.instructions
+	ipRegister := j.program.ipRegister
+
+	last := start
+	for last < end && program[last].C != ipRegister {
+		last++
+	}
+
+	// The last instruction works out where to go next
+	next := fuseJump(program[last], last, ipRegister, j.program.word)
+
+	// Then chain the rest of the block in front of it
+	for ip := last - 1; ip >= start; ip-- {
+		next = fuseInstruction(program[ip], ip, ipRegister, j.program.word, next)
+	}
+
+	return fusedBlock{next, last - start + 1}
+}
+
+// Builds a closure for the instruction at the end of a block, returning the next instruction pointer. The
+// common jumps are worked out directly, as the instruction pointer register is set before it is next read
+// (unless the instruction pointer has to be wrapped to the word size)
+func fuseJump(instruction Instruction, ip int, ipRegister int, word WordSize) func(r Registers) int {
+	a, b, c := instruction.A, instruction.B, instruction.C
+
+	if c == ipRegister && word.Bits == 0 {
+		switch {
+		case instruction.OpCode == SetI:
+			return func(r Registers) int { return a + 1 }
+		case instruction.OpCode == AddI && a == ipRegister:
+			return func(r Registers) int { return ip + b + 1 }
+		case instruction.OpCode == AddR && a == ipRegister && b != ipRegister:
+			return func(r Registers) int { return ip + r[b] + 1 }
+		case instruction.OpCode == AddR && b == ipRegister && a != ipRegister:
+			return func(r Registers) int { return ip + r[a] + 1 }
+		}
+	}
+
+	return func(r Registers) int {
+		r[ipRegister] = ip
+		_ = executeDecoded(&instruction, r, nil) // Only built in op codes are compiled, which can't fail
+		r[c] = word.Wrap(r[c])
+		return r[ipRegister] + 1
+	}
+}
+
+// Builds a closure running the instruction and then `next`. As the instruction doesn't write
+// to the instruction pointer, any read of it can be replaced by the constant `ip`
+func fuseInstruction(instruction Instruction, ip int, ipRegister int, word WordSize, next func(r Registers) int) func(r Registers) int {
+	isImmediate := OpCodeInputType[instruction.OpCode]
+	a, b, c := instruction.A, instruction.B, instruction.C
+
+	if (!isImmediate.A && a == ipRegister) || (!isImmediate.B && b == ipRegister) || word.Bits != 0 {
+		return func(r Registers) int {
+			r[ipRegister] = ip
+			_ = executeDecoded(&instruction, r, nil)
+			r[c] = word.Wrap(r[c])
+			return next(r)
+		}
+	}
+
+	switch instruction.OpCode {
+	case AddR:
+		return func(r Registers) int { r[c] = r[a] + r[b]; return next(r) }
+	case AddI:
+		return func(r Registers) int { r[c] = r[a] + b; return next(r) }
+	case MulR:
+		return func(r Registers) int { r[c] = r[a] * r[b]; return next(r) }
+	case MulI:
+		return func(r Registers) int { r[c] = r[a] * b; return next(r) }
+	case BanR:
+		return func(r Registers) int { r[c] = r[a] & r[b]; return next(r) }
+	case BanI:
+		return func(r Registers) int { r[c] = r[a] & b; return next(r) }
+	case BorR:
+		return func(r Registers) int { r[c] = r[a] | r[b]; return next(r) }
+	case BorI:
+		return func(r Registers) int { r[c] = r[a] | b; return next(r) }
+	case SetR:
+		return func(r Registers) int { r[c] = r[a]; return next(r) }
+	case SetI:
+		return func(r Registers) int { r[c] = a; return next(r) }
+	case GtIR:
+		return func(r Registers) int { r[c] = boolToRegister(a > r[b]); return next(r) }
+	case GtRI:
+		return func(r Registers) int { r[c] = boolToRegister(r[a] > b); return next(r) }
+	case GtRR:
+		return func(r Registers) int { r[c] = boolToRegister(r[a] > r[b]); return next(r) }
+	case EqIR:
+		return func(r Registers) int { r[c] = boolToRegister(a == r[b]); return next(r) }
+	case EqRI:
+		return func(r Registers) int { r[c] = boolToRegister(r[a] == b); return next(r) }
+	default: // EqRR, as loops with registered op codes aren't compiled
+		return func(r Registers) int { r[c] = boolToRegister(r[a] == r[b]); return next(r) }
+	}
+}
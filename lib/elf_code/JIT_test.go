@@ -0,0 +1,104 @@
+package elf_code
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCPU_ExecuteJIT(t *testing.T) {
+	tests := []struct {
+		name      string
+		program   string
+		r0        int
+		threshold int
+	}{
+		{"Debugger program", debuggerTestProgram, 0, 1},
+		{"Loop", loopTestProgram, 0, 1},
+		{"Day 19 part 1", smallDay19Program, 0, 0},
+		{"Day 19 part 2", smallDay19Program, 1, 0},
+		{"Day 19 hot immediately", smallDay19Program, 0, 1},
+		{"Reads the IP register", "#ip 2\nseti 10 0 1\naddr 2 0 0\naddi 1 -1 1\ngtri 1 0 3\nmulr 3 3 3\nmuli 3 -6 4\naddr 2 4 2", 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+			got, _ := NewCPUFromProgramFile(tt.program)
+
+			want.Registers[0] = tt.r0
+			got.Registers[0] = tt.r0
+
+			if err := want.Execute(); err != nil {
+				t.Fatalf("CPU.Execute() error = %v", err)
+			}
+
+			stats, err := got.ExecuteJIT(JITOptions{HotLoopThreshold: tt.threshold})
+			if err != nil {
+				t.Fatalf("CPU.ExecuteJIT() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got.Registers, want.Registers) {
+				t.Errorf("CPU.ExecuteJIT() = %v, want %v", got.Registers, want.Registers)
+			}
+
+			if tt.name != "Debugger program" && stats.CompiledLoops == 0 && stats.IdiomLoops == 0 {
+				t.Errorf("CPU.ExecuteJIT() stats = %+v, want loops to be compiled or run as idioms", stats)
+			}
+		})
+	}
+}
+
+func TestJIT_Run(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(smallDay19Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	decoded, err := cpu.Decode()
+	if err != nil {
+		t.Fatalf("CPU.Decode() error = %v", err)
+	}
+	jit := decoded.NewJIT(JITOptions{HotLoopThreshold: 10})
+
+	// Stopping part way through a compiled loop should stop on the same instruction as the interpreter
+	for _, limit := range []int{1, 100, 5001, 12345} {
+		registers := NewRegisters(6)
+		executed, err := jit.Run(registers, limit)
+		got, ok := err.(*ExecutionStoppedError)
+		if !ok || executed != limit {
+			t.Fatalf("JIT.Run(%d) = %v, %v, want the instruction limit to be reached", limit, executed, err)
+		}
+
+		want := NewCPU(cpu.Program, cpu.InstructionPointerRegister, 6)
+		if err := want.ExecuteWithLimits(context.Background(), limit); !reflect.DeepEqual(got, err) {
+			t.Errorf("JIT.Run(%d) error = %v, want %v", limit, got, err)
+		}
+	}
+
+	// The compiled loops are kept between runs
+	registers := NewRegisters(6)
+	if _, err := jit.Run(registers, 0); err != nil {
+		t.Fatalf("JIT.Run() error = %v", err)
+	}
+
+	if jit.Stats.CompiledInstructions <= jit.Stats.InterpretedInstructions {
+		t.Errorf("JIT.Run() stats = %+v, want most instructions to run compiled", jit.Stats)
+	}
+}
+
+func BenchmarkCPU_ExecuteJIT(b *testing.B) {
+	cpu, err := NewCPUFromProgramFile(smallDay19Program)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < b.N; i++ {
+		cpu.Registers = NewRegisters(len(cpu.Registers))
+		if _, err := cpu.ExecuteJIT(JITOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
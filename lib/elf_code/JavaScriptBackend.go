@@ -0,0 +1,172 @@
+package elf_code
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Writes the program out as JavaScript
+type JavaScriptBackend struct{}
+
+func (JavaScriptBackend) Indent() string {
+	return "    "
+}
+
+func (JavaScriptBackend) WriteProgramStart(t *TranspileState, functions []*ProgramBlock, str *strings.Builder) {
+	writeJavaScriptRegisters(len(t.Registers), str)
+}
+
+func writeJavaScriptRegisters(numRegisters int, str *strings.Builder) {
+	str.WriteString("// Registers\nvar R = [")
+	for i := 0; i < numRegisters; i++ {
+		if i > 0 {
+			str.WriteString(", ")
+		}
+
+		str.WriteRune('0')
+	}
+	str.WriteString("]\n\n")
+}
+
+func (JavaScriptBackend) WriteProgramEnd(t *TranspileState, functions []*ProgramBlock, str *strings.Builder) {
+	if t.idioms > 0 {
+		str.WriteString("// Idiom helpers\n")
+		str.WriteString("function maxInt(a, b) { return a > b ? a : b; }\n")
+		str.WriteString("function floorDiv(a, b) { return Math.floor(a / b); }\n")
+		str.WriteString("function shiftLeft(value, places) { return value * Math.pow(2, places); }\n")
+		str.WriteString("function divisorHit(factor, from, target) {\n")
+		str.WriteString("    if (factor == 0 || target % factor != 0) { return 0; }\n")
+		str.WriteString("    var j = target / factor;\n")
+		str.WriteString("    return j >= from && j <= maxInt(from, target) ? factor : 0;\n")
+		str.WriteString("}\n")
+	}
+}
+
+func (JavaScriptBackend) WriteFunctionStart(t *TranspileState, b *ProgramBlock, isMain bool, str *strings.Builder) {
+	// Jumps back to the start of the program call the main block, so it needs a name of its own
+	if isMain && len(b.calledBy) > 0 {
+		str.WriteString(fmt.Sprintf("function main() {\n    block%d()\n}\n\nfunction block%d() {\n", b.blockNum, b.blockNum))
+	} else if isMain {
+		str.WriteString("function main() {\n")
+	} else {
+		str.WriteString(fmt.Sprintf("function block%d() {\n", b.blockNum))
+	}
+}
+
+func (JavaScriptBackend) WriteFunctionEnd(t *TranspileState, b *ProgramBlock, isMain bool, str *strings.Builder) {
+	str.WriteString("}\n\n")
+}
+
+func (JavaScriptBackend) WriteStatement(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent)
+	line.WriteInstruction(str)
+	str.WriteString(fmt.Sprintf(" // %s\n", t.ipComment(line.ip)))
+}
+
+func (JavaScriptBackend) WriteCall(t *TranspileState, line *ProgramLine, target *ProgramBlock, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%sblock%d()", indent, target.blockNum))
+	if line != nil {
+		str.WriteString(fmt.Sprintf(" // %s", t.ipComment(line.ip)))
+	}
+	str.WriteRune('\n')
+}
+
+func (JavaScriptBackend) WriteIfStart(t *TranspileState, line *ProgramLine, register int, value int, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%sif (R[%d] == %d) { // %s\n", indent, register, value, t.ipComment(line.ip)))
+}
+
+func (JavaScriptBackend) WriteElse(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent + "} else {\n")
+}
+
+func (JavaScriptBackend) WriteIfEnd(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent + "}\n")
+}
+
+func (JavaScriptBackend) WriteDoWhileStart(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent + "do {\n")
+}
+
+func (JavaScriptBackend) WriteDoWhileEnd(t *TranspileState, line *ProgramLine, register int, value int, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%s} while (R[%d] == %d); // %s\n", indent, register, value, t.ipComment(line.ip)))
+}
+
+func (JavaScriptBackend) WriteIdiom(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	writeIdiomAssignments(t, line, indent, "", str)
+}
+
+func (JavaScriptBackend) WriteComment(t *TranspileState, comment string, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%s// %s\n", indent, comment))
+}
+
+func (JavaScriptBackend) Finish(source string) (string, error) {
+	return source, nil
+}
+
+func (JavaScriptBackend) WriteASTStart(d *DecompiledProgram, str *strings.Builder) {
+	writeJavaScriptRegisters(d.NumRegisters, str)
+	str.WriteString("function main() {\n")
+}
+
+// JavaScript has no goto, so control flow which can't be structured is written as a loop switching on the block to run
+func (JavaScriptBackend) WritesGotos() bool {
+	return false
+}
+
+func (JavaScriptBackend) WriteASTEnd(d *DecompiledProgram, str *strings.Builder) {
+	str.WriteString("}\n")
+}
+
+func (JavaScriptBackend) WriteASTNodeStart(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder) {
+	if node.Kind == ASTLabel {
+		// Labels are the cases of the switch in `ASTDispatch`, so line up with it
+		indent = strings.TrimSuffix(indent, "    ")
+	}
+	str.WriteString(indent)
+	if node.Labelled && node.Kind != ASTBreak && node.Kind != ASTContinue {
+		str.WriteString(node.loopName() + ": ")
+	}
+
+	switch node.Kind {
+	case ASTStatement:
+		node.programLine().WriteInstruction(str)
+		str.WriteString(d.nodeComment(node))
+	case ASTIf:
+		str.WriteString(fmt.Sprintf("if (%s) {%s", node.Condition, d.nodeComment(node)))
+	case ASTWhile:
+		str.WriteString(fmt.Sprintf("while (%s) {%s", node.Condition, d.nodeComment(node)))
+	case ASTDoWhile:
+		str.WriteString("do {")
+	case ASTLoop:
+		str.WriteString("while (true) {")
+	case ASTBreak, ASTContinue:
+		str.WriteString(node.Kind.String())
+		if node.Labelled {
+			str.WriteString(" " + node.loopName())
+		}
+	case ASTHalt:
+		str.WriteString("return")
+	case ASTGoto:
+		str.WriteString(fmt.Sprintf("pc = %d;\n%scontinue;", node.Label, indent))
+	case ASTLabel:
+		str.WriteString(fmt.Sprintf("case %d:", node.Label))
+	case ASTDispatch:
+		str.WriteString(fmt.Sprintf("var pc = %d; // irreducible control flow\n%sfor (;;) {\n%s    switch (pc) {", node.Label, indent, indent))
+	}
+
+	str.WriteRune('\n')
+}
+
+func (JavaScriptBackend) WriteASTElse(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder) {
+	str.WriteString(indent + "} else {\n")
+}
+
+func (JavaScriptBackend) WriteASTNodeEnd(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder) {
+	if node.Kind == ASTDoWhile {
+		str.WriteString(fmt.Sprintf("%s} while (%s);%s\n", indent, node.Condition, d.nodeComment(node)))
+	} else if node.Kind == ASTDispatch {
+		str.WriteString(indent + "    }\n" + indent + "}\n")
+	} else {
+		str.WriteString(indent + "}\n")
+	}
+}
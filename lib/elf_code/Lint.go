@@ -0,0 +1,196 @@
+package elf_code
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// How serious a problem found by `Program.Lint` is
+type Severity int
+
+const (
+	SeverityInfo    Severity = iota // Harmless, but probably not what was meant
+	SeverityWarning                 // Something which makes the program harder to analyse or likely a mistake
+	SeverityError                   // Something which will stop the program from running
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// The checks `Program.Lint` runs
+const (
+	CheckUnknownOpCode  = "unknown-op-code" // The op code isn't built in or registered
+	CheckRegisterRange  = "register-range"  // A register operand is outside of the registers
+	CheckUnreachable    = "unreachable"     // The instructions can't be reached from the start of the program
+	CheckDeadWrite      = "dead-write"      // The value written is always overwritten before it is read
+	CheckComputedJump   = "computed-jump"   // The jump target depends on a register which isn't known before running
+	CheckIgnoredOperand = "ignored-operand" // An operand the op code ignores isn't zero
+)
+
+// A problem found by `Program.Lint`
+type Diagnostic struct {
+	IP       int      // The instruction the problem is with
+	Severity Severity // How serious the problem is
+	Check    string   // The check which found the problem, such as `CheckUnreachable`
+	Message  string   // What the problem is
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d: %s: %s (%s)", d.IP, d.Severity, d.Message, d.Check)
+}
+
+// Checks the program loaded into the CPU for problems without running it, see `Program.Lint`
+func (cpu *CPU) Lint(booleanInputs ...int) []Diagnostic {
+	return cpu.Program.Lint(cpu.InstructionPointerRegister, len(cpu.Registers), booleanInputs...)
+}
+
+// Checks the program for problems without running it, returning them in instruction pointer order.
+// `booleanInputs` are registers which can be assumed to hold 0 or 1, see `Program.JumpAt`
+func (p Program) Lint(ipRegister int, numRegisters int, booleanInputs ...int) (diagnostics []Diagnostic) {
+	l := &linter{p, ipRegister, numRegisters, make([]Diagnostic, 0)}
+	if len(p) == 0 {
+		return l.diagnostics
+	}
+
+	// The other checks need to know what every instruction does
+	if !l.checkOperands() {
+		return l.diagnostics
+	}
+
+	flow, err := p.DataFlow(ipRegister, numRegisters, booleanInputs...)
+	if err != nil {
+		return l.diagnostics
+	}
+
+	graph := NewControlFlowGraph(p, ipRegister, numRegisters, booleanInputs...)
+	l.checkUnreachable(graph)
+	l.checkComputedJumps(graph, flow)
+	l.checkDeadWrites(flow)
+
+	sort.SliceStable(l.diagnostics, func(i, j int) bool { return l.diagnostics[i].IP < l.diagnostics[j].IP })
+	return l.diagnostics
+}
+
+type linter struct {
+	program      Program
+	ipRegister   int
+	numRegisters int
+	diagnostics  []Diagnostic
+}
+
+func (l *linter) report(ip int, severity Severity, check string, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{ip, severity, check, fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) isRegister(register int) bool {
+	return register >= 0 && register < l.numRegisters
+}
+
+// Checks the op codes are known, the registers exist and the ignored operands are zero, returning false on errors
+func (l *linter) checkOperands() (valid bool) {
+	valid = true
+
+	for ip, instruction := range l.program {
+		isImmediate, found := OpCodeInputType[instruction.OpCode]
+		if !found {
+			l.report(ip, SeverityError, CheckUnknownOpCode, "unknown op code %d", int(instruction.OpCode))
+			valid = false
+			continue
+		}
+
+		if !isImmediate.A && !l.isRegister(instruction.A) {
+			l.report(ip, SeverityError, CheckRegisterRange, "input A register %d out of range of %d registers", instruction.A, l.numRegisters)
+			valid = false
+		}
+		if !isImmediate.B && !l.isRegister(instruction.B) {
+			l.report(ip, SeverityError, CheckRegisterRange, "input B register %d out of range of %d registers", instruction.B, l.numRegisters)
+			valid = false
+		}
+		if !l.isRegister(instruction.C) {
+			l.report(ip, SeverityError, CheckRegisterRange, "output C register %d out of range of %d registers", instruction.C, l.numRegisters)
+			valid = false
+		}
+
+		if (instruction.OpCode == SetR || instruction.OpCode == SetI) && instruction.B != 0 {
+			l.report(ip, SeverityInfo, CheckIgnoredOperand, "input B of %s is ignored, but is %d", instruction.OpCode, instruction.B)
+		}
+	}
+
+	return
+}
+
+// Reports each run of unreachable instructions once. Computed jumps could go anywhere,
+// so nothing is reported if one can be reached
+func (l *linter) checkUnreachable(graph *ControlFlowGraph) {
+	for _, block := range graph.Blocks {
+		if block.Reachable && block.ComputedJump {
+			return
+		}
+	}
+
+	for i := 0; i < len(graph.Blocks); i++ {
+		if graph.Blocks[i].Reachable {
+			continue
+		}
+
+		start := graph.Blocks[i].Start
+		for i+1 < len(graph.Blocks) && !graph.Blocks[i+1].Reachable {
+			i++
+		}
+
+		if end := graph.Blocks[i].End; end == start {
+			l.report(start, SeverityWarning, CheckUnreachable, "unreachable instruction")
+		} else {
+			l.report(start, SeverityWarning, CheckUnreachable, "unreachable instructions %d to %d", start, end)
+		}
+	}
+}
+
+// Reports jumps which depend on registers that don't always hold the same value
+func (l *linter) checkComputedJumps(graph *ControlFlowGraph, flow *DataFlow) {
+	for _, block := range graph.Blocks {
+		if !block.ComputedJump || !block.Reachable {
+			continue
+		}
+
+		instruction := l.program[block.End]
+		isImmediate := OpCodeInputType[instruction.OpCode]
+
+		unknown := make([]string, 0, 2)
+		for _, operand := range []struct {
+			register    int
+			isImmediate bool
+		}{{instruction.A, isImmediate.A}, {instruction.B, isImmediate.B}} {
+			if operand.isImmediate {
+				continue
+			}
+			if _, isConstant := flow.ConstantBefore(block.End, operand.register); !isConstant {
+				unknown = append(unknown, fmt.Sprintf("r%d", operand.register))
+			}
+		}
+
+		if len(unknown) > 0 {
+			l.report(block.End, SeverityWarning, CheckComputedJump, "jump target computed from %s", strings.Join(unknown, " and "))
+		}
+	}
+}
+
+// Reports writes which are overwritten before they are read on every path
+func (l *linter) checkDeadWrites(flow *DataFlow) {
+	for ip, instruction := range l.program {
+		if flow.IsDeadWrite(ip) {
+			l.report(ip, SeverityWarning, CheckDeadWrite, "value written to r%d is never read", instruction.C)
+		}
+	}
+}
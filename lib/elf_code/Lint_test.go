@@ -0,0 +1,67 @@
+package elf_code
+
+import (
+	"reflect"
+	"testing"
+)
+
+const lintTestProgram = `#ip 5
+seti 1 0 1
+seti 2 3 1
+addi 1 1 0
+seti 9 0 5
+addi 0 1 0
+mulr 0 0 0`
+
+func TestCPU_Lint(t *testing.T) {
+	tests := []struct {
+		name          string
+		program       string
+		booleanInputs []int
+		want          []Diagnostic
+	}{
+		{"Clean", "#ip 5\nseti 1 0 0\naddi 0 2 0", nil, []Diagnostic{}},
+		{"Problems", lintTestProgram, nil, []Diagnostic{
+			{0, SeverityWarning, CheckDeadWrite, "value written to r1 is never read"},
+			{1, SeverityInfo, CheckIgnoredOperand, "input B of seti is ignored, but is 3"},
+			{4, SeverityWarning, CheckUnreachable, "unreachable instructions 4 to 5"},
+		}},
+		{"Computed jump", "#ip 5\naddr 0 5 5\nseti 0 0 0", nil, []Diagnostic{
+			{0, SeverityWarning, CheckComputedJump, "jump target computed from r0"},
+		}},
+		{"Boolean input", "#ip 5\naddr 0 5 5\nseti 0 0 0", []int{0}, []Diagnostic{}},
+		{"Constant jump", "#ip 5\nseti 1 0 1\naddr 1 5 5\nseti 0 0 0\nseti 1 0 0", nil, []Diagnostic{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			if got := cpu.Lint(tt.booleanInputs...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CPU.Lint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgram_Lint_Errors(t *testing.T) {
+	program := Program{{AddR, 7, 0, 0}, {OpCode(-1), 0, 0, 0}, {SetI, 1, 0, 6}}
+
+	want := []Diagnostic{
+		{0, SeverityError, CheckRegisterRange, "input A register 7 out of range of 6 registers"},
+		{1, SeverityError, CheckUnknownOpCode, "unknown op code -1"},
+		{2, SeverityError, CheckRegisterRange, "output C register 6 out of range of 6 registers"},
+	}
+	if got := program.Lint(5, 6); !reflect.DeepEqual(got, want) {
+		t.Errorf("Program.Lint() = %v, want %v", got, want)
+	}
+}
+
+func TestDiagnostic_String(t *testing.T) {
+	d := Diagnostic{4, SeverityWarning, CheckUnreachable, "unreachable instruction"}
+	if got, want := d.String(), "4: warning: unreachable instruction (unreachable)"; got != want {
+		t.Errorf("Diagnostic.String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,187 @@
+package elf_code
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// An op code added on top of the built in op codes by `RegisterOpCode`, such as `divi` or `modr`
+type OpCodeDefinition struct {
+	Name       string           // The name used in programs, which can't already be taken by another op code
+	Inputs     InputIsImmediate // If inputs A and B are values rather than registers
+	Func       OpFunc           // Executes the op code, returning the value to put in output C
+	HostFunc   HostOpFunc       // Used instead of `Func` for op codes which talk to the CPU's host, such as for I/O
+	Comparator bool             // If the op code compares its inputs, so always gives 0 or 1
+	Operator   string           // The operator transpiled and disassembled code puts between the inputs, such as `/`. If empty the op code is written as a call `name(A, B)`, which the code around it has to provide
+
+	// The name of an already registered op code which does the same with an immediate input B, such as `modi` for
+	// `modr`. The transpiler and decompiler swap to it when input B is known to be constant
+	ImmediateVersion string
+}
+
+// Registered op code => its definition
+var registeredOpCodes = make(map[OpCode]*OpCodeDefinition)
+
+// Registered op code name => op code
+var registeredOpCodeNames = make(map[string]OpCode)
+
+// Adds an op code which can then be used by the interpreter, assembler, transpiler and other tools in this package
+// like any of the built in op codes. Registered op codes are given the lowest free number after the built in ones,
+// so their numbers depend on what else has been registered. `Program.MarshalBinary` stores them by name, so programs
+// can be read back as long as the same op codes are registered, in any order.
+//
+// The registry isn't safe to change while programs are being loaded or run, so op codes should be registered up front,
+// such as from an `init` function
+func RegisterOpCode(definition OpCodeDefinition) (opCode OpCode, err error) {
+	definition.Name = strings.ToLower(definition.Name)
+
+	if !validOpCodeName(definition.Name) {
+		return 0, fmt.Errorf("invalid op code name %q", definition.Name)
+	}
+
+	if _, err := ParseOpCode(definition.Name); err == nil {
+		return 0, fmt.Errorf("op code %q is already defined", definition.Name)
+	}
+
+	if (definition.Func == nil) == (definition.HostFunc == nil) {
+		return 0, fmt.Errorf("op code %q needs either a function or a host function", definition.Name)
+	}
+
+	immediate, err := definition.immediateVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	if definition.HostFunc != nil {
+		// Without a CPU to give them a host, op codes which talk to the host can't be run
+		definition.Func = func(a int, b int, registers Registers) (value int, err error) {
+			return 0, ErrNoHost
+		}
+	}
+
+	opCode = NumOpCodes
+	for _, taken := registeredOpCodes[opCode]; taken; _, taken = registeredOpCodes[opCode] {
+		opCode++
+	}
+
+	registeredOpCodes[opCode] = &definition
+	registeredOpCodeNames[definition.Name] = opCode
+	OpCodeInputType[opCode] = definition.Inputs
+	OpCodeFunc[opCode] = definition.Func
+	if definition.ImmediateVersion != "" {
+		OpCodeImmedateVersion[opCode] = immediate
+	}
+
+	return opCode, nil
+}
+
+// Removes a registered op code, freeing its name and number. Any op code using it as their immediate version stops
+// being swapped to it. Like `RegisterOpCode` this isn't safe while programs are being loaded or run
+func UnregisterOpCode(opCode OpCode) error {
+	definition, found := registeredOpCodes[opCode]
+	if !found {
+		return fmt.Errorf("op code %v is not registered", opCode)
+	}
+
+	delete(registeredOpCodes, opCode)
+	delete(registeredOpCodeNames, definition.Name)
+	delete(OpCodeInputType, opCode)
+	delete(OpCodeFunc, opCode)
+	delete(OpCodeImmedateVersion, opCode)
+
+	for from, to := range OpCodeImmedateVersion {
+		if to == opCode {
+			delete(OpCodeImmedateVersion, from)
+		}
+	}
+
+	return nil
+}
+
+// Registers the op code, panicking if it can't be. Useful for defining op codes as package variables
+func MustRegisterOpCode(definition OpCodeDefinition) OpCode {
+	opCode, err := RegisterOpCode(definition)
+	if err != nil {
+		panic(err)
+	}
+
+	return opCode
+}
+
+// The definition of a registered op code, or false for built in (and unknown) op codes
+func (o OpCode) Definition() (definition OpCodeDefinition, found bool) {
+	if registered, found := registeredOpCodes[o]; found {
+		return *registered, true
+	}
+
+	return OpCodeDefinition{}, false
+}
+
+// The registered op codes, in order of their numbers
+func RegisteredOpCodes() []OpCode {
+	res := make([]OpCode, 0, len(registeredOpCodes))
+	for opCode := range registeredOpCodes {
+		res = append(res, opCode)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// Op code names are letters, digits and underscores, not starting with a digit, so they can't be confused with the
+// operands, labels or directives of a program
+func validOpCodeName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		if r != '_' && !(r >= 'a' && r <= 'z') && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Looks up the op code named by `ImmediateVersion`, checking it takes the same inputs other than an immediate B
+func (d *OpCodeDefinition) immediateVersion() (OpCode, error) {
+	if d.ImmediateVersion == "" {
+		return 0, nil
+	}
+
+	immediate, found := registeredOpCodeNames[strings.ToLower(d.ImmediateVersion)]
+	if !found {
+		return 0, fmt.Errorf("immediate version %q of op code %q is not registered", d.ImmediateVersion, d.Name)
+	}
+
+	if d.Inputs.B || registeredOpCodes[immediate].Inputs != (InputIsImmediate{d.Inputs.A, true}) {
+		return 0, fmt.Errorf("op code %q can't have %q as its immediate version, only input B can differ", d.Name, d.ImmediateVersion)
+	}
+
+	return immediate, nil
+}
+
+// Writes the op code applied to the already written inputs, such as `R[1] / 5` or `divi(R[1], 5)`
+func (d *OpCodeDefinition) expression(a string, b string) string {
+	if d.Operator == "" {
+		return d.Name + "(" + a + ", " + b + ")"
+	}
+
+	return a + " " + d.Operator + " " + b
+}
+
+// Runs the op code on the values of its inputs, rather than the register numbers an instruction would give it
+func (d *OpCodeDefinition) apply(a int, b int) (int, error) {
+	registers := Registers{a, b}
+	inputA, inputB := 0, 1
+	if d.Inputs.A {
+		inputA = a
+	}
+	if d.Inputs.B {
+		inputB = b
+	}
+
+	return d.Func(inputA, inputB, registers)
+}
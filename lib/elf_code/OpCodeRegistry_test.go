@@ -0,0 +1,372 @@
+package elf_code
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var errTestDivideByZero = errors.New("divide by zero")
+
+// Op codes registered by `registerTestOpCodes`, one for each way they can be written out
+var testDivI, testModI, testModR, testMaxR OpCode
+
+// Registers the test op codes until the test finishes, so they can't leak into other tests
+func registerTestOpCodes(t *testing.T) {
+	definitions := []struct {
+		opCode     *OpCode
+		definition OpCodeDefinition
+	}{
+		{&testDivI, OpCodeDefinition{
+			Name:   "divi",
+			Inputs: InputIsImmediate{false, true},
+			Func: func(a int, b int, registers Registers) (value int, err error) {
+				aV, err := registers.Get(a)
+				if err != nil {
+					return 0, err
+				}
+				if b == 0 {
+					return 0, errTestDivideByZero
+				}
+
+				return aV / b, nil
+			},
+			Operator: "/",
+		}},
+		{&testModI, OpCodeDefinition{
+			Name:   "modi",
+			Inputs: InputIsImmediate{false, true},
+			Func: func(a int, b int, registers Registers) (value int, err error) {
+				aV, err := registers.Get(a)
+				if err != nil {
+					return 0, err
+				}
+				if b == 0 {
+					return 0, errTestDivideByZero
+				}
+
+				return aV % b, nil
+			},
+			Operator: "%",
+		}},
+		{&testModR, OpCodeDefinition{
+			Name:   "modr",
+			Inputs: InputIsImmediate{false, false},
+			Func: func(a int, b int, registers Registers) (value int, err error) {
+				aV, bV, err := registers.GetTwo(a, b)
+				if err != nil {
+					return 0, err
+				}
+				if bV == 0 {
+					return 0, errTestDivideByZero
+				}
+
+				return aV % bV, nil
+			},
+			Operator:         "%",
+			ImmediateVersion: "modi",
+		}},
+		{&testMaxR, OpCodeDefinition{
+			Name:   "maxr",
+			Inputs: InputIsImmediate{false, false},
+			Func: func(a int, b int, registers Registers) (value int, err error) {
+				aV, bV, err := registers.GetTwo(a, b)
+				if err != nil {
+					return 0, err
+				}
+				if aV > bV {
+					return aV, nil
+				}
+
+				return bV, nil
+			},
+		}},
+	}
+
+	for _, d := range definitions {
+		opCode, err := RegisterOpCode(d.definition)
+		if err != nil {
+			t.Fatalf("RegisterOpCode() error = %v", err)
+		}
+		*d.opCode = opCode
+
+		t.Cleanup(func() {
+			if err := UnregisterOpCode(opCode); err != nil {
+				t.Errorf("UnregisterOpCode() error = %v", err)
+			}
+		})
+	}
+}
+
+// Sums the digits of R[0] into R[1]
+const digitSumTestProgram = `#ip 5
+seti 10 0 2
+seti 0 0 1
+modr 0 2 3
+addr 1 3 1
+divi 0 10 0
+gtri 0 0 3
+addr 3 5 5
+seti 99 0 5
+seti 1 0 5`
+
+func TestRegisterOpCode(t *testing.T) {
+	registerTestOpCodes(t)
+
+	if got, err := ParseOpCode("DIVI"); err != nil || got != testDivI {
+		t.Errorf("ParseOpCode() = %v, %v, want %v", got, err, testDivI)
+	}
+
+	if got := testModR.String(); got != "modr" {
+		t.Errorf("OpCode.String() = %v, want modr", got)
+	}
+
+	want := []OpCode{testDivI, testModI, testModR, testMaxR}
+	if got := RegisteredOpCodes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RegisteredOpCodes() = %v, want %v", got, want)
+	}
+
+	if got := OpCodeImmedateVersion[testModR]; got != testModI {
+		t.Errorf("OpCodeImmedateVersion[modr] = %v, want modi", got)
+	}
+
+	if definition, found := testDivI.Definition(); !found || definition.Name != "divi" || definition.Operator != "/" {
+		t.Errorf("OpCode.Definition() = %+v, %v, want divi", definition, found)
+	}
+
+	if _, found := AddR.Definition(); found {
+		t.Errorf("OpCode.Definition() for a built in op code, want not found")
+	}
+
+	noop := func(a int, b int, registers Registers) (int, error) { return 0, nil }
+	errorTests := []struct {
+		name       string
+		definition OpCodeDefinition
+		wantErr    string
+	}{
+		{"Built in", OpCodeDefinition{Name: "addr", Func: noop}, `op code "addr" is already defined`},
+		{"Registered", OpCodeDefinition{Name: "Divi", Func: noop}, `op code "divi" is already defined`},
+		{"Invalid name", OpCodeDefinition{Name: "2nd", Func: noop}, `invalid op code name "2nd"`},
+		{"No name", OpCodeDefinition{Func: noop}, `invalid op code name ""`},
+		{"No function", OpCodeDefinition{Name: "nope"}, `op code "nope" needs either a function or a host function`},
+		{"Unknown immediate version", OpCodeDefinition{Name: "powr", Func: noop, ImmediateVersion: "powi"}, `immediate version "powi" of op code "powr" is not registered`},
+		{"Built in immediate version", OpCodeDefinition{Name: "powr", Func: noop, ImmediateVersion: "addi"}, `immediate version "addi" of op code "powr" is not registered`},
+		{"Mismatched immediate version", OpCodeDefinition{Name: "powr", Inputs: InputIsImmediate{true, false}, Func: noop, ImmediateVersion: "modi"}, `op code "powr" can't have "modi" as its immediate version, only input B can differ`},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := RegisterOpCode(tt.definition); err == nil || err.Error() != tt.wantErr {
+				t.Errorf("RegisterOpCode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnregisterOpCode(t *testing.T) {
+	registerTestOpCodes(t)
+
+	definition, _ := testModI.Definition()
+	if err := UnregisterOpCode(testModI); err != nil {
+		t.Fatalf("UnregisterOpCode() error = %v", err)
+	}
+
+	if _, err := ParseOpCode("modi"); err == nil {
+		t.Errorf("ParseOpCode() after unregistering, want error")
+	}
+
+	if _, found := OpCodeImmedateVersion[testModR]; found {
+		t.Errorf("OpCodeImmedateVersion[modr] after unregistering modi, want not found")
+	}
+
+	if err := UnregisterOpCode(testModI); err == nil {
+		t.Errorf("UnregisterOpCode() twice, want error")
+	}
+
+	if err := UnregisterOpCode(AddR); err == nil {
+		t.Errorf("UnregisterOpCode() for a built in op code, want error")
+	}
+
+	// The freed number is the next one given out, putting modi back for the cleanup
+	if got, err := RegisterOpCode(definition); err != nil || got != testModI {
+		t.Errorf("RegisterOpCode() after unregistering = %v, %v, want %v", got, err, testModI)
+	}
+}
+
+func TestRegisteredOpCodes_Execute(t *testing.T) {
+	registerTestOpCodes(t)
+
+	tests := []struct {
+		name    string
+		execute func(cpu *CPU) error
+	}{
+		{"Interpreter", func(cpu *CPU) error { return cpu.Execute() }},
+		{"Fast", func(cpu *CPU) error { return cpu.ExecuteFast() }},
+		{"JIT", func(cpu *CPU) error {
+			stats, err := cpu.ExecuteJIT(JITOptions{HotLoopThreshold: 1})
+			if stats.CompiledLoops != 0 {
+				t.Errorf("CPU.ExecuteJIT() stats = %+v, want the loop to be left to the interpreter", stats)
+			}
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(digitSumTestProgram)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			cpu.Registers[0] = 12345
+			if err := tt.execute(cpu); err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+
+			if cpu.Registers[1] != 15 {
+				t.Errorf("Execute() R[1] = %v, want 15", cpu.Registers[1])
+			}
+
+			cpu, _ = NewCPUFromProgramFile("#ip 5\nseti 4 0 0\ndivi 0 0 1")
+			if err := tt.execute(cpu); err != errTestDivideByZero {
+				t.Errorf("Execute() error = %v, want %v", err, errTestDivideByZero)
+			}
+		})
+	}
+}
+
+func TestRegisteredOpCodes_Assemble(t *testing.T) {
+	registerTestOpCodes(t)
+
+	assembly, err := Assemble("#ip 5\n.reg digit 3\n.const base 10\nloop: modr 0 2 digit\ndivi 0 base 0\nmaxr 1 digit 1")
+	if err != nil {
+		t.Fatalf("Assemble() error = %v", err)
+	}
+
+	want := Program{{testModR, 0, 2, 3}, {testDivI, 0, 10, 0}, {testMaxR, 1, 3, 1}}
+	if !reflect.DeepEqual(assembly.Program, want) {
+		t.Errorf("Assemble() = %v, want %v", assembly.Program, want)
+	}
+
+	if _, err := Assemble("#ip 5\ndivi 0 digit 0"); err == nil {
+		t.Errorf("Assemble() with an unknown value, want error")
+	}
+}
+
+func TestRegisteredOpCodes_Disassemble(t *testing.T) {
+	registerTestOpCodes(t)
+
+	program := Program{{testModR, 0, 2, 3}, {testDivI, 5, 10, 0}, {testMaxR, 1, 3, 1}}
+	want := []string{"r3 = r0 % r2", "r0 = 1 / 10", "r1 = maxr(r1, r3)"}
+
+	for ip, line := range program.Disassemble(5).Lines {
+		if line.Comment != want[ip] {
+			t.Errorf("Program.Disassemble() line %d = %q, want %q", ip, line.Comment, want[ip])
+		}
+	}
+}
+
+func TestRegisteredOpCodes_Transpile(t *testing.T) {
+	registerTestOpCodes(t)
+
+	cpu, err := NewCPUFromProgramFile(digitSumTestProgram)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	options := allTranspileOptions()
+	options.Backend = PseudoCodeBackend{}
+	transpiler := cpu.StartTranspiler(options)
+	transpiler.Registers[0].SetUnknownInt()
+
+	source, err := transpiler.Run()
+	if err != nil {
+		t.Fatalf("TranspileState.Run() error = %v", err)
+	}
+
+	for _, want := range []string{"R[3] = R[0] % R[2]", "R[0] = R[0] / 10"} {
+		if !strings.Contains(source, want) {
+			t.Errorf("TranspileState.Run() = %s\nwant it to contain %q", source, want)
+		}
+	}
+
+	// With a constant input B the immediate version is used instead
+	cpu, err = NewCPUFromProgramFile("#ip 5\nseti 10 0 2\nmodr 0 2 3\naddr 3 3 1")
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	transpiler = cpu.StartTranspiler(options)
+	transpiler.Registers[0].SetUnknownInt()
+
+	source, err = transpiler.Run()
+	if err != nil {
+		t.Fatalf("TranspileState.Run() error = %v", err)
+	}
+
+	if want := "R[3] = R[0] % 10 // IP: 1 (modr 0 2 3)"; !strings.Contains(source, want) {
+		t.Errorf("TranspileState.Run() = %s\nwant it to contain %q", source, want)
+	}
+}
+
+func TestRegisteredOpCodes_MarshalBinary(t *testing.T) {
+	registerTestOpCodes(t)
+
+	program := Program{{testMaxR, 1, 3, 1}, {AddR, 1, 2, 3}, {testDivI, 0, 10, 0}}
+	data, err := program.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Program.MarshalBinary() error = %v", err)
+	}
+	want := fmt.Sprint(program)
+
+	definitions := make(map[OpCode]OpCodeDefinition)
+	for _, opCode := range []OpCode{testMaxR, testModR, testModI, testDivI} {
+		definitions[opCode], _ = opCode.Definition()
+		if err := UnregisterOpCode(opCode); err != nil {
+			t.Fatalf("UnregisterOpCode() error = %v", err)
+		}
+	}
+
+	var got Program
+	if err := got.UnmarshalBinary(data); err == nil || err.Error() != `unknown op code "divi"` {
+		t.Errorf("Program.UnmarshalBinary() without the op codes registered error = %v", err)
+	}
+
+	// Registering the op codes again in another order gives them different numbers
+	for _, opCode := range []OpCode{testMaxR, testDivI, testModI, testModR} {
+		if _, err := RegisterOpCode(definitions[opCode]); err != nil {
+			t.Fatalf("RegisterOpCode() error = %v", err)
+		}
+	}
+
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("Program.UnmarshalBinary() error = %v", err)
+	}
+
+	if fmt.Sprint(got) != want || got[0].OpCode == testMaxR {
+		t.Errorf("Program.UnmarshalBinary() = %v (%d), want %v", got, got[0].OpCode, want)
+	}
+}
+
+func TestRegisteredOpCodes_ExecuteSymbolic(t *testing.T) {
+	registerTestOpCodes(t)
+
+	cpu, err := NewCPUFromProgramFile("#ip 5\ndivi 0 2 1\nseti 9 0 2\ndivi 2 2 3\nmaxr 1 3 4")
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	paths, err := cpu.ExecuteSymbolic(SymbolicOptions{Inputs: []int{0}})
+	if err != nil {
+		t.Fatalf("CPU.ExecuteSymbolic() error = %v", err)
+	}
+
+	registers := make([]string, len(paths[0].Registers))
+	for i, register := range paths[0].Registers {
+		registers[i] = register.String()
+	}
+
+	if want := []string{"r0", "r0 / 2", "9", "4", "maxr(r0 / 2, 4)", "3"}; !reflect.DeepEqual(registers, want) {
+		t.Errorf("CPU.ExecuteSymbolic() registers = %q, want %q", registers, want)
+	}
+}
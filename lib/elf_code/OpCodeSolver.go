@@ -0,0 +1,274 @@
+package elf_code
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A sample of an instruction being executed, where the op code of the instruction is an unknown number
+// (as read by `NewInstructionFromNumber`)
+type OpCodeSample struct {
+	Before      Registers   // The registers before the instruction
+	Instruction Instruction // The instruction, with the unknown op code number
+	After       Registers   // The registers after the instruction
+}
+
+// Tests if executing the sample's instruction as the given op code gives the after registers
+func (s OpCodeSample) Matches(opCode OpCode) bool {
+	f, found := OpCodeFunc[opCode]
+	if !found {
+		return false
+	}
+
+	registers := s.Before.Copy()
+	value, err := f(s.Instruction.A, s.Instruction.B, registers)
+	if err != nil {
+		return false
+	}
+
+	if err = registers.Set(s.Instruction.C, value); err != nil {
+		return false
+	}
+
+	if len(registers) != len(s.After) {
+		return false
+	}
+	for i := range registers {
+		if registers[i] != s.After[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Finds all the op codes the sample could have been
+func (s OpCodeSample) MatchingOpCodes() (matching []OpCode) {
+	matching = make([]OpCode, 0)
+
+	for opCode := OpCode(0); opCode < NumOpCodes; opCode++ {
+		if s.Matches(opCode) {
+			matching = append(matching, opCode)
+		}
+	}
+
+	return
+}
+
+// Op code number => the op code it is
+type OpCodeMapping map[int]OpCode
+
+// Returned when no mapping fits the samples, as every op code has been taken by other numbers
+var ErrNoOpCodeMapping = errors.New("no op code mapping fits the samples")
+
+// Returned when a sample doesn't match any of the op codes left for its number
+type ContradictorySampleError struct {
+	Sample int // The index of the sample
+	Number int // The op code number of the sample
+}
+
+func (e *ContradictorySampleError) Error() string {
+	return fmt.Sprintf("sample %d leaves no op code for number %d", e.Sample, e.Number)
+}
+
+// Returned when the samples fit more than one mapping
+type AmbiguousOpCodesError struct {
+	Mapping    OpCodeMapping    // One of the mappings which fits the samples
+	Candidates map[int][]OpCode // Ambiguous op code number => the op codes it could be
+}
+
+func (e *AmbiguousOpCodesError) Error() string {
+	numbers := make([]int, 0, len(e.Candidates))
+	for number := range e.Candidates {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	return fmt.Sprintf("samples fit more than one op code for numbers %v", numbers)
+}
+
+// Works out which op code each number in the samples is. Each op code can only be used by one number, so once
+// a number is known it is removed from the others. If that isn't enough, the remaining options are searched.
+//
+// An `*AmbiguousOpCodesError` is returned if the samples fit more than one mapping and a `*ContradictorySampleError`
+// if a sample doesn't fit any of the op codes the other samples with its number allow
+func SolveOpCodes(samples []OpCodeSample) (mapping OpCodeMapping, err error) {
+	candidates := make(map[int][]OpCode)
+
+	for i, sample := range samples {
+		number := int(sample.Instruction.OpCode)
+		matching := sample.MatchingOpCodes()
+
+		if existing, found := candidates[number]; found {
+			matching = intersectOpCodes(existing, matching)
+		}
+
+		if len(matching) == 0 {
+			return nil, &ContradictorySampleError{i, number}
+		}
+		candidates[number] = matching
+	}
+
+	if !propagateOpCodes(candidates) {
+		return nil, ErrNoOpCodeMapping
+	}
+
+	mapping = solveOpCodes(candidates)
+	if mapping == nil {
+		return nil, ErrNoOpCodeMapping
+	}
+
+	// Check if any number could be another op code in a different mapping
+	ambiguous := make(map[int][]OpCode)
+	for number, options := range candidates {
+		for _, opCode := range options {
+			if opCode == mapping[number] {
+				continue
+			}
+
+			restricted := copyOpCodeCandidates(candidates)
+			restricted[number] = []OpCode{opCode}
+			if propagateOpCodes(restricted) && solveOpCodes(restricted) != nil {
+				ambiguous[number] = append(ambiguous[number], opCode)
+			}
+		}
+
+		if len(ambiguous[number]) > 0 {
+			ambiguous[number] = append(ambiguous[number], mapping[number])
+			sort.Slice(ambiguous[number], func(i, j int) bool { return ambiguous[number][i] < ambiguous[number][j] })
+		}
+	}
+
+	if len(ambiguous) > 0 {
+		return nil, &AmbiguousOpCodesError{mapping, ambiguous}
+	}
+
+	return mapping, nil
+}
+
+// Replaces the op code numbers in a program read by `NewInstructionFromNumber` with the op codes they map to
+func (m OpCodeMapping) Remap(program Program) (res Program, err error) {
+	res = make(Program, len(program))
+
+	for ip, instruction := range program {
+		opCode, found := m[int(instruction.OpCode)]
+		if !found {
+			return nil, fmt.Errorf("unknown op code number %d at %d", instruction.OpCode, ip)
+		}
+
+		instruction.OpCode = opCode
+		res[ip] = instruction
+	}
+
+	return res, nil
+}
+
+// Removes the op codes of numbers with only one option from every other number, until nothing changes.
+// Returns false if a number is left without any options
+func propagateOpCodes(candidates map[int][]OpCode) bool {
+	for changed := true; changed; {
+		changed = false
+
+		for number, options := range candidates {
+			if len(options) != 1 {
+				continue
+			}
+
+			for other, otherOptions := range candidates {
+				if other == number {
+					continue
+				}
+
+				remaining := make([]OpCode, 0, len(otherOptions))
+				for _, opCode := range otherOptions {
+					if opCode != options[0] {
+						remaining = append(remaining, opCode)
+					}
+				}
+
+				if len(remaining) == 0 {
+					return false
+				}
+				if len(remaining) != len(otherOptions) {
+					candidates[other] = remaining
+					changed = true
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// Searches for a mapping where every number has a different op code, or nil if there isn't one
+func solveOpCodes(candidates map[int][]OpCode) OpCodeMapping {
+	// Try the numbers with the fewest options first
+	numbers := make([]int, 0, len(candidates))
+	for number := range candidates {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool {
+		if len(candidates[numbers[i]]) != len(candidates[numbers[j]]) {
+			return len(candidates[numbers[i]]) < len(candidates[numbers[j]])
+		}
+		return numbers[i] < numbers[j]
+	})
+
+	mapping := make(OpCodeMapping, len(numbers))
+	used := make(map[OpCode]bool)
+
+	var search func(index int) bool
+	search = func(index int) bool {
+		if index == len(numbers) {
+			return true
+		}
+
+		number := numbers[index]
+		for _, opCode := range candidates[number] {
+			if used[opCode] {
+				continue
+			}
+
+			mapping[number] = opCode
+			used[opCode] = true
+			if search(index + 1) {
+				return true
+			}
+			used[opCode] = false
+		}
+
+		delete(mapping, number)
+		return false
+	}
+
+	if !search(0) {
+		return nil
+	}
+
+	return mapping
+}
+
+func intersectOpCodes(a []OpCode, b []OpCode) []OpCode {
+	res := make([]OpCode, 0)
+
+	for _, opCode := range a {
+		for _, other := range b {
+			if opCode == other {
+				res = append(res, opCode)
+				break
+			}
+		}
+	}
+
+	return res
+}
+
+func copyOpCodeCandidates(candidates map[int][]OpCode) map[int][]OpCode {
+	res := make(map[int][]OpCode, len(candidates))
+	for number, options := range candidates {
+		res[number] = append([]OpCode(nil), options...)
+	}
+
+	return res
+}
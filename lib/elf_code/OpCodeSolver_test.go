@@ -0,0 +1,127 @@
+package elf_code
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestOpCodeSample_MatchingOpCodes(t *testing.T) {
+	// The example from day 16
+	sample := OpCodeSample{Registers{3, 2, 1, 1}, Instruction{9, 2, 1, 2}, Registers{3, 2, 2, 1}}
+
+	if got, want := sample.MatchingOpCodes(), []OpCode{AddI, MulR, SetI}; !reflect.DeepEqual(got, want) {
+		t.Errorf("OpCodeSample.MatchingOpCodes() = %v, want %v", got, want)
+	}
+}
+
+// Creates samples of every op code, using `numbers` to give each op code its number
+func samplesForNumbers(seed int64, numbers []int, samplesPerOpCode int) []OpCodeSample {
+	random := rand.New(rand.NewSource(seed))
+	samples := make([]OpCodeSample, 0)
+
+	for opCode := OpCode(0); opCode < NumOpCodes; opCode++ {
+		for len(samples) < int(opCode+1)*samplesPerOpCode {
+			before := Registers{random.Intn(4), random.Intn(4), random.Intn(4), random.Intn(4)}
+			instruction := Instruction{opCode, random.Intn(4), random.Intn(4), random.Intn(4)}
+
+			after := before.Copy()
+			value, err := OpCodeFunc[opCode](instruction.A, instruction.B, after)
+			if err != nil {
+				continue
+			}
+			after[instruction.C] = value
+
+			instruction.OpCode = OpCode(numbers[opCode])
+			samples = append(samples, OpCodeSample{before, instruction, after})
+		}
+	}
+
+	return samples
+}
+
+func TestSolveOpCodes(t *testing.T) {
+	numbers := rand.New(rand.NewSource(16)).Perm(int(NumOpCodes))
+	samples := samplesForNumbers(16, numbers, 10)
+
+	mapping, err := SolveOpCodes(samples)
+	if err != nil {
+		t.Fatalf("SolveOpCodes() error = %v", err)
+	}
+
+	for opCode, number := range numbers {
+		if mapping[number] != OpCode(opCode) {
+			t.Errorf("SolveOpCodes()[%d] = %v, want %v", number, mapping[number], OpCode(opCode))
+		}
+	}
+
+	// The mapping can then be used to load programs
+	program := Program{{OpCode(numbers[SetI]), 5, 0, 1}, {OpCode(numbers[MulR]), 1, 1, 0}}
+	got, err := mapping.Remap(program)
+	if err != nil {
+		t.Fatalf("OpCodeMapping.Remap() error = %v", err)
+	}
+
+	if want := (Program{{SetI, 5, 0, 1}, {MulR, 1, 1, 0}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("OpCodeMapping.Remap() = %v, want %v", got, want)
+	}
+
+	if _, err := (OpCodeMapping{0: AddR}).Remap(Program{{0, 0, 0, 0}, {1, 0, 0, 0}}); err == nil {
+		t.Errorf("OpCodeMapping.Remap() with an unknown number, want error")
+	}
+}
+
+func TestSolveOpCodes_Errors(t *testing.T) {
+	// Matches addi, mulr and seti
+	sample := func(number int) OpCodeSample {
+		return OpCodeSample{Registers{3, 2, 1, 1}, Instruction{OpCode(number), 2, 1, 2}, Registers{3, 2, 2, 1}}
+	}
+
+	// Two numbers with three possible op codes between them
+	_, err := SolveOpCodes([]OpCodeSample{sample(1), sample(2)})
+	var ambiguous *AmbiguousOpCodesError
+	if !errors.As(err, &ambiguous) {
+		t.Fatalf("SolveOpCodes() error = %v, want an AmbiguousOpCodesError", err)
+	}
+
+	want := map[int][]OpCode{1: {AddI, MulR, SetI}, 2: {AddI, MulR, SetI}}
+	if !reflect.DeepEqual(ambiguous.Candidates, want) {
+		t.Errorf("SolveOpCodes() candidates = %v, want %v", ambiguous.Candidates, want)
+	}
+
+	// Only seti, then only addi or bori for the same number
+	_, err = SolveOpCodes([]OpCodeSample{
+		{Registers{0, 0, 0, 0}, Instruction{3, 5, 0, 1}, Registers{0, 5, 0, 0}},
+		{Registers{0, 0, 0, 0}, Instruction{3, 0, 7, 1}, Registers{0, 7, 0, 0}},
+	})
+	if want := (&ContradictorySampleError{1, 3}); !reflect.DeepEqual(err, want) {
+		t.Errorf("SolveOpCodes() error = %v, want %v", err, want)
+	}
+
+	// Two numbers which can only be seti
+	_, err = SolveOpCodes([]OpCodeSample{
+		{Registers{0, 0, 0, 0}, Instruction{3, 5, 0, 1}, Registers{0, 5, 0, 0}},
+		{Registers{0, 0, 0, 0}, Instruction{4, 5, 0, 1}, Registers{0, 5, 0, 0}},
+	})
+	if err != ErrNoOpCodeMapping {
+		t.Errorf("SolveOpCodes() error = %v, want %v", err, ErrNoOpCodeMapping)
+	}
+}
+
+func Test_solveOpCodes(t *testing.T) {
+	// No number has a single option, so the search has to find that 2 is mulr
+	candidates := map[int][]OpCode{0: {AddR, AddI}, 1: {AddR, AddI}, 2: {AddR, AddI, MulR}}
+	if propagateOpCodes(candidates); len(candidates[2]) != 3 {
+		t.Fatalf("propagateOpCodes() = %v, want nothing removed", candidates)
+	}
+
+	mapping := solveOpCodes(candidates)
+	if mapping == nil || mapping[2] != MulR || mapping[0] == mapping[1] {
+		t.Errorf("solveOpCodes() = %v, want 2 to be mulr", mapping)
+	}
+
+	if got := solveOpCodes(map[int][]OpCode{0: {AddR, AddI}, 1: {AddR, AddI}, 2: {AddR, AddI}}); got != nil {
+		t.Errorf("solveOpCodes() = %v, want nil", got)
+	}
+}
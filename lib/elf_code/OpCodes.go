@@ -64,11 +64,18 @@ func (o OpCode) String() string {
 	case EqRR:
 		return "eqrr"
 	default:
+		if registered, found := registeredOpCodes[o]; found {
+			return registered.Name
+		}
 		return "Unknown OpCode: " + strconv.Itoa(int(o))
 	}
 }
 
 func (o OpCode) isComparator() bool {
+	if registered, found := registeredOpCodes[o]; found {
+		return registered.Comparator
+	}
+
 	return o >= GtIR && o <= EqRR
 }
 
@@ -108,6 +115,10 @@ func ParseOpCode(str string) (opCode OpCode, err error) {
 	case "eqrr":
 		return EqRR, nil
 	default:
+		if opCode, found := registeredOpCodeNames[strings.ToLower(str)]; found {
+			return opCode, nil
+		}
+
 		err = errors.New("unknown op code: " + str)
 		return
 	}
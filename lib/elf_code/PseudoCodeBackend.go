@@ -0,0 +1,154 @@
+package elf_code
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Writes the program out as readable pseudo code, which isn't intended to be run
+type PseudoCodeBackend struct{}
+
+func (PseudoCodeBackend) Indent() string {
+	return "    "
+}
+
+func (PseudoCodeBackend) WriteProgramStart(t *TranspileState, functions []*ProgramBlock, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("registers R[0..%d]\n\n", len(t.Registers)-1))
+}
+
+func (PseudoCodeBackend) WriteProgramEnd(t *TranspileState, functions []*ProgramBlock, str *strings.Builder) {
+}
+
+func (PseudoCodeBackend) WriteFunctionStart(t *TranspileState, b *ProgramBlock, isMain bool, str *strings.Builder) {
+	// Jumps back to the start of the program call the main block, so it needs a name of its own
+	if isMain && len(b.calledBy) > 0 {
+		str.WriteString(fmt.Sprintf("function main\n    call block%d\nend\n\nfunction block%d\n", b.blockNum, b.blockNum))
+	} else if isMain {
+		str.WriteString("function main\n")
+	} else {
+		str.WriteString(fmt.Sprintf("function block%d\n", b.blockNum))
+	}
+}
+
+func (PseudoCodeBackend) WriteFunctionEnd(t *TranspileState, b *ProgramBlock, isMain bool, str *strings.Builder) {
+	str.WriteString("end\n\n")
+}
+
+func (PseudoCodeBackend) WriteStatement(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent)
+
+	if line.instruction.OpCode.isComparator() {
+		str.WriteString(fmt.Sprintf("R[%d] = (", line.instruction.C))
+		line.WriteExpression(str)
+		str.WriteString(") ? 1 : 0")
+	} else {
+		line.WriteInstruction(str)
+	}
+
+	str.WriteString(fmt.Sprintf(" // %s\n", t.ipComment(line.ip)))
+}
+
+func (PseudoCodeBackend) WriteCall(t *TranspileState, line *ProgramLine, target *ProgramBlock, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%scall block%d", indent, target.blockNum))
+	if line != nil {
+		str.WriteString(fmt.Sprintf(" // %s", t.ipComment(line.ip)))
+	}
+	str.WriteRune('\n')
+}
+
+func (PseudoCodeBackend) WriteIfStart(t *TranspileState, line *ProgramLine, register int, value int, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%sif R[%d] == %d then // %s\n", indent, register, value, t.ipComment(line.ip)))
+}
+
+func (PseudoCodeBackend) WriteElse(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent + "else\n")
+}
+
+func (PseudoCodeBackend) WriteIfEnd(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent + "end\n")
+}
+
+func (PseudoCodeBackend) WriteDoWhileStart(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	str.WriteString(indent + "do\n")
+}
+
+func (PseudoCodeBackend) WriteDoWhileEnd(t *TranspileState, line *ProgramLine, register int, value int, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%swhile R[%d] == %d // %s\n", indent, register, value, t.ipComment(line.ip)))
+}
+
+func (PseudoCodeBackend) WriteIdiom(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder) {
+	writeIdiomAssignments(t, line, indent, "", str)
+}
+
+func (PseudoCodeBackend) WriteComment(t *TranspileState, comment string, indent string, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("%s// %s\n", indent, comment))
+}
+
+func (PseudoCodeBackend) Finish(source string) (string, error) {
+	return source, nil
+}
+
+func (PseudoCodeBackend) WriteASTStart(d *DecompiledProgram, str *strings.Builder) {
+	str.WriteString(fmt.Sprintf("registers R[0..%d]\n\nfunction main\n", d.NumRegisters-1))
+}
+
+func (PseudoCodeBackend) WritesGotos() bool {
+	return true
+}
+
+func (PseudoCodeBackend) WriteASTEnd(d *DecompiledProgram, str *strings.Builder) {
+	str.WriteString("end\n")
+}
+
+func (PseudoCodeBackend) WriteASTNodeStart(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder) {
+	str.WriteString(indent)
+	if node.Labelled && node.Kind != ASTBreak && node.Kind != ASTContinue {
+		str.WriteString(node.loopName() + ": ")
+	}
+
+	switch node.Kind {
+	case ASTStatement:
+		line := node.programLine()
+		if node.Instruction.OpCode.isComparator() {
+			str.WriteString(fmt.Sprintf("R[%d] = (", node.Instruction.C))
+			line.WriteExpression(str)
+			str.WriteString(") ? 1 : 0")
+		} else {
+			line.WriteInstruction(str)
+		}
+		str.WriteString(d.nodeComment(node))
+	case ASTIf:
+		str.WriteString(fmt.Sprintf("if %s then%s", node.Condition, d.nodeComment(node)))
+	case ASTWhile:
+		str.WriteString(fmt.Sprintf("while %s do%s", node.Condition, d.nodeComment(node)))
+	case ASTDoWhile:
+		str.WriteString("do")
+	case ASTLoop:
+		str.WriteString("loop")
+	case ASTBreak, ASTContinue:
+		str.WriteString(node.Kind.String())
+		if node.Labelled {
+			str.WriteString(" " + node.loopName())
+		}
+	case ASTHalt:
+		str.WriteString("halt")
+	case ASTGoto:
+		str.WriteString(fmt.Sprintf("goto L%d // irreducible control flow", node.Label))
+	case ASTLabel:
+		str.WriteString(fmt.Sprintf("L%d:", node.Label))
+	}
+
+	str.WriteRune('\n')
+}
+
+func (PseudoCodeBackend) WriteASTElse(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder) {
+	str.WriteString(indent + "else\n")
+}
+
+func (PseudoCodeBackend) WriteASTNodeEnd(d *DecompiledProgram, node *ASTNode, indent string, str *strings.Builder) {
+	if node.Kind == ASTDoWhile {
+		str.WriteString(fmt.Sprintf("%swhile %s%s\n", indent, node.Condition, d.nodeComment(node)))
+	} else {
+		str.WriteString(indent + "end\n")
+	}
+}
@@ -0,0 +1,1106 @@
+package elf_code
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// What an SSA value is
+type SSAValueKind int
+
+const (
+	SSAParam       SSAValueKind = iota // The value a register held when the program started
+	SSAConst                           // A constant, such as an immediate input
+	SSAPhi                             // Picks a value depending on which block execution came from
+	SSAInstruction                     // The result of an op code
+)
+
+// A value in the SSA form of a program, which is only ever defined once
+type SSAValue struct {
+	ID       int          // Unique within the function
+	Kind     SSAValueKind // What the value is
+	Type     DataType     // Is the value an int or a boolean comparison result?
+	Register int          // The register the value is held in, or -1 for constants
+	Const    int          // The value of constants
+	OpCode   OpCode       // The op code of instructions
+	Args     []*SSAValue  // The A and B inputs of instructions, or one value per predecessor of the block for phis
+	Block    *SSABlock    // The block the value is defined in, nil for constants
+	IP       int          // The instruction pointer of instructions in the original program, otherwise -1
+}
+
+func (v *SSAValue) String() string {
+	if v.Kind == SSAConst {
+		return fmt.Sprintf("%d", v.Const)
+	}
+
+	return fmt.Sprintf("v%d", v.ID)
+}
+
+// The definition of the value, such as `v3 = addi v1 5 ; r2`
+func (v *SSAValue) Definition() string {
+	var str strings.Builder
+	str.WriteString(fmt.Sprintf("%s = ", v))
+
+	switch v.Kind {
+	case SSAParam:
+		str.WriteString("param")
+	case SSAConst:
+		str.WriteString("const")
+	case SSAPhi:
+		str.WriteString("phi")
+	case SSAInstruction:
+		str.WriteString(v.OpCode.String())
+	}
+
+	for _, arg := range v.Args {
+		str.WriteString(" ")
+		str.WriteString(arg.String())
+	}
+
+	if v.Kind != SSAConst {
+		str.WriteString(fmt.Sprintf(" ; r%d", v.Register))
+	}
+	if v.Type == BoolType {
+		str.WriteString(" bool")
+	}
+
+	return str.String()
+}
+
+// How a block of an SSA function ends
+type SSATerminator int
+
+const (
+	SSAJump   SSATerminator = iota // Continue at the only successor
+	SSABranch                      // Continue at the first successor if the condition is 1, otherwise the second
+	SSAHalt                        // Stop the program
+)
+
+// A straight run of SSA values, with the phis coming first
+type SSABlock struct {
+	ID           int           // The block number, the entry block is 0
+	IP           int           // The first instruction pointer of the block in the original program, -1 if it has none
+	Phis         []*SSAValue   // The phis, one for each register with a value depending on the predecessor
+	Values       []*SSAValue   // The instructions in the order they are executed
+	Predecessors []*SSABlock   // The blocks which can move to this block, in the same order as the phi arguments
+	Successors   []*SSABlock   // The blocks this block can move to, see `SSATerminator`
+	Terminator   SSATerminator // How the block ends
+	Condition    *SSAValue     // The comparison result picking the successor of branches
+	Exit         []*SSAValue   // The values of the registers when halting, nil for the IP register
+}
+
+func (b *SSABlock) String() string {
+	return fmt.Sprintf("b%d", b.ID)
+}
+
+// A program lifted into SSA form. The values are held in the registers they were written to, so optimisations can
+// move values between registers (with copies added when lowering) but not keep a value after its register is written
+type SSAFunction struct {
+	Blocks       []*SSABlock // The blocks in the order they are laid out, the entry block is first
+	Params       []*SSAValue // The values of the registers when the program starts, nil for the IP register
+	IPRegister   int         // The register bound to the instruction pointer
+	NumRegisters int         // The number of registers of the CPU
+	Word         WordSize    // The word size constants are wrapped to
+
+	nextValueID int
+}
+
+// Lifts the program loaded into the CPU into SSA form, see `Program.SSA`
+func (cpu *CPU) SSA(booleanInputs ...int) (f *SSAFunction, err error) {
+	return liftSSA(cpu.Program, cpu.InstructionPointerRegister, len(cpu.Registers), cpu.Word, booleanInputs)
+}
+
+// Lifts the program into SSA form starting at instruction 0, with native words. `booleanInputs` are registers
+// which can be assumed to hold 0 or 1, see `Program.JumpAt`. Programs with jumps computed from registers can't be lifted
+func (p Program) SSA(ipRegister int, numRegisters int, booleanInputs ...int) (f *SSAFunction, err error) {
+	return liftSSA(p, ipRegister, numRegisters, WordSize{}, booleanInputs)
+}
+
+func liftSSA(program Program, ipRegister int, numRegisters int, word WordSize, booleanInputs []int) (f *SSAFunction, err error) {
+	if err = program.Validate(numRegisters); err != nil {
+		return nil, err
+	}
+
+	f = &SSAFunction{IPRegister: ipRegister, NumRegisters: numRegisters, Word: word}
+	graph := NewControlFlowGraph(program, ipRegister, numRegisters, booleanInputs...)
+
+	entry := f.newBlock(-1)
+	f.Params = make([]*SSAValue, numRegisters)
+	for register := range f.Params {
+		if register != ipRegister {
+			f.Params[register] = f.newValue(SSAParam, entry, register)
+		}
+	}
+	for _, register := range booleanInputs {
+		if register >= 0 && register < numRegisters && register != ipRegister {
+			f.Params[register].Type = BoolType
+		}
+	}
+
+	blocks := make(map[int]*SSABlock)
+	for _, block := range graph.Blocks {
+		if !block.Reachable {
+			continue
+		}
+
+		if block.ComputedJump {
+			return nil, fmt.Errorf("unable to lift computed jump at %d", block.End)
+		}
+
+		blocks[block.Number] = f.newBlock(block.Start)
+	}
+
+	// Jumps out of the program all go to a single exit block, so the values when halting are in one place
+	exit := &SSABlock{IP: -1, Terminator: SSAHalt}
+	target := func(ip int) *SSABlock {
+		if ip < 0 || ip >= len(program) {
+			return exit
+		}
+
+		return blocks[graph.BlockAt(ip).Number]
+	}
+
+	// The values of the registers at the end of each block
+	out := map[*SSABlock][]*SSAValue{entry: f.Params}
+	link(entry, target(0))
+
+	for _, block := range graph.Blocks {
+		if !block.Reachable {
+			continue
+		}
+
+		current := f.addPhis(blocks[block.Number])
+		for ip := block.Start; ip <= block.End; ip++ {
+			instruction := program[ip]
+			if instruction.C == ipRegister {
+				break // Only the last instruction of a block can jump
+			}
+
+			isImmediate := OpCodeInputType[instruction.OpCode]
+			value := f.newValue(SSAInstruction, blocks[block.Number], instruction.C)
+			value.OpCode = instruction.OpCode
+			value.IP = ip
+			value.Args = []*SSAValue{
+				f.liftOperand(instruction.A, isImmediate.A, ip, current),
+				f.liftOperand(instruction.B, isImmediate.B, ip, current),
+			}
+
+			blocks[block.Number].Values = append(blocks[block.Number].Values, value)
+			current[instruction.C] = value
+		}
+		out[blocks[block.Number]] = current
+
+		jump := program.JumpAt(block.End, ipRegister, booleanInputs...)
+		if jump.Kind == ConditionalJump {
+			blocks[block.Number].Terminator = SSABranch
+			blocks[block.Number].Condition = current[jump.ConditionRegister]
+			link(blocks[block.Number], target(jump.Targets[1]))
+		}
+		link(blocks[block.Number], target(jump.Targets[0]))
+	}
+
+	if len(exit.Predecessors) > 0 {
+		exit.ID = len(f.Blocks)
+		f.Blocks = append(f.Blocks, exit)
+		exit.Exit = f.addPhis(exit)
+	}
+
+	// Now every block has been lifted, the phis can be given their arguments
+	for _, block := range f.Blocks {
+		for _, phi := range block.Phis {
+			for _, previous := range block.Predecessors {
+				phi.Args = append(phi.Args, out[previous][phi.Register])
+			}
+		}
+	}
+
+	f.removeTrivialPhis()
+	f.inferTypes()
+
+	return f, nil
+}
+
+func (f *SSAFunction) newBlock(ip int) (block *SSABlock) {
+	block = &SSABlock{ID: len(f.Blocks), IP: ip}
+	f.Blocks = append(f.Blocks, block)
+
+	return
+}
+
+func (f *SSAFunction) newValue(kind SSAValueKind, block *SSABlock, register int) (value *SSAValue) {
+	value = &SSAValue{ID: f.nextValueID, Kind: kind, Register: register, Block: block, IP: -1}
+	f.nextValueID++
+
+	return
+}
+
+// Creates a constant, which isn't part of any block
+func (f *SSAFunction) newConst(value int) *SSAValue {
+	constant := f.newValue(SSAConst, nil, -1)
+	constant.Const = value
+
+	return constant
+}
+
+// Adds a phi to the block for every register, returning the values of the registers at the start of the block
+func (f *SSAFunction) addPhis(block *SSABlock) (current []*SSAValue) {
+	current = make([]*SSAValue, f.NumRegisters)
+
+	for register := range current {
+		if register != f.IPRegister {
+			current[register] = f.newValue(SSAPhi, block, register)
+			block.Phis = append(block.Phis, current[register])
+		}
+	}
+
+	return
+}
+
+// Immediate inputs, and reads of the instruction pointer, are constants
+func (f *SSAFunction) liftOperand(operand int, isImmediate bool, ip int, current []*SSAValue) *SSAValue {
+	if isImmediate {
+		return f.newConst(operand)
+	}
+
+	if operand == f.IPRegister {
+		return f.newConst(ip)
+	}
+
+	return current[operand]
+}
+
+func link(from *SSABlock, to *SSABlock) {
+	from.Successors = append(from.Successors, to)
+	to.Predecessors = append(to.Predecessors, from)
+}
+
+// Removes phis which only ever pick one value (other than themselves), as every register got a phi
+// at the start of every block
+func (f *SSAFunction) removeTrivialPhis() {
+	for changed := true; changed; {
+		changed = false
+
+		for _, block := range f.Blocks {
+			phis := block.Phis[:0]
+
+			for _, phi := range block.Phis {
+				var same *SSAValue
+				trivial := true
+
+				for _, arg := range phi.Args {
+					if arg == phi || arg == same {
+						continue
+					}
+
+					if same != nil {
+						trivial = false
+						break
+					}
+					same = arg
+				}
+
+				if trivial && same != nil {
+					f.replaceUses(phi, same)
+					changed = true
+				} else {
+					phis = append(phis, phi)
+				}
+			}
+
+			block.Phis = phis
+		}
+	}
+}
+
+// Makes everything using `old` use `value` instead
+func (f *SSAFunction) replaceUses(old *SSAValue, value *SSAValue) {
+	for _, use := range f.uses(old) {
+		*use = value
+	}
+}
+
+// Everywhere the value is used by phis, instructions, branches and when halting
+func (f *SSAFunction) uses(value *SSAValue) (uses []**SSAValue) {
+	find := func(values []*SSAValue) {
+		for i := range values {
+			if values[i] == value {
+				uses = append(uses, &values[i])
+			}
+		}
+	}
+
+	for _, block := range f.Blocks {
+		for _, phi := range block.Phis {
+			find(phi.Args)
+		}
+
+		for _, instruction := range block.Values {
+			find(instruction.Args)
+		}
+
+		if block.Condition == value {
+			uses = append(uses, &block.Condition)
+		}
+		find(block.Exit)
+	}
+
+	return
+}
+
+// Comparisons are booleans, as are copies of them and phis which only pick booleans
+func (f *SSAFunction) inferTypes() {
+	for _, block := range f.Blocks {
+		for _, phi := range block.Phis {
+			phi.Type = BoolType
+		}
+
+		for _, instruction := range block.Values {
+			if instruction.OpCode.isComparator() || instruction.OpCode == SetR {
+				instruction.Type = BoolType
+			}
+		}
+	}
+
+	// Start assuming everything which could be a boolean is, and take it back until nothing changes
+	for changed := true; changed; {
+		changed = false
+
+		check := func(value *SSAValue, args []*SSAValue) {
+			if value.Type != BoolType {
+				return
+			}
+
+			for _, arg := range args {
+				if arg.Type != BoolType {
+					value.Type = IntType
+					changed = true
+					return
+				}
+			}
+		}
+
+		for _, block := range f.Blocks {
+			for _, phi := range block.Phis {
+				check(phi, phi.Args)
+			}
+
+			for _, instruction := range block.Values {
+				if instruction.OpCode == SetR {
+					check(instruction, instruction.Args[:1])
+				}
+			}
+		}
+	}
+}
+
+// The number of times each value is used by instructions, phis, branches and when halting
+func (f *SSAFunction) useCounts() (uses map[*SSAValue]int) {
+	uses = make(map[*SSAValue]int)
+
+	for _, block := range f.Blocks {
+		for _, phi := range block.Phis {
+			for _, arg := range phi.Args {
+				uses[arg]++
+			}
+		}
+
+		for _, instruction := range block.Values {
+			for _, arg := range instruction.Args {
+				uses[arg]++
+			}
+		}
+
+		if block.Condition != nil {
+			uses[block.Condition]++
+		}
+
+		for _, value := range block.Exit {
+			if value != nil {
+				uses[value]++
+			}
+		}
+	}
+
+	return
+}
+
+// Evaluates instructions whose inputs are all constant, turning them into `seti` and passing the result to the
+// instructions which use them as a constant. Returns the number of instructions changed
+func (f *SSAFunction) FoldConstants() (changes int) {
+	constantOf := func(value *SSAValue) (int, bool) {
+		switch {
+		case value.Kind == SSAConst:
+			return value.Const, true
+		case value.Kind == SSAInstruction && value.OpCode == SetI:
+			return value.Args[0].Const, true
+		default:
+			return 0, false
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, block := range f.Blocks {
+			for _, instruction := range block.Values {
+				if instruction.OpCode.talksToHost() {
+					continue
+				}
+
+				// Use the constants directly, rather than the registers holding them. Registered op codes
+				// have no immediate versions to lower to, so all their inputs need to be constant
+				allConstant := true
+				for _, arg := range instruction.Args {
+					if _, isConstant := constantOf(arg); !isConstant {
+						allConstant = false
+					}
+				}
+
+				if _, registered := registeredOpCodes[instruction.OpCode]; allConstant || !registered {
+					for i, arg := range instruction.Args {
+						if value, isConstant := constantOf(arg); isConstant && arg.Kind != SSAConst {
+							instruction.Args[i] = f.newConst(value)
+							changed = true
+							changes++
+						}
+					}
+				}
+
+				if instruction.OpCode == SetI || instruction.Args[0].Kind != SSAConst || instruction.Args[1].Kind != SSAConst {
+					continue
+				}
+
+				value, err := evaluateConstants(instruction)
+				if err != nil {
+					continue
+				}
+
+				instruction.OpCode = SetI
+				instruction.Args = []*SSAValue{f.newConst(f.Word.Wrap(value)), f.newConst(0)}
+				changed = true
+				changes++
+			}
+		}
+	}
+
+	return
+}
+
+// Evaluates an instruction whose inputs are all constants
+func evaluateConstants(instruction *SSAValue) (value int, err error) {
+	isImmediate := OpCodeInputType[instruction.OpCode]
+	registers := NewRegisters(2)
+	a, b := instruction.Args[0].Const, instruction.Args[1].Const
+
+	if !isImmediate.A {
+		registers[0], a = a, 0
+	}
+	if !isImmediate.B {
+		registers[1], b = b, 1
+	}
+
+	return OpCodeFunc[instruction.OpCode](a, b, registers)
+}
+
+// Removes instructions and phis whose values are never used. Instructions which talk to the host are always kept.
+// Returns the number of values removed
+func (f *SSAFunction) RemoveDeadValues() (changes int) {
+	for changed := true; changed; {
+		changed = false
+		uses := f.useCounts()
+
+		for _, block := range f.Blocks {
+			phis := block.Phis[:0]
+			for _, phi := range block.Phis {
+				// A phi only used by itself is still dead
+				selfUses := 0
+				for _, arg := range phi.Args {
+					if arg == phi {
+						selfUses++
+					}
+				}
+
+				if uses[phi] > selfUses {
+					phis = append(phis, phi)
+				} else {
+					changed = true
+					changes++
+				}
+			}
+			block.Phis = phis
+
+			values := block.Values[:0]
+			for _, instruction := range block.Values {
+				if uses[instruction] > 0 || instruction.OpCode.talksToHost() {
+					values = append(values, instruction)
+				} else {
+					changed = true
+					changes++
+				}
+			}
+			block.Values = values
+		}
+	}
+
+	return
+}
+
+// Makes everything using the result of a `setr` use the value it copies instead, which can be in another register.
+// Copies are left alone if the value they copy is overwritten before everything using them. Returns the number of
+// copies propagated, which are left for `RemoveDeadValues` to remove
+func (f *SSAFunction) PropagateCopies() (changes int) {
+	for _, block := range f.Blocks {
+		for _, instruction := range block.Values {
+			if instruction.OpCode != SetR || instruction.Args[0].Kind == SSAConst {
+				continue
+			}
+
+			uses := f.uses(instruction)
+			if len(uses) == 0 {
+				continue
+			}
+
+			for _, use := range uses {
+				*use = instruction.Args[0]
+			}
+
+			if _, liveOut := f.liveValues(); f.checkRegisters(liveOut) != nil {
+				for _, use := range uses {
+					*use = instruction
+				}
+				continue
+			}
+
+			changes++
+		}
+	}
+
+	return
+}
+
+func (f *SSAFunction) String() string {
+	var str strings.Builder
+
+	for _, block := range f.Blocks {
+		str.WriteString(block.String())
+		switch {
+		case block.ID == 0:
+			str.WriteString(" (entry)")
+		case block.IP >= 0:
+			str.WriteString(fmt.Sprintf(" (ip %d)", block.IP))
+		case block.Terminator == SSAHalt:
+			str.WriteString(" (exit)")
+		}
+
+		for i, previous := range block.Predecessors {
+			if i == 0 {
+				str.WriteString(" from ")
+			} else {
+				str.WriteString(", ")
+			}
+			str.WriteString(previous.String())
+		}
+		str.WriteString(":\n")
+
+		if block.ID == 0 {
+			for _, param := range f.Params {
+				if param != nil {
+					str.WriteString("  " + param.Definition() + "\n")
+				}
+			}
+		}
+
+		for _, phi := range block.Phis {
+			str.WriteString("  " + phi.Definition() + "\n")
+		}
+
+		for _, instruction := range block.Values {
+			str.WriteString("  " + instruction.Definition() + "\n")
+		}
+
+		switch block.Terminator {
+		case SSAJump:
+			str.WriteString(fmt.Sprintf("  jump %s\n", block.Successors[0]))
+		case SSABranch:
+			str.WriteString(fmt.Sprintf("  branch %s %s %s\n", block.Condition, block.Successors[0], block.Successors[1]))
+		case SSAHalt:
+			str.WriteString("  halt")
+			for _, value := range block.Exit {
+				if value == nil {
+					str.WriteString(" _")
+				} else {
+					str.WriteString(" " + value.String())
+				}
+			}
+			str.WriteString("\n")
+		}
+	}
+
+	return str.String()
+}
+
+// Lowers the function back down to a program, laying the blocks out in order. The registers other
+// than the instruction pointer hold the same values as the original program when it halts. Phis with
+// arguments in other registers, and halting with values in other registers, are lowered to copies
+func (f *SSAFunction) Lower() (program Program, err error) {
+	liveIn, liveOut := f.liveValues()
+	if err = f.checkRegisters(liveOut); err != nil {
+		return nil, err
+	}
+
+	blocks, successors, copies, err := f.placeCopies(liveIn)
+	if err != nil {
+		return nil, err
+	}
+
+	// The number of instructions the block lowers to when followed by `next`, at least 2 for branches
+	loweredSize := func(block *SSABlock, next *SSABlock) (size int) {
+		size = len(block.Values) + len(copies[block])
+
+		switch block.Terminator {
+		case SSAJump:
+			if successors[block][0] != next {
+				size++
+			}
+		case SSABranch:
+			size += 2
+		case SSAHalt:
+			if next != nil {
+				size++
+			}
+		}
+
+		return
+	}
+
+	// Jumps are written once every block has an address, so keep track of which block each jump goes to
+	type jump struct {
+		ip     int
+		target *SSABlock // nil to jump out of the program
+	}
+	jumps := make([]jump, 0)
+	addresses := make(map[*SSABlock]int)
+
+	jumpTo := func(target *SSABlock) {
+		jumps = append(jumps, jump{len(program), target})
+		program = append(program, Instruction{SetI, 0, 0, f.IPRegister})
+	}
+
+	program = make(Program, 0)
+	for i, block := range blocks {
+		addresses[block] = len(program)
+
+		var next *SSABlock
+		if i+1 < len(blocks) {
+			next = blocks[i+1]
+		}
+
+		for _, value := range block.Values {
+			instruction, err := f.lowerInstruction(value)
+			if err != nil {
+				return nil, err
+			}
+			program = append(program, instruction)
+		}
+		program = append(program, copies[block]...)
+
+		switch block.Terminator {
+		case SSAJump:
+			if successors[block][0] != next {
+				jumpTo(successors[block][0])
+			}
+
+		case SSABranch:
+			// Adding the condition to the IP skips the instruction after it, which is usually a jump to the false
+			// block. If the false block is only a single instruction, followed by the true block, it can go there instead
+			program = append(program, Instruction{AddR, block.Condition.Register, f.IPRegister, f.IPRegister})
+
+			var afterNext *SSABlock
+			if i+2 < len(blocks) {
+				afterNext = blocks[i+2]
+			}
+			if successors[block][1] == next && successors[block][0] == afterNext && loweredSize(next, afterNext) == 1 {
+				break
+			}
+
+			jumpTo(successors[block][1])
+			if successors[block][0] != next {
+				jumpTo(successors[block][0])
+			}
+
+		case SSAHalt:
+			if next != nil {
+				jumpTo(nil)
+			}
+		}
+	}
+
+	for _, j := range jumps {
+		address, found := addresses[j.target]
+		if !found {
+			address = len(program)
+		}
+
+		program[j.ip].A = address - 1
+	}
+
+	return program, nil
+}
+
+// The values needed at the start and end of each block, other than the phis of the block. Phi arguments are
+// needed at the end of the predecessor they come from
+func (f *SSAFunction) liveValues() (liveIn map[*SSABlock]map[*SSAValue]bool, liveOut map[*SSABlock]map[*SSAValue]bool) {
+	liveIn = make(map[*SSABlock]map[*SSAValue]bool)
+	liveOut = make(map[*SSABlock]map[*SSAValue]bool)
+	for _, block := range f.Blocks {
+		liveIn[block] = make(map[*SSAValue]bool)
+		liveOut[block] = make(map[*SSAValue]bool)
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for i := len(f.Blocks) - 1; i >= 0; i-- {
+			block := f.Blocks[i]
+
+			for _, successor := range block.Successors {
+				for value := range liveIn[successor] {
+					liveOut[block][value] = true
+				}
+
+				for _, phi := range successor.Phis {
+					for index, previous := range successor.Predecessors {
+						if previous == block {
+							liveOut[block][phi.Args[index]] = true
+						}
+					}
+				}
+			}
+
+			for value := range f.liveBefore(block, liveOut[block], nil) {
+				if !liveIn[block][value] {
+					liveIn[block][value] = true
+					changed = true
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// Walks back through the block from the values live at its end, returning the values live at its start. `defined`
+// is called for each value written in the block, with the values live just after it
+func (f *SSAFunction) liveBefore(block *SSABlock, liveOut map[*SSAValue]bool, defined func(value *SSAValue, live map[*SSAValue]bool)) map[*SSAValue]bool {
+	live := make(map[*SSAValue]bool)
+	for value := range liveOut {
+		live[value] = true
+	}
+
+	use := func(value *SSAValue) {
+		if value != nil && value.Kind != SSAConst {
+			live[value] = true
+		}
+	}
+
+	use(block.Condition)
+	for _, value := range block.Exit {
+		use(value)
+	}
+
+	for i := len(block.Values) - 1; i >= 0; i-- {
+		delete(live, block.Values[i])
+		if defined != nil {
+			defined(block.Values[i], live)
+		}
+
+		for _, arg := range block.Values[i].Args {
+			use(arg)
+		}
+	}
+
+	// Phis, and the params of the entry block, are all written at the start of the block
+	starts := block.Phis
+	if block == f.Blocks[0] {
+		starts = f.Params
+	}
+
+	for _, value := range starts {
+		delete(live, value)
+	}
+	for _, value := range starts {
+		if value != nil && defined != nil {
+			defined(value, live)
+		}
+	}
+
+	return live
+}
+
+// Checks every value can stay in the register it was written to until it is last needed, and branches aren't on constants
+func (f *SSAFunction) checkRegisters(liveOut map[*SSABlock]map[*SSAValue]bool) (err error) {
+	for _, block := range f.Blocks {
+		if block.Condition != nil && block.Condition.Kind == SSAConst {
+			return fmt.Errorf("branch at the end of %s on a constant", block)
+		}
+
+		f.liveBefore(block, liveOut[block], func(value *SSAValue, live map[*SSAValue]bool) {
+			var overwritten *SSAValue
+			for other := range live {
+				if other.Register == value.Register && (overwritten == nil || other.ID < overwritten.ID) {
+					overwritten = other
+				}
+			}
+
+			if overwritten != nil && err == nil {
+				err = fmt.Errorf("%s in r%d overwrites %s, which is still needed", value, value.Register, overwritten)
+			}
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Works out the copies needed to move values into the registers they are needed in, returning the blocks to lay
+// out, where each goes next and the copies to make at the end of each. Branches going to a block with phis
+// which need copies go through a new block holding them, as the copies can't be made before the branch
+func (f *SSAFunction) placeCopies(liveIn map[*SSABlock]map[*SSAValue]bool) (blocks []*SSABlock, successors map[*SSABlock][]*SSABlock, copies map[*SSABlock][]Instruction, err error) {
+	successors = make(map[*SSABlock][]*SSABlock)
+	copies = make(map[*SSABlock][]Instruction)
+	edges := make([]*SSABlock, 0)
+
+	for _, block := range f.Blocks {
+		successors[block] = append([]*SSABlock{}, block.Successors...)
+
+		// Halting copies the values into the registers they halt in
+		if block.Terminator == SSAHalt {
+			moves := make(map[int]int)
+			for register, value := range block.Exit {
+				if value != nil {
+					moves[register] = value.Register
+				}
+			}
+
+			if copies[block], err = f.sequentialiseCopies(moves, nil); err != nil {
+				return nil, nil, nil, fmt.Errorf("unable to halt %s: %v", block, err)
+			}
+		}
+
+		for i, successor := range block.Successors {
+			index := edgeIndex(block, i)
+
+			moves := make(map[int]int)
+			for _, phi := range successor.Phis {
+				moves[phi.Register] = phi.Args[index].Register
+			}
+
+			instructions, err := f.sequentialiseCopies(moves, liveIn[successor])
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("unable to move from %s to %s: %v", block, successor, err)
+			}
+
+			if len(instructions) == 0 {
+				continue
+			} else if len(block.Successors) == 1 {
+				copies[block] = instructions
+				continue
+			}
+
+			edge := &SSABlock{ID: len(f.Blocks) + len(edges), IP: -1, Terminator: SSAJump}
+			edges = append(edges, edge)
+			successors[edge] = []*SSABlock{successor}
+			successors[block][i] = edge
+			copies[edge] = instructions
+		}
+	}
+
+	// Keep a halting block last, so it doesn't need a jump out of the program
+	blocks = append([]*SSABlock{}, f.Blocks...)
+	if last := blocks[len(blocks)-1]; last.Terminator == SSAHalt {
+		blocks = append(append(blocks[:len(blocks)-1], edges...), last)
+	} else {
+		blocks = append(blocks, edges...)
+	}
+
+	return blocks, successors, copies, nil
+}
+
+// Which of the predecessors of the successor the edge from the block to its `i`th successor is, as a branch
+// can go to the same block either way
+func edgeIndex(block *SSABlock, i int) int {
+	successor := block.Successors[i]
+	occurrence := 0
+	for _, previous := range block.Successors[:i] {
+		if previous == successor {
+			occurrence++
+		}
+	}
+
+	for index, previous := range successor.Predecessors {
+		if previous == block {
+			if occurrence == 0 {
+				return index
+			}
+			occurrence--
+		}
+	}
+
+	return -1
+}
+
+// Orders copies which all happen at once, destination register => source register, into `setr` instructions.
+// Copies going round in a cycle need a spare register, which can't hold any of the `live` values
+func (f *SSAFunction) sequentialiseCopies(moves map[int]int, live map[*SSAValue]bool) (instructions []Instruction, err error) {
+	// Register => the register to copy into it, -1 for none
+	pending := make([]int, f.NumRegisters)
+	isSource := func(register int) bool {
+		for _, from := range pending {
+			if from == register {
+				return true
+			}
+		}
+		return false
+	}
+
+	for register := range pending {
+		pending[register] = -1
+		if from, found := moves[register]; found && from != register {
+			pending[register] = from
+		}
+	}
+
+	for remaining := len(pending); remaining > 0; {
+		remaining = 0
+		progress := false
+
+		for to, from := range pending {
+			if from < 0 {
+				continue
+			}
+
+			if !isSource(to) {
+				instructions = append(instructions, Instruction{SetR, from, 0, to})
+				pending[to] = -1
+				progress = true
+			} else {
+				remaining++
+			}
+		}
+
+		if progress || remaining == 0 {
+			continue
+		}
+
+		// Everything left goes round in cycles, so move one of them out of the way
+		spare := f.spareRegister(moves, live)
+		if spare < 0 {
+			return nil, errors.New("no spare register to break a cycle of copies")
+		}
+
+		for to, from := range pending {
+			if from >= 0 {
+				instructions = append(instructions, Instruction{SetR, to, 0, spare})
+				for other, source := range pending {
+					if source == to {
+						pending[other] = spare
+					}
+				}
+				break
+			}
+		}
+	}
+
+	return instructions, nil
+}
+
+// A register which isn't the instruction pointer, part of the copies or holding a live value, or -1 if there isn't one
+func (f *SSAFunction) spareRegister(moves map[int]int, live map[*SSAValue]bool) int {
+	used := make([]bool, f.NumRegisters)
+	used[f.IPRegister] = true
+
+	for to, from := range moves {
+		used[to], used[from] = true, true
+	}
+	for value := range live {
+		used[value.Register] = true
+	}
+
+	for register, isUsed := range used {
+		if !isUsed {
+			return register
+		}
+	}
+
+	return -1
+}
+
+// Lowers an instruction back down, rewriting the op code if a register input has become a constant
+func (f *SSAFunction) lowerInstruction(value *SSAValue) (instruction Instruction, err error) {
+	instruction = Instruction{value.OpCode, 0, 0, value.Register}
+	a, b := value.Args[0], value.Args[1]
+	isImmediate := OpCodeInputType[value.OpCode]
+
+	if a.Kind == SSAConst && b.Kind == SSAConst && !value.OpCode.talksToHost() {
+		if !isImmediate.A || !isImmediate.B {
+			result, err := evaluateConstants(value)
+			if err != nil {
+				return instruction, fmt.Errorf("unable to evaluate %s: %v", value.Definition(), err)
+			}
+
+			return Instruction{SetI, f.Word.Wrap(result), 0, value.Register}, nil
+		}
+	}
+
+	if !isImmediate.A && a.Kind == SSAConst {
+		if swapped, found := constantAVersion[instruction.OpCode]; found {
+			instruction.OpCode = swapped
+			isImmediate = OpCodeInputType[swapped]
+		} else if isCommutative(instruction.OpCode) {
+			instruction.OpCode = OpCodeImmedateVersion[instruction.OpCode]
+			isImmediate = OpCodeInputType[instruction.OpCode]
+			a, b = b, a
+		}
+	}
+
+	if !isImmediate.B && b.Kind == SSAConst {
+		if immediate, found := OpCodeImmedateVersion[instruction.OpCode]; found {
+			instruction.OpCode = immediate
+			isImmediate = OpCodeInputType[immediate]
+		}
+	}
+
+	if instruction.A, err = lowerOperand(value, a, isImmediate.A); err != nil {
+		return
+	}
+	instruction.B, err = lowerOperand(value, b, isImmediate.B)
+	return
+}
+
+// The op codes to use when register input A of the op code is a constant
+var constantAVersion = map[OpCode]OpCode{
+	SetR: SetI,
+	GtRR: GtIR,
+	EqRR: EqIR,
+}
+
+func isCommutative(opCode OpCode) bool {
+	return opCode == AddR || opCode == MulR || opCode == BanR || opCode == BorR
+}
+
+func lowerOperand(value *SSAValue, operand *SSAValue, isImmediate bool) (int, error) {
+	switch {
+	case isImmediate && operand.Kind != SSAConst:
+		return 0, fmt.Errorf("%s has %s as an immediate input", value.Definition(), operand)
+	case isImmediate:
+		return operand.Const, nil
+	case operand.Kind == SSAConst:
+		return 0, fmt.Errorf("unable to lower %s with a constant register input", value.Definition())
+	default:
+		return operand.Register, nil
+	}
+}
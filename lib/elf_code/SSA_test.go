@@ -0,0 +1,313 @@
+package elf_code
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Counts R[1] up to 10, then squares it into R[0]
+const ssaTestProgram = `#ip 5
+seti 0 0 1
+addi 1 1 1
+gtri 1 9 2
+addr 2 5 5
+seti 0 0 5
+mulr 1 1 0`
+
+func TestCPU_SSA(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(ssaTestProgram)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	f, err := cpu.SSA()
+	if err != nil {
+		t.Fatalf("CPU.SSA() error = %v", err)
+	}
+
+	want := `b0 (entry):
+  v0 = param ; r0
+  v1 = param ; r1
+  v2 = param ; r2
+  v3 = param ; r3
+  v4 = param ; r4
+  jump b1
+b1 (ip 0) from b0:
+  v10 = seti 0 0 ; r1
+  jump b2
+b2 (ip 1) from b1, b3:
+  v14 = phi v10 v18 ; r1
+  v15 = phi v2 v20 ; r2
+  v18 = addi v14 1 ; r1
+  v20 = gtri v18 9 ; r2 bool
+  branch v20 b4 b3
+b3 (ip 4) from b2:
+  jump b2
+b4 (ip 5) from b2:
+  v32 = mulr v18 v18 ; r0
+  jump b5
+b5 (exit) from b4:
+  halt v32 v18 v20 v3 v4 _
+`
+	if got := f.String(); got != want {
+		t.Errorf("SSAFunction.String() =\n%s\nwant\n%s", got, want)
+	}
+
+	program, err := f.Lower()
+	if err != nil {
+		t.Fatalf("SSAFunction.Lower() error = %v", err)
+	}
+	if !reflect.DeepEqual(program, cpu.Program) {
+		t.Errorf("SSAFunction.Lower() = %v, want %v", program, cpu.Program)
+	}
+}
+
+func TestSSAFunction_Types(t *testing.T) {
+	// R[3] is a comparison on one path and a copy of the boolean input R[0] on the other
+	f, err := Program{
+		{EqRI, 1, 4, 2}, {AddR, 2, 5, 5}, {SetI, 3, 0, 5}, {SetI, 5, 0, 5},
+		{SetR, 0, 0, 3}, {SetI, 6, 0, 5},
+		{GtRI, 1, 2, 3},
+		{AddI, 3, 1, 4},
+	}.SSA(5, 6, 0)
+	if err != nil {
+		t.Fatalf("Program.SSA() error = %v", err)
+	}
+
+	exit := f.Blocks[len(f.Blocks)-1]
+	for register, want := range []DataType{BoolType, IntType, BoolType, BoolType, IntType} {
+		if got := exit.Exit[register].Type; got != want {
+			t.Errorf("SSAFunction exit r%d = %s, want type %v", register, exit.Exit[register].Definition(), want)
+		}
+	}
+}
+
+func TestSSAFunction_Lower_RoundTrip(t *testing.T) {
+	day21, err := NewCPUFromProgramFile(day21Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	// Day 21 only halts when R[0] matches the first number it generates
+	debugger := NewDebugger(day21)
+	debugger.SetBreakpoint(28)
+	if reason, err := debugger.Continue(); err != nil || reason != BreakpointHit {
+		t.Fatalf("Debugger.Continue() = %v, %v, want %v", reason, err, BreakpointHit)
+	}
+	day21Halt := day21.Registers[4]
+
+	tests := []struct {
+		name          string
+		program       string
+		booleanInputs []int
+		inputs        []Registers
+	}{
+		{"Data flow", dataFlowTestProgram, nil, []Registers{{0, 0, 0, 0, 0, 0}, {7, 0, 0, 0, 0, 0}}},
+		{"Loop", loopTestProgram, nil, randomInputs(24, 5, 6, 3, -1)},
+		{"Day 19", smallDay19Program, []int{0}, []Registers{{0, 0, 0, 0, 0, 0}, {1, 0, 0, 0, 0, 0}}},
+		{"Day 21", day21Program, nil, []Registers{{day21Halt, 0, 0, 0, 0, 0}}},
+		{"Word size", wordTestProgram, nil, []Registers{NewRegisters(8)}},
+	}
+	for _, tt := range tests {
+		for _, optimise := range []bool{false, true} {
+			name := tt.name
+			if optimise {
+				name += " optimised"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				cpu, err := NewCPUFromProgramFile(tt.program)
+				if err != nil {
+					t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+				}
+
+				f, err := cpu.SSA(tt.booleanInputs...)
+				if err != nil {
+					t.Fatalf("CPU.SSA() error = %v", err)
+				}
+
+				if optimise {
+					f.FoldConstants()
+					f.PropagateCopies()
+					f.RemoveDeadValues()
+				}
+
+				program, err := f.Lower()
+				if err != nil {
+					t.Fatalf("SSAFunction.Lower() error = %v\n%s", err, f)
+				}
+
+				for _, input := range tt.inputs {
+					original, _ := NewCPUFromProgramFile(tt.program)
+					lowered, err := NewCPUWithConfig(program, original.InstructionPointerRegister, original.Config())
+					if err != nil {
+						t.Fatalf("NewCPUWithConfig() error = %v", err)
+					}
+
+					copy(original.Registers, input)
+					copy(lowered.Registers, input)
+					if err := original.Execute(); err != nil {
+						t.Fatalf("CPU.Execute() error = %v", err)
+					}
+					if err := lowered.Execute(); err != nil {
+						t.Fatalf("CPU.Execute() lowered error = %v", err)
+					}
+
+					// The instruction pointer can halt anywhere past the end of the program
+					original.Registers[original.InstructionPointerRegister] = 0
+					lowered.Registers[lowered.InstructionPointerRegister] = 0
+					if !reflect.DeepEqual(original.Registers, lowered.Registers) {
+						t.Errorf("CPU.Execute() from %v lowered = %v, want %v\n%s", input, lowered.Registers, original.Registers, program)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSSAFunction_Optimise(t *testing.T) {
+	f, err := Program{{SetI, 3, 0, 1}, {AddI, 1, 4, 2}, {MulR, 2, 1, 1}, {SetI, 0, 0, 2}, {AddR, 1, 0, 0}}.SSA(5, 6)
+	if err != nil {
+		t.Fatalf("Program.SSA() error = %v", err)
+	}
+
+	if changes := f.FoldConstants(); changes == 0 {
+		t.Errorf("SSAFunction.FoldConstants() = 0, want changes")
+	}
+	if changes := f.RemoveDeadValues(); changes != 2 {
+		t.Errorf("SSAFunction.RemoveDeadValues() = %d, want 2\n%s", changes, f)
+	}
+
+	program, err := f.Lower()
+	if err != nil {
+		t.Fatalf("SSAFunction.Lower() error = %v", err)
+	}
+
+	if want := (Program{{SetI, 21, 0, 1}, {SetI, 0, 0, 2}, {AddI, 0, 21, 0}}); !reflect.DeepEqual(program, want) {
+		t.Errorf("SSAFunction.Lower() = %v, want %v", program, want)
+	}
+}
+
+func TestSSAFunction_Errors(t *testing.T) {
+	if _, err := (Program{{AddR, 0, 5, 5}}).SSA(5, 6); err == nil || err.Error() != "unable to lift computed jump at 0" {
+		t.Errorf("Program.SSA() error = %v, want unable to lift computed jump at 0", err)
+	}
+
+	f, err := (Program{{SetI, 1, 0, 1}, {GtRI, 0, 2, 2}, {AddR, 2, 5, 5}, {SetI, 2, 0, 1}}).SSA(5, 6)
+	if err != nil {
+		t.Fatalf("Program.SSA() error = %v", err)
+	}
+
+	// Using a value after its register has been overwritten can't be lowered
+	f.Blocks[1].Values[1].Args[0] = f.Params[1]
+	if _, err := f.Lower(); err == nil || !strings.Contains(err.Error(), "in r1 overwrites v1, which is still needed") {
+		t.Errorf("SSAFunction.Lower() error = %v, want overwritten value error", err)
+	}
+}
+
+// Loops with R[2] holding the value R[1] had at the end of the previous iteration
+const copyTestProgram = `#ip 5
+seti 0 0 1
+seti 0 0 2
+addi 1 1 1
+gtrr 2 0 3
+setr 1 0 2
+addr 3 5 5
+seti 1 0 5`
+
+func TestSSAFunction_PropagateCopies(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(copyTestProgram)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	f, err := cpu.SSA()
+	if err != nil {
+		t.Fatalf("CPU.SSA() error = %v", err)
+	}
+
+	if changes := f.PropagateCopies(); changes != 1 {
+		t.Errorf("SSAFunction.PropagateCopies() = %d, want 1\n%s", changes, f)
+	}
+	f.RemoveDeadValues()
+
+	// The phi for R[2] in the loop, and R[2] when halting, now take the value from R[1]
+	program, err := f.Lower()
+	if err != nil {
+		t.Fatalf("SSAFunction.Lower() error = %v\n%s", err, f)
+	}
+
+	want := Program{
+		{SetI, 0, 0, 1}, {SetI, 0, 0, 2}, {AddI, 1, 1, 1}, {GtRR, 2, 0, 3}, {AddR, 3, 5, 5}, {SetI, 6, 0, 5}, {SetI, 8, 0, 5},
+		{SetR, 1, 0, 2}, {SetI, 1, 0, 5},
+		{SetR, 1, 0, 2},
+	}
+	if !reflect.DeepEqual(program, want) {
+		t.Errorf("SSAFunction.Lower() = %v, want %v", program, want)
+	}
+
+	for _, input := range randomInputs(24, 5, 6, 5, -1) {
+		original, _ := NewCPUFromProgramFile(copyTestProgram)
+		lowered := NewCPU(program, 5, 6)
+
+		copy(original.Registers, input)
+		copy(lowered.Registers, input)
+		if err := original.Execute(); err != nil {
+			t.Fatalf("CPU.Execute() error = %v", err)
+		}
+		if err := lowered.Execute(); err != nil {
+			t.Fatalf("CPU.Execute() lowered error = %v", err)
+		}
+
+		original.Registers[5], lowered.Registers[5] = 0, 0
+		if !reflect.DeepEqual(original.Registers, lowered.Registers) {
+			t.Errorf("CPU.Execute() from %v lowered = %v, want %v", input, lowered.Registers, original.Registers)
+		}
+	}
+
+	// A copy of a value which is then overwritten is left alone
+	f, err = (Program{{SetR, 1, 0, 2}, {AddI, 1, 1, 1}, {AddR, 2, 1, 3}}).SSA(5, 6)
+	if err != nil {
+		t.Fatalf("Program.SSA() error = %v", err)
+	}
+
+	if changes := f.PropagateCopies(); changes != 0 {
+		t.Errorf("SSAFunction.PropagateCopies() = %d, want 0\n%s", changes, f)
+	}
+}
+
+func TestSSAFunction_Lower_Copies(t *testing.T) {
+	f, err := (Program{{SetI, 1, 0, 1}, {SetI, 2, 0, 2}}).SSA(5, 6)
+	if err != nil {
+		t.Fatalf("Program.SSA() error = %v", err)
+	}
+
+	// Copies going round in a cycle use a register which isn't part of them, or holding a value still needed
+	tests := []struct {
+		name  string
+		moves map[int]int
+		live  map[*SSAValue]bool
+		want  Program
+	}{
+		{"Chain", map[int]int{1: 2, 2: 3, 4: 4}, nil, Program{{SetR, 2, 0, 1}, {SetR, 3, 0, 2}}},
+		{"Swap", map[int]int{1: 2, 2: 1}, nil, Program{{SetR, 1, 0, 0}, {SetR, 2, 0, 1}, {SetR, 0, 0, 2}}},
+		{"Swap with live values", map[int]int{1: 2, 2: 1}, map[*SSAValue]bool{f.Params[0]: true}, Program{{SetR, 1, 0, 3}, {SetR, 2, 0, 1}, {SetR, 3, 0, 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := f.sequentialiseCopies(tt.moves, tt.live)
+			if err != nil || !reflect.DeepEqual(Program(got), tt.want) {
+				t.Errorf("SSAFunction.sequentialiseCopies() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	// Every register is needed when halting, so swapping two of them can't be done
+	exit := f.Blocks[len(f.Blocks)-1]
+	exit.Exit[1], exit.Exit[2] = exit.Exit[2], exit.Exit[1]
+
+	if _, err := f.Lower(); err == nil || err.Error() != "unable to halt b2: no spare register to break a cycle of copies" {
+		t.Errorf("SSAFunction.Lower() error = %v, want no spare register", err)
+	}
+}
@@ -0,0 +1,525 @@
+package elf_code
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A symbolic value held by a register
+type Expression interface {
+	String() string
+}
+
+// A value known before the program runs
+type Constant int
+
+func (c Constant) String() string {
+	return strconv.Itoa(int(c))
+}
+
+// The unknown value a register held when the program started
+type Input int
+
+func (i Input) String() string {
+	return "r" + strconv.Itoa(int(i))
+}
+
+// An operation on two expressions. The op code is always the register/register version (`AddR`, `MulR`, `BanR`,
+// `BorR`, `GtRR` or `EqRR`) or a registered op code, with immediate values held as constants
+type Operation struct {
+	OpCode OpCode
+	A, B   Expression
+}
+
+func (o Operation) String() string {
+	operand := func(e Expression) string {
+		if _, isOperation := e.(Operation); isOperation {
+			return "(" + e.String() + ")"
+		}
+		return e.String()
+	}
+
+	if registered, found := registeredOpCodes[o.OpCode]; found {
+		if registered.Operator == "" {
+			// Calls don't need brackets around their arguments
+			return registered.expression(o.A.String(), o.B.String())
+		}
+		return registered.expression(operand(o.A), operand(o.B))
+	}
+
+	return operand(o.A) + " " + symbolicOperators[o.OpCode] + " " + operand(o.B)
+}
+
+var symbolicOperators = map[OpCode]string{AddR: "+", MulR: "*", BanR: "&", BorR: "|", GtRR: ">", EqRR: "=="}
+
+// A comparison (or boolean input) a path depends on, and the value it had on that path
+type PathCondition struct {
+	Expression Expression
+	Value      int // 1 if the comparison was true, otherwise 0
+}
+
+func (c PathCondition) String() string {
+	if c.Value == 0 {
+		return "!(" + c.Expression.String() + ")"
+	}
+	return c.Expression.String()
+}
+
+// How a path through the program ended
+type PathStatus int
+
+const (
+	PathHalted        PathStatus = iota // The instruction pointer left the program
+	PathReachedTarget                   // The instruction pointer reached one of `SymbolicOptions.StopAt`
+	PathStepLimit                       // The path ran for `SymbolicOptions.MaxSteps` instructions
+	PathUnknownJump                     // The instruction pointer was set to a value which depends on a non boolean input
+	PathHostCall                        // The path reached an op code which talks to the host, such as I/O
+)
+
+func (s PathStatus) String() string {
+	switch s {
+	case PathHalted:
+		return "halted"
+	case PathReachedTarget:
+		return "reached target"
+	case PathStepLimit:
+		return "step limit"
+	case PathUnknownJump:
+		return "unknown jump"
+	case PathHostCall:
+		return "host call"
+	default:
+		return "unknown"
+	}
+}
+
+// A path through the program and the registers at the end of it
+type SymbolicPath struct {
+	Status     PathStatus
+	IP         int             // The instruction pointer the path ended on
+	Steps      int             // The number of instructions executed along the path
+	Registers  []Expression    // The value of each register at the end of the path
+	Conditions []PathCondition // The conditions inputs have to meet for the program to take this path
+	Inputs     map[int]int     // The inputs which the conditions fix to a single value
+}
+
+// The default maximum number of instructions along a path
+const DefaultSymbolicMaxSteps = 1000000
+
+// The default maximum number of paths returned
+const DefaultSymbolicMaxPaths = 64
+
+// Options for running a program symbolically
+type SymbolicOptions struct {
+	Inputs        []int // Registers which are unknown when the program starts, the rest keep the CPU's values
+	BooleanInputs []int // Unknown registers which hold 0 or 1, see `Program.JumpAt`
+	StopAt        []int // Instruction pointers to stop paths at before they are executed
+	MaxSteps      int   // The maximum number of instructions along a path (DefaultSymbolicMaxSteps if zero)
+	MaxPaths      int   // The maximum number of paths to return (DefaultSymbolicMaxPaths if zero)
+}
+
+// A path which is still being explored
+type symbolicState struct {
+	registers  []Expression
+	ip         int
+	steps      int
+	conditions []PathCondition
+	inputs     map[int]int
+
+	order    int           // The order states were created in, to keep the results the same between runs
+	finished *SymbolicPath // Set once the path has ended
+}
+
+// States ordered by the number of instructions executed
+type symbolicQueue []*symbolicState
+
+func (q symbolicQueue) Len() int { return len(q) }
+func (q symbolicQueue) Less(i, j int) bool {
+	if q[i].steps != q[j].steps {
+		return q[i].steps < q[j].steps
+	}
+	return q[i].order < q[j].order
+}
+func (q symbolicQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *symbolicQueue) Push(x interface{}) { *q = append(*q, x.(*symbolicState)) }
+func (q *symbolicQueue) Pop() interface{} {
+	old := *q
+	state := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return state
+}
+
+type symbolicExecutor struct {
+	program       Program
+	ipRegister    int
+	booleanInputs map[int]bool
+	stopAt        map[int]bool
+	maxSteps      int
+	queue         symbolicQueue
+	created       int
+}
+
+// Runs the program loaded into the CPU with the `Inputs` registers left unknown. Whenever a jump depends
+// on a comparison involving them, the path splits in two. Paths are returned in the order of the number
+// of instructions they executed, so the first halting path is the one which halts soonest.
+//
+// Paths which can't be taken, as their conditions contradict each other, are dropped. This is only
+// spotted once the conditions fix an input to a single value, such as `r0 == 5` or a boolean input
+func (cpu *CPU) ExecuteSymbolic(options SymbolicOptions) (paths []SymbolicPath, err error) {
+	if cpu.Word.Bits != 0 {
+		return nil, fmt.Errorf("unable to execute %s words symbolically", cpu.Word)
+	}
+
+	unknown := make([]bool, len(cpu.Registers))
+	for _, register := range append(append([]int(nil), options.Inputs...), options.BooleanInputs...) {
+		if register < 0 || register >= len(cpu.Registers) || register == cpu.InstructionPointerRegister {
+			return nil, fmt.Errorf("invalid input register %d", register)
+		}
+		unknown[register] = true
+	}
+
+	e := &symbolicExecutor{
+		program:       cpu.Program,
+		ipRegister:    cpu.InstructionPointerRegister,
+		booleanInputs: make(map[int]bool),
+		stopAt:        make(map[int]bool),
+		maxSteps:      options.MaxSteps,
+	}
+	if e.maxSteps <= 0 {
+		e.maxSteps = DefaultSymbolicMaxSteps
+	}
+	maxPaths := options.MaxPaths
+	if maxPaths <= 0 {
+		maxPaths = DefaultSymbolicMaxPaths
+	}
+	for _, register := range options.BooleanInputs {
+		e.booleanInputs[register] = true
+	}
+	for _, ip := range options.StopAt {
+		e.stopAt[ip] = true
+	}
+
+	start := &symbolicState{
+		registers: make([]Expression, len(cpu.Registers)),
+		ip:        cpu.Registers[cpu.InstructionPointerRegister],
+		inputs:    make(map[int]int),
+	}
+	for i, value := range cpu.Registers {
+		if unknown[i] {
+			start.registers[i] = Input(i)
+		} else {
+			start.registers[i] = Constant(value)
+		}
+	}
+	e.push(start)
+
+	for len(e.queue) > 0 && len(paths) < maxPaths {
+		state := heap.Pop(&e.queue).(*symbolicState)
+
+		if state.finished != nil {
+			paths = append(paths, *state.finished)
+		} else {
+			e.run(state)
+		}
+	}
+
+	return paths, nil
+}
+
+// Runs the program symbolically, with the unknown registers of the transpiler as inputs
+func (t *TranspileState) ExecuteSymbolic(options SymbolicOptions) (paths []SymbolicPath, err error) {
+	options.Inputs = options.Inputs[:len(options.Inputs):len(options.Inputs)]
+	options.BooleanInputs = options.BooleanInputs[:len(options.BooleanInputs):len(options.BooleanInputs)]
+
+	for i, register := range t.Registers {
+		if register.isConst || i == t.cpu.InstructionPointerRegister {
+			continue
+		}
+
+		if register.dataType == BoolType {
+			options.BooleanInputs = append(options.BooleanInputs, i)
+		} else {
+			options.Inputs = append(options.Inputs, i)
+		}
+	}
+
+	return t.cpu.ExecuteSymbolic(options)
+}
+
+func (e *symbolicExecutor) push(state *symbolicState) {
+	state.order = e.created
+	e.created++
+	heap.Push(&e.queue, state)
+}
+
+// Runs the state until it ends or splits
+func (e *symbolicExecutor) run(state *symbolicState) {
+	for {
+		switch {
+		case state.ip < 0 || state.ip >= len(e.program):
+			// Leave the instruction pointer on the last instruction, as CPU.Execute does
+			state.registers[e.ipRegister] = Constant(state.ip - 1)
+			e.finish(state, PathHalted)
+			return
+
+		case e.stopAt[state.ip]:
+			state.registers[e.ipRegister] = Constant(state.ip)
+			e.finish(state, PathReachedTarget)
+			return
+
+		case state.steps >= e.maxSteps:
+			state.registers[e.ipRegister] = Constant(state.ip)
+			e.finish(state, PathStepLimit)
+			return
+		}
+
+		instruction := e.program[state.ip]
+		state.registers[e.ipRegister] = Constant(state.ip)
+
+		// What the host does can't be known ahead of time
+		if instruction.OpCode.talksToHost() {
+			e.finish(state, PathHostCall)
+			return
+		}
+
+		state.registers[instruction.C] = e.evaluate(instruction, state.registers)
+		state.steps++
+
+		if next, isConstant := state.registers[e.ipRegister].(Constant); isConstant {
+			state.ip = int(next) + 1
+			continue
+		}
+
+		e.jump(state, state.registers[e.ipRegister])
+		return
+	}
+}
+
+// Splits the state on a boolean the jump target depends on, until the target is known
+func (e *symbolicExecutor) jump(state *symbolicState, target Expression) {
+	if next, isConstant := target.(Constant); isConstant {
+		state.registers[e.ipRegister] = next
+		state.ip = int(next) + 1
+		e.push(state)
+		return
+	}
+
+	condition := e.findBoolean(target)
+	if condition == nil {
+		e.finish(state, PathUnknownJump)
+		return
+	}
+
+	for value := 0; value <= 1; value++ {
+		child := &symbolicState{
+			registers:  append([]Expression(nil), state.registers...),
+			ip:         state.ip,
+			steps:      state.steps,
+			conditions: append(state.conditions[:len(state.conditions):len(state.conditions)], PathCondition{condition, value}),
+			inputs:     make(map[int]int, len(state.inputs)),
+		}
+		for input, inputValue := range state.inputs {
+			child.inputs[input] = inputValue
+		}
+
+		if e.fixInputs(child, condition, value) {
+			e.jump(child, replaceExpression(target, condition, Constant(value)))
+		}
+	}
+}
+
+// If the condition fixes an input to a single value, replaces it everywhere in the state.
+// Returns false if the state's conditions now contradict each other
+func (e *symbolicExecutor) fixInputs(state *symbolicState, condition Expression, value int) bool {
+	input, inputValue, fixed := Input(0), 0, false
+
+	switch c := condition.(type) {
+	case Input:
+		input, inputValue, fixed = c, value, true
+	case Operation:
+		if constant, isConstant := c.B.(Constant); isConstant && c.OpCode == EqRR && value == 1 {
+			input, fixed = c.A.(Input)
+			inputValue = int(constant)
+		}
+	}
+
+	if !fixed {
+		return true
+	}
+
+	state.inputs[int(input)] = inputValue
+	for i, register := range state.registers {
+		state.registers[i] = replaceExpression(register, input, Constant(inputValue))
+	}
+
+	for _, existing := range state.conditions {
+		expression := existing.Expression
+		for fixedInput, fixedValue := range state.inputs {
+			expression = replaceExpression(expression, Input(fixedInput), Constant(fixedValue))
+		}
+
+		if result, isConstant := expression.(Constant); isConstant && int(result) != existing.Value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (e *symbolicExecutor) finish(state *symbolicState, status PathStatus) {
+	inputs := make(map[int]int, len(state.inputs))
+	for input, value := range state.inputs {
+		inputs[input] = value
+	}
+
+	state.finished = &SymbolicPath{status, state.ip, state.steps, state.registers, state.conditions, inputs}
+	e.push(state)
+}
+
+// Works out the expression for the value the instruction writes
+func (e *symbolicExecutor) evaluate(instruction Instruction, registers []Expression) Expression {
+	isImmediate := OpCodeInputType[instruction.OpCode]
+	operand := func(value int, isImmediate bool) Expression {
+		if isImmediate {
+			return Constant(value)
+		}
+		return registers[value]
+	}
+	a := operand(instruction.A, isImmediate.A)
+
+	switch instruction.OpCode {
+	case SetR, SetI:
+		return a
+	case AddR, AddI:
+		return newOperation(AddR, a, operand(instruction.B, isImmediate.B))
+	case MulR, MulI:
+		return newOperation(MulR, a, operand(instruction.B, isImmediate.B))
+	case BanR, BanI:
+		return newOperation(BanR, a, operand(instruction.B, isImmediate.B))
+	case BorR, BorI:
+		return newOperation(BorR, a, operand(instruction.B, isImmediate.B))
+	case GtIR, GtRI, GtRR:
+		return newOperation(GtRR, a, operand(instruction.B, isImmediate.B))
+	case EqIR, EqRI, EqRR:
+		return newOperation(EqRR, a, operand(instruction.B, isImmediate.B))
+	default:
+		return newOperation(instruction.OpCode, a, operand(instruction.B, isImmediate.B))
+	}
+}
+
+// Finds the first comparison or boolean input within the expression
+func (e *symbolicExecutor) findBoolean(expression Expression) Expression {
+	switch ex := expression.(type) {
+	case Input:
+		if e.booleanInputs[int(ex)] {
+			return ex
+		}
+	case Operation:
+		if ex.OpCode == GtRR || ex.OpCode == EqRR {
+			return ex
+		}
+		if found := e.findBoolean(ex.A); found != nil {
+			return found
+		}
+		return e.findBoolean(ex.B)
+	}
+
+	return nil
+}
+
+// Creates an operation, folding constants and removing operations which don't change the value
+func newOperation(opCode OpCode, a Expression, b Expression) Expression {
+	constA, aIsConstant := a.(Constant)
+	constB, bIsConstant := b.(Constant)
+
+	// Nothing is known about registered op codes, other than their value once the inputs are known
+	if registered, found := registeredOpCodes[opCode]; found {
+		if aIsConstant && bIsConstant {
+			if value, err := registered.apply(int(constA), int(constB)); err == nil {
+				return Constant(value)
+			}
+		}
+		return Operation{opCode, a, b}
+	}
+
+	if aIsConstant && bIsConstant {
+		value, _ := OpCodeFunc[opCode](0, 1, Registers{int(constA), int(constB)})
+		return Constant(value)
+	}
+
+	// Keep constants on the right of operations where the order doesn't matter
+	if aIsConstant && opCode != GtRR {
+		a, b = b, a
+		constB, bIsConstant = constA, true
+	}
+
+	switch {
+	case a == b && opCode == EqRR:
+		return Constant(1)
+	case a == b && opCode == GtRR:
+		return Constant(0)
+	case !bIsConstant:
+		return Operation{opCode, a, b}
+	case constB == 0 && (opCode == AddR || opCode == BorR):
+		return a
+	case constB == 0 && (opCode == MulR || opCode == BanR):
+		return Constant(0)
+	case constB == 1 && opCode == MulR:
+		return a
+	}
+
+	// Combine constants in chains of the same operation, such as (r0 + 1) + 2 => r0 + 3
+	if inner, isOperation := a.(Operation); isOperation && inner.OpCode == opCode && opCode != GtRR && opCode != EqRR {
+		if innerConst, isConstant := inner.B.(Constant); isConstant {
+			return newOperation(opCode, inner.A, newOperation(opCode, innerConst, constB))
+		}
+	}
+
+	return Operation{opCode, a, b}
+}
+
+// Replaces every `find` within the expression with `value`, simplifying the result
+func replaceExpression(expression Expression, find Expression, value Expression) Expression {
+	if expression == find {
+		return value
+	}
+
+	if operation, isOperation := expression.(Operation); isOperation {
+		return newOperation(
+			operation.OpCode,
+			replaceExpression(operation.A, find, value),
+			replaceExpression(operation.B, find, value),
+		)
+	}
+
+	return expression
+}
+
+func (p SymbolicPath) String() string {
+	var str strings.Builder
+
+	str.WriteString(fmt.Sprintf("%s at %d after %d instructions", p.Status, p.IP, p.Steps))
+
+	if len(p.Conditions) > 0 {
+		conditions := make([]string, len(p.Conditions))
+		for i, condition := range p.Conditions {
+			conditions[i] = condition.String()
+		}
+		str.WriteString(" when ")
+		str.WriteString(strings.Join(conditions, " && "))
+	}
+
+	inputs := make([]int, 0, len(p.Inputs))
+	for input := range p.Inputs {
+		inputs = append(inputs, input)
+	}
+	sort.Ints(inputs)
+	for _, input := range inputs {
+		str.WriteString(fmt.Sprintf(", r%d = %d", input, p.Inputs[input]))
+	}
+
+	return str.String()
+}
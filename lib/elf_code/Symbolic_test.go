@@ -0,0 +1,204 @@
+package elf_code
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCPU_ExecuteSymbolic(t *testing.T) {
+	tests := []struct {
+		name      string
+		program   string
+		options   SymbolicOptions
+		want      []string
+		wantRegs  []string
+		wantFixed map[int]int
+	}{
+		{
+			"Straight line",
+			"#ip 5\naddi 0 3 1\nmuli 1 2 1\naddi 1 -6 4\ngtrr 1 2 3\nseti 7 0 0",
+			SymbolicOptions{Inputs: []int{0, 2}},
+			[]string{"halted at 5 after 5 instructions"},
+			[]string{"7", "(r0 + 3) * 2", "r2", "((r0 + 3) * 2) > r2", "((r0 + 3) * 2) + -6", "4"},
+			map[int]int{},
+		},
+		{
+			"Branch on comparison",
+			"#ip 5\ngtri 0 10 1\naddr 1 5 5\nseti 1 0 2\nseti 2 0 3",
+			SymbolicOptions{Inputs: []int{0}},
+			[]string{
+				"halted at 4 after 3 instructions when r0 > 10",
+				"halted at 4 after 4 instructions when !(r0 > 10)",
+			},
+			[]string{"r0", "r0 > 10", "1", "2", "0", "3"},
+			map[int]int{},
+		},
+		{
+			"Fixed by equality",
+			"#ip 5\neqri 0 4 1\naddr 1 5 5\nseti 9 0 5\naddi 0 1 2",
+			SymbolicOptions{Inputs: []int{0}},
+			[]string{
+				"halted at 10 after 3 instructions when !(r0 == 4)",
+				"halted at 4 after 3 instructions when r0 == 4, r0 = 4",
+			},
+			[]string{"4", "1", "5", "0", "0", "3"},
+			map[int]int{0: 4},
+		},
+		{
+			"Contradicting conditions are dropped",
+			"#ip 5\ngtri 0 10 1\naddr 1 5 5\nseti 9 0 5\neqri 0 4 1\naddr 1 5 5\nseti 9 0 5\nseti 1 0 2",
+			SymbolicOptions{Inputs: []int{0}},
+			[]string{
+				"halted at 10 after 3 instructions when !(r0 > 10)",
+				"halted at 10 after 5 instructions when r0 > 10 && !(r0 == 4)",
+			},
+			nil,
+			nil,
+		},
+		{
+			"Unknown jump",
+			"#ip 5\naddr 0 5 5\nseti 1 0 1",
+			SymbolicOptions{Inputs: []int{0}},
+			[]string{"unknown jump at 0 after 1 instructions"},
+			[]string{"r0", "0", "0", "0", "0", "r0"},
+			map[int]int{},
+		},
+		{
+			"Boolean input",
+			"#ip 5\naddr 0 5 5\nseti 1 0 1\nseti 2 0 2",
+			SymbolicOptions{BooleanInputs: []int{0}, StopAt: []int{2}},
+			[]string{
+				"reached target at 2 after 1 instructions when r0, r0 = 1",
+				"reached target at 2 after 2 instructions when !(r0), r0 = 0",
+			},
+			nil,
+			nil,
+		},
+		{
+			"Step limit",
+			"#ip 3\nseti 0 0 0\naddi 0 1 0\neqrr 0 1 2\naddr 2 3 3\nseti 0 0 3",
+			SymbolicOptions{Inputs: []int{1}, MaxSteps: 10},
+			[]string{
+				"halted at 5 after 4 instructions when r1 == 1, r1 = 1",
+				"halted at 5 after 8 instructions when !(r1 == 1) && r1 == 2, r1 = 2",
+				"step limit at 2 after 10 instructions when !(r1 == 1) && !(r1 == 2)",
+			},
+			nil,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			paths, err := cpu.ExecuteSymbolic(tt.options)
+			if err != nil {
+				t.Fatalf("CPU.ExecuteSymbolic() error = %v", err)
+			}
+
+			got := make([]string, len(paths))
+			for i, path := range paths {
+				got[i] = path.String()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("CPU.ExecuteSymbolic() = %q, want %q", got, tt.want)
+			}
+
+			if tt.wantRegs != nil {
+				registers := make([]string, len(paths[len(paths)-1].Registers))
+				for i, register := range paths[len(paths)-1].Registers {
+					registers[i] = register.String()
+				}
+				if !reflect.DeepEqual(registers, tt.wantRegs) {
+					t.Errorf("CPU.ExecuteSymbolic() registers = %q, want %q", registers, tt.wantRegs)
+				}
+			}
+
+			if last := paths[len(paths)-1]; tt.wantFixed != nil && !reflect.DeepEqual(last.Inputs, tt.wantFixed) {
+				t.Errorf("CPU.ExecuteSymbolic() inputs = %v, want %v", last.Inputs, tt.wantFixed)
+			}
+		})
+	}
+}
+
+func TestCPU_ExecuteSymbolic_Day21(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(day21Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	paths, err := cpu.ExecuteSymbolic(SymbolicOptions{Inputs: []int{0}, MaxPaths: 1})
+	if err != nil {
+		t.Fatalf("CPU.ExecuteSymbolic() error = %v", err)
+	}
+
+	if len(paths) != 1 || paths[0].Status != PathHalted {
+		t.Fatalf("CPU.ExecuteSymbolic() = %v, want a halting path", paths)
+	}
+
+	// Running the program with the value the path needs should halt after the same number of instructions
+	r0, fixed := paths[0].Inputs[0]
+	if !fixed {
+		t.Fatalf("CPU.ExecuteSymbolic() = %v, want r0 to be fixed", paths[0])
+	}
+
+	decoded, _ := cpu.Decode()
+	registers := Registers{r0, 0, 0, 0, 0, 0}
+	executed, err := decoded.Run(registers, paths[0].Steps+1)
+	if err != nil || executed != paths[0].Steps {
+		t.Errorf("DecodedProgram.Run() = %v, %v, want %v instructions", executed, err, paths[0].Steps)
+	}
+}
+
+func TestTranspileState_ExecuteSymbolic(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(day19Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	transpiler := cpu.StartTranspiler(TranspileOptions{})
+	transpiler.Registers[0].SetUnknownBool()
+
+	// Stop once the set up is done and the main loop starts
+	paths, err := transpiler.ExecuteSymbolic(SymbolicOptions{StopAt: []int{1}})
+	if err != nil {
+		t.Fatalf("TranspileState.ExecuteSymbolic() error = %v", err)
+	}
+
+	want := []string{"reached target at 1 after 11 instructions when !(r0), r0 = 0", "reached target at 1 after 19 instructions when r0, r0 = 1"}
+	if len(paths) != 2 || paths[0].String() != want[0] || paths[1].String() != want[1] {
+		t.Fatalf("TranspileState.ExecuteSymbolic() = %v, want %v", paths, want)
+	}
+
+	if paths[0].Registers[2] != Constant(882) || paths[1].Registers[2] != Constant(10551282) {
+		t.Errorf("TranspileState.ExecuteSymbolic() R[2] = %v and %v, want 882 and 10551282", paths[0].Registers[2], paths[1].Registers[2])
+	}
+}
+
+func Test_newOperation(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Expression
+		want string
+	}{
+		{"Fold constants", newOperation(MulR, Constant(6), Constant(7)), "42"},
+		{"Constant on the right", newOperation(AddR, Constant(6), Input(0)), "r0 + 6"},
+		{"Comparisons keep their order", newOperation(GtRR, Constant(6), Input(0)), "6 > r0"},
+		{"Add zero", newOperation(AddR, Input(0), Constant(0)), "r0"},
+		{"Multiply by zero", newOperation(MulR, Input(0), Constant(0)), "0"},
+		{"Multiply by one", newOperation(MulR, Input(0), Constant(1)), "r0"},
+		{"Chains", newOperation(AddR, newOperation(AddR, Input(0), Constant(1)), Constant(2)), "r0 + 3"},
+		{"Equal to itself", newOperation(EqRR, Input(2), Input(2)), "1"},
+		{"Replace", replaceExpression(newOperation(BanR, newOperation(AddR, Input(0), Input(1)), Constant(255)), Input(1), Constant(1)), "(r0 + 1) & 255"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got.String() != tt.want {
+				t.Errorf("newOperation() = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
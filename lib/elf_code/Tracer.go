@@ -0,0 +1,185 @@
+package elf_code
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Receives every instruction executed by a CPU.
+//
+// `before` is the register state before the instruction executed and `after` is the state after it,
+// including the instruction pointer having moved on. Both are only valid for the duration of the call,
+// so must be copied if they are kept.
+type Tracer interface {
+	Trace(ip int, instruction Instruction, before Registers, after Registers)
+}
+
+// A single executed instruction
+type TraceEntry struct {
+	IP          int         // The instruction pointer of the instruction
+	Instruction Instruction // The instruction executed
+	Before      Registers   // The registers before execution
+	After       Registers   // The registers after execution
+}
+
+func (e TraceEntry) String() string {
+	return fmt.Sprintf("%d: %s %s -> %s", e.IP, e.Instruction, e.Before, e.After)
+}
+
+// Records every instruction executed by the CPU
+type ExecutionTrace struct {
+	Limit int // If greater than zero, only the last `Limit` instructions are kept
+
+	entries []TraceEntry // The recorded instructions, once at the limit this wraps around at `head`
+	head    int          // The index of the oldest entry, once at the limit
+}
+
+func (t *ExecutionTrace) Trace(ip int, instruction Instruction, before Registers, after Registers) {
+	entry := TraceEntry{ip, instruction, before.Copy(), after.Copy()}
+
+	// Once at the limit, overwrite the oldest entry rather than moving all of them along
+	if t.Limit > 0 && len(t.entries) >= t.Limit {
+		t.entries[t.head] = entry
+		t.head = (t.head + 1) % len(t.entries)
+		return
+	}
+
+	t.entries = append(t.entries, entry)
+}
+
+// The recorded instructions, oldest first
+func (t *ExecutionTrace) Entries() []TraceEntry {
+	entries := make([]TraceEntry, 0, len(t.entries))
+	entries = append(entries, t.entries[t.head:]...)
+	return append(entries, t.entries[:t.head]...)
+}
+
+func (t *ExecutionTrace) String() string {
+	var str strings.Builder
+
+	for _, entry := range t.Entries() {
+		str.WriteString(entry.String())
+		str.WriteRune('\n')
+	}
+
+	return str.String()
+}
+
+// A loop found while profiling, where execution jumped backwards from `End` to `Start`
+type HotLoop struct {
+	Start        int // The first instruction of the loop
+	End          int // The instruction which jumps back to the start
+	Iterations   int // How many times the jump back was taken
+	Instructions int // The number of instructions executed within the loop range
+}
+
+func (l HotLoop) String() string {
+	return fmt.Sprintf("%d-%d: %d iterations, %d instructions", l.Start, l.End, l.Iterations, l.Instructions)
+}
+
+// Counts how many times each instruction is executed and which backwards jumps are taken
+type Profiler struct {
+	HitCounts []int // The number of times each instruction pointer was executed
+
+	ipRegister int
+	backEdges  map[[2]int]int // Jump from => to, to the number of times taken
+}
+
+// Creates a profiler for the program loaded into the CPU. It still needs to be
+// set as the CPU's Tracer
+func NewProfiler(cpu *CPU) *Profiler {
+	return &Profiler{
+		make([]int, len(cpu.Program)),
+		cpu.InstructionPointerRegister,
+		make(map[[2]int]int),
+	}
+}
+
+func (p *Profiler) Trace(ip int, instruction Instruction, before Registers, after Registers) {
+	p.HitCounts[ip]++
+
+	// Jumping back to or before ourselves is a loop
+	next := after[p.ipRegister]
+	if next <= ip && next >= 0 {
+		p.backEdges[[2]int{ip, next}]++
+	}
+}
+
+// The total number of instructions executed
+func (p *Profiler) TotalInstructions() (total int) {
+	for _, hits := range p.HitCounts {
+		total += hits
+	}
+
+	return
+}
+
+// The instruction pointers which were executed most, most executed first
+func (p *Profiler) HottestInstructions(count int) []int {
+	ips := make([]int, 0, len(p.HitCounts))
+	for ip, hits := range p.HitCounts {
+		if hits > 0 {
+			ips = append(ips, ip)
+		}
+	}
+
+	sort.SliceStable(ips, func(i, j int) bool {
+		return p.HitCounts[ips[i]] > p.HitCounts[ips[j]]
+	})
+
+	if count < len(ips) {
+		ips = ips[:count]
+	}
+
+	return ips
+}
+
+// All the loops found, with the loop which executed the most instructions first
+func (p *Profiler) HotLoops() []HotLoop {
+	loops := make([]HotLoop, 0, len(p.backEdges))
+
+	for edge, iterations := range p.backEdges {
+		loop := HotLoop{edge[1], edge[0], iterations, 0}
+		for ip := loop.Start; ip <= loop.End; ip++ {
+			loop.Instructions += p.HitCounts[ip]
+		}
+
+		loops = append(loops, loop)
+	}
+
+	sort.Slice(loops, func(i, j int) bool {
+		if loops[i].Instructions != loops[j].Instructions {
+			return loops[i].Instructions > loops[j].Instructions
+		}
+
+		return loops[i].Start < loops[j].Start
+	})
+
+	return loops
+}
+
+// Writes out the profile against the given program
+func (p *Profiler) Summary(program Program) string {
+	var str strings.Builder
+	total := p.TotalInstructions()
+
+	str.WriteString(fmt.Sprintf("Instructions executed: %d\n", total))
+	for ip, hits := range p.HitCounts {
+		percent := 0.0
+		if total > 0 {
+			percent = float64(hits) * 100 / float64(total)
+		}
+
+		str.WriteString(fmt.Sprintf("%4d: %-16s %12d %6.2f%%\n", ip, program[ip], hits, percent))
+	}
+
+	str.WriteString("Hot loops:\n")
+	for _, loop := range p.HotLoops() {
+		str.WriteString("  ")
+		str.WriteString(loop.String())
+		str.WriteRune('\n')
+	}
+
+	return str.String()
+}
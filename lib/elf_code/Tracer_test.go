@@ -0,0 +1,67 @@
+package elf_code
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Counts R[0] up to 5, looping from IP 5 back to IP 2
+const loopTestProgram = `#ip 3
+seti 0 0 0
+seti 5 0 1
+addi 0 1 0
+eqrr 0 1 2
+addr 2 3 3
+seti 1 0 3`
+
+func TestProfiler(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(loopTestProgram)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	profiler := NewProfiler(cpu)
+	cpu.Tracer = profiler
+	if err := cpu.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	wantHits := []int{1, 1, 5, 5, 5, 4}
+	if !reflect.DeepEqual(profiler.HitCounts, wantHits) {
+		t.Errorf("Profiler.HitCounts = %v, want %v", profiler.HitCounts, wantHits)
+	}
+
+	if got := profiler.TotalInstructions(); got != 21 {
+		t.Errorf("Profiler.TotalInstructions() = %v, want %v", got, 21)
+	}
+
+	if got := profiler.HottestInstructions(2); !reflect.DeepEqual(got, []int{2, 3}) {
+		t.Errorf("Profiler.HottestInstructions() = %v, want %v", got, []int{2, 3})
+	}
+
+	wantLoops := []HotLoop{{2, 5, 4, 19}}
+	if got := profiler.HotLoops(); !reflect.DeepEqual(got, wantLoops) {
+		t.Errorf("Profiler.HotLoops() = %v, want %v", got, wantLoops)
+	}
+}
+
+func TestExecutionTrace(t *testing.T) {
+	cpu, err := NewCPUFromProgramFile(loopTestProgram)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	trace := &ExecutionTrace{Limit: 2}
+	cpu.Tracer = trace
+	if err := cpu.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	want := []TraceEntry{
+		{3, Instruction{EqRR, 0, 1, 2}, Registers{5, 5, 0, 3, 0, 0}, Registers{5, 5, 1, 4, 0, 0}},
+		{4, Instruction{AddR, 2, 3, 3}, Registers{5, 5, 1, 4, 0, 0}, Registers{5, 5, 1, 6, 0, 0}},
+	}
+	if got := trace.Entries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ExecutionTrace.Entries() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,41 @@
+package elf_code
+
+import (
+	"strings"
+)
+
+// A language the transpiler can write a program out in. The transpiler walks the program blocks
+// and calls the backend for each part of the program, with `indent` already built for the line
+type TranspileBackend interface {
+	Indent() string // The string used for a single level of indentation
+
+	// Starts and ends the program, `functions` are the function blocks which will be written with the main block first
+	WriteProgramStart(t *TranspileState, functions []*ProgramBlock, str *strings.Builder)
+	WriteProgramEnd(t *TranspileState, functions []*ProgramBlock, str *strings.Builder)
+
+	WriteFunctionStart(t *TranspileState, b *ProgramBlock, isMain bool, str *strings.Builder)
+	WriteFunctionEnd(t *TranspileState, b *ProgramBlock, isMain bool, str *strings.Builder)
+
+	// Writes a single statement line
+	WriteStatement(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder)
+
+	// Writes a call to a function block, `line` is nil when the call is the body of a branch
+	WriteCall(t *TranspileState, line *ProgramLine, target *ProgramBlock, indent string, str *strings.Builder)
+
+	// Writes an if statement, which is true when `register` is equal to `value`
+	WriteIfStart(t *TranspileState, line *ProgramLine, register int, value int, indent string, str *strings.Builder)
+	WriteElse(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder)
+	WriteIfEnd(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder)
+
+	// Writes a loop which runs while `register` is equal to `value`, checking after each iteration
+	WriteDoWhileStart(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder)
+	WriteDoWhileEnd(t *TranspileState, line *ProgramLine, register int, value int, indent string, str *strings.Builder)
+
+	// Writes the register assignments of an idiom statement, see `IdiomAssignment` for the expressions used
+	WriteIdiom(t *TranspileState, line *ProgramLine, indent string, str *strings.Builder)
+
+	WriteComment(t *TranspileState, comment string, indent string, str *strings.Builder)
+
+	// Post processes the complete output
+	Finish(source string) (string, error)
+}
@@ -0,0 +1,153 @@
+package elf_code
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Loops by jumping back to the first instruction, so the main block is called as well as being run first
+const jumpToStartTestProgram = "#ip 5\naddi 0 1 0\ngtri 0 10 1\naddr 1 5 5\nseti -1 0 5\nseti 99 0 5"
+
+// Transpiles the day 19 program with R[0] as an unknown input using the given backend
+func transpileDay19(t *testing.T, backend TranspileBackend) string {
+	cpu, err := NewCPUFromProgramFile(day19Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	options := allTranspileOptions()
+	options.Backend = backend
+	transpiler := cpu.StartTranspiler(options)
+	transpiler.Registers[0].SetUnknownBool()
+
+	source, err := transpiler.Run()
+	if err != nil {
+		t.Fatalf("TranspileState.Run() error = %v", err)
+	}
+
+	return source
+}
+
+func TestTranspileBackends(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend TranspileBackend
+		want    []string
+	}{
+		{"Default", nil, []string{
+			"var R = [0, 0, 0, 0, 0, 0]",
+			"function main() {",
+			"R[4] = R[4] == R[2] // IP: 4 (eqrr 4 2 4)",
+			"} while (R[4] == 0); // IP: 3 (mulr 1 5 4)",
+		}},
+		{"JavaScript", JavaScriptBackend{}, []string{
+			"if (R[0] == 1) { // IP: 25 (addr 3 0 3)",
+		}},
+		{"Go", GoBackend{}, []string{
+			"package elfcode",
+			"func Run(registers []int) []int {",
+			"R[4] = boolToInt(R[4] == R[2]) // IP: 4 (eqrr 4 2 4)",
+			"if R[4] != 0 {",
+		}},
+		{"C", CBackend{}, []string{
+			"static long long R[NUM_REGISTERS];",
+			"static void block0(void) {",
+			"R[0] += R[1]; // IP: 7 (addr 1 0 0)",
+			"} while (R[4] == 0); // IP: 2 (seti 1 7 5)",
+		}},
+		{"Pseudo code", PseudoCodeBackend{}, []string{
+			"registers R[0..5]",
+			"function main",
+			"R[4] = (R[4] == R[2]) ? 1 : 0 // IP: 4 (eqrr 4 2 4)",
+			"if R[0] == 1 then // IP: 25 (addr 3 0 3)",
+			"while R[4] == 0 // IP: 2 (seti 1 7 5)",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := transpileDay19(t, tt.backend)
+
+			for _, want := range tt.want {
+				if !strings.Contains(source, want) {
+					t.Errorf("TranspileState.Run() = %s\nwant it to contain %q", source, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTranspileBackends_JumpToStart(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend TranspileBackend
+		want    []string
+	}{
+		{"JavaScript", JavaScriptBackend{}, []string{"function main() {\n    block0()\n}", "function block0() {", "R[0]++"}},
+		{"Go", GoBackend{}, []string{"\tblock0(R)\n", "func block0(R []int) {", "R[0]++"}},
+		{"C", CBackend{}, []string{"    block0();\n", "static void block0(void) {", "R[0]++;"}},
+		{"Pseudo code", PseudoCodeBackend{}, []string{"function main\n    call block0\nend", "function block0", "R[0]++"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(jumpToStartTestProgram)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			options := allTranspileOptions()
+			options.Backend = tt.backend
+			source, err := cpu.StartTranspiler(options).Run()
+			if err != nil {
+				t.Fatalf("TranspileState.Run() error = %v", err)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(source, want) {
+					t.Errorf("TranspileState.Run() = %s\nwant it to contain %q", source, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCBackend(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiles the generated code")
+	}
+
+	compiler, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("C compiler not available")
+	}
+
+	dir, err := ioutil.TempDir("", "elf_code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "program.c")
+	binary := filepath.Join(dir, "program")
+	if err := ioutil.WriteFile(source, []byte(transpileDay19(t, CBackend{})), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if output, err := exec.Command(compiler, "-std=c99", "-O2", "-o", binary, source).CombinedOutput(); err != nil {
+		t.Fatalf("cc error = %v\n%s", err, output)
+	}
+
+	output, err := exec.Command(binary, "0").CombinedOutput()
+	if err != nil {
+		t.Fatalf("program error = %v\n%s", err, output)
+	}
+
+	// Only R[0] is checked, as transpiling removes unused writes to the other registers
+	got := strings.Fields(string(output))
+	if len(got) != 6 || got[0] != "2223" {
+		t.Errorf("program output = %q, want R[0] = 2223", output)
+	}
+}
@@ -1,6 +1,7 @@
 package elf_code
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"sort"
@@ -31,7 +32,7 @@ func (m *variableNameMap) getNew(register int) string {
 	name := ""
 	n := m.nextVarNumber
 	for n > 0 {
-		name += string('A' + (n - 1%26))
+		name += string(rune('A' + (n - 1%26)))
 		n /= 26
 	}
 
@@ -43,7 +44,7 @@ func (m *variableNameMap) getNew(register int) string {
 
 // Re-orders program inputs when order doesn't matter,
 // such that both `add A B A` and `add B A A` both become `add A B A`
-// and on OpCodes which have an option for IR and RI inputs, normalises to RI
+// and replaces reads of the instruction pointer with the constant they always read
 func (c *CPU) normaliseProgramForTranspile() {
 	for i, instruction := range c.Program {
 		// If input B is the same register as output C, reorder so it is always input A
@@ -56,47 +57,52 @@ func (c *CPU) normaliseProgramForTranspile() {
 			case EqIR:
 				instruction.OpCode = EqRI
 				instruction.A, instruction.B = instruction.B, instruction.A
-			case GtIR:
-				instruction.OpCode = GtRI
-				instruction.A, instruction.B = instruction.B, instruction.A
 			}
 		}
 
-		// Normalise IP modifications
-		if instruction.OpCode == AddR {
-			if instruction.A == c.InstructionPointerRegister {
-				if instruction.B == c.InstructionPointerRegister {
-					instruction.OpCode = SetI
-					instruction.A = i + i
-				} else {
-					instruction.OpCode = AddI
-					instruction.A, instruction.B = instruction.B, i
-				}
-			} else if instruction.B == c.InstructionPointerRegister {
-				instruction.OpCode = AddI
-				instruction.B = i
-			}
-		} else if instruction.OpCode == MulR {
-			if instruction.A == c.InstructionPointerRegister {
-				if instruction.B == c.InstructionPointerRegister {
-					instruction.OpCode = SetI
-					instruction.A = i * i
-				} else {
-					instruction.OpCode = MulI
-					instruction.A, instruction.B = instruction.B, i
+		c.Program[i] = c.readIPAsConstant(i, instruction)
+	}
+}
 
-				}
-			} else if instruction.B == c.InstructionPointerRegister {
-				instruction.OpCode = MulI
-				instruction.B = i
-			}
-		} else if instruction.OpCode == SetR && instruction.A == c.InstructionPointerRegister {
-			instruction.OpCode = SetI
-			instruction.A, instruction.B = i, 0
+// The transpiled code never writes the instruction pointer register, so reads of it are replaced with the
+// instruction pointer of the instruction. Instructions which only read it and immediates become a `seti`
+func (c *CPU) readIPAsConstant(ip int, instruction Instruction) Instruction {
+	ipRegister := c.InstructionPointerRegister
+	isImmediate := OpCodeInputType[instruction.OpCode]
+	readsA := !isImmediate.A && instruction.A == ipRegister
+	readsB := !isImmediate.B && instruction.B == ipRegister
+
+	if (!readsA && !readsB) || instruction.OpCode.talksToHost() {
+		return instruction
+	}
+
+	if (isImmediate.A || readsA) && (isImmediate.B || readsB) {
+		registers := NewRegisters(len(c.Registers))
+		registers[ipRegister] = ip
+
+		value, err := OpCodeFunc[instruction.OpCode](instruction.A, instruction.B, registers)
+		if err != nil {
+			return instruction
+		}
+		return Instruction{SetI, value, 0, instruction.C}
+	}
+
+	// Move the read to input B where the op code allows it, as only B has immediate versions
+	if readsA {
+		switch instruction.OpCode {
+		case AddR, MulR, BanR, BorR, EqRR:
+			instruction.A, instruction.B = instruction.B, instruction.A
+		case GtRR:
+			return Instruction{GtIR, ip, instruction.B, instruction.C}
+		default:
+			return instruction
 		}
+	}
 
-		c.Program[i] = instruction
+	if immediate, found := OpCodeImmedateVersion[instruction.OpCode]; found {
+		return Instruction{immediate, instruction.A, ip, instruction.C}
 	}
+	return instruction
 }
 
 type LineType int
@@ -106,6 +112,7 @@ const (
 	JumpStatement
 	IfStatement
 	DoWhileStatement
+	IdiomStatement
 )
 
 type ProgramLine struct {
@@ -118,6 +125,7 @@ type ProgramLine struct {
 	next            *ProgramLine  // The next line after this
 	previous        *ProgramLine  // The previous line before this
 	invertCondition bool          // Invert the condition of the while loop?
+	idiom           Idiom         // The closed form operation for idiom statements
 }
 
 func NewProgramLine(ip int, instruction *Instruction, block *ProgramBlock) *ProgramLine {
@@ -131,6 +139,7 @@ func NewProgramLine(ip int, instruction *Instruction, block *ProgramBlock) *Prog
 		nil,
 		nil,
 		false,
+		nil,
 	}
 }
 
@@ -264,6 +273,18 @@ func (pl *ProgramLine) WriteInstruction(str *strings.Builder) {
 	}
 
 	str.WriteString(fmt.Sprintf("R[%d] = ", pl.instruction.C))
+	pl.WriteExpression(str)
+}
+
+// Writes the right hand side of the instruction, such as `R[1] + 5`
+func (pl *ProgramLine) WriteExpression(str *strings.Builder) {
+	opCode := pl.instruction.OpCode
+	isImmediate := OpCodeInputType[opCode]
+
+	if registered, found := registeredOpCodes[opCode]; found {
+		str.WriteString(registered.expression(transpiledOperand(pl.instruction.A, isImmediate.A), transpiledOperand(pl.instruction.B, isImmediate.B)))
+		return
+	}
 
 	if isImmediate.A {
 		str.WriteString(fmt.Sprintf("%d", pl.instruction.A))
@@ -313,6 +334,13 @@ func (pl *ProgramLine) WriteInstruction(str *strings.Builder) {
 	}
 }
 
+func transpiledOperand(value int, isImmediate bool) string {
+	if isImmediate {
+		return fmt.Sprintf("%d", value)
+	}
+	return fmt.Sprintf("R[%d]", value)
+}
+
 type BlockType int
 
 const (
@@ -330,29 +358,64 @@ type ProgramBlock struct {
 	readsRegisters     []bool         // List of registers which have their existing values used when coming into this block
 }
 
-func (b ProgramBlock) writeBlockAsJavaScript(state *TranspileState, str *strings.Builder, indent int) {
-	indentStr := ""
-	for i := 0; i < indent; i++ {
-		indentStr += "    "
+// Checks every block this block calls, or has written inside it, will be in the output
+func (b *ProgramBlock) checkCallsAreWritten(written map[*ProgramBlock]bool) error {
+	for line := b.firstLine; line != nil; line = line.next {
+		if line.lineType == DoWhileStatement && line.jumpToBlock == nil {
+			return fmt.Errorf("error at %d: loop body was optimised out", line.ip)
+		}
+
+		if line.lineType != JumpStatement && line.lineType != IfStatement && line.lineType != DoWhileStatement {
+			continue
+		}
+
+		for _, target := range []*ProgramBlock{line.jumpToBlock, line.elseBlock} {
+			if target == nil {
+				continue
+			}
+
+			if target.blockType == InlineBlock {
+				if err := target.checkCallsAreWritten(written); err != nil {
+					return err
+				}
+			} else if !written[target] {
+				return fmt.Errorf("error at %d: call to block%d which was optimised out", line.ip, target.blockNum)
+			}
+		}
 	}
 
+	return nil
+}
+
+// Writes the block out using the state's backend
+func (b ProgramBlock) writeBlock(state *TranspileState, str *strings.Builder, indent int) {
+	backend := state.backend()
+	indentStr := strings.Repeat(backend.Indent(), indent)
+
 	if state.options.DisplayBlockNumbersOnOutput {
-		str.WriteString(fmt.Sprintf("%s// BEGIN block%d\n", indentStr, b.blockNum))
+		backend.WriteComment(state, fmt.Sprintf("BEGIN block%d", b.blockNum), indentStr, str)
+	}
+
+	// Writes the branch of an if statement or body of a loop
+	writeBranch := func(branch *ProgramBlock) {
+		if branch.blockType == InlineBlock {
+			branch.writeBlock(state, str, indent+1)
+		} else {
+			backend.WriteCall(state, nil, branch, indentStr+backend.Indent(), str)
+		}
 	}
 
 	for line := b.firstLine; line != nil; line = line.next {
 		switch line.lineType {
 		case Statement:
-			str.WriteString(indentStr)
-			line.WriteInstruction(str)
+			backend.WriteStatement(state, line, indentStr, str)
 		case JumpStatement:
 			if line.jumpToBlock == nil {
-				str.WriteString(indentStr + "// FIXME: jump to nil\n")
+				backend.WriteComment(state, "FIXME: jump to nil", indentStr, str)
 			} else if line.jumpToBlock.blockType == InlineBlock {
-				line.jumpToBlock.writeBlockAsJavaScript(state, str, indent) // does not need extra indent
-				continue                                                    // don't need to original comment
+				line.jumpToBlock.writeBlock(state, str, indent) // does not need extra indent
 			} else {
-				str.WriteString(fmt.Sprintf("%sblock%d()", indentStr, line.jumpToBlock.blockNum))
+				backend.WriteCall(state, line, line.jumpToBlock, indentStr, str)
 			}
 		case IfStatement:
 			if b.firstLine != line {
@@ -361,72 +424,59 @@ func (b ProgramBlock) writeBlockAsJavaScript(state *TranspileState, str *strings
 			}
 
 			if line.jumpToBlock != nil {
-				str.WriteString(fmt.Sprintf("%sif (R[%d] == 1) { // IP: %d (%s)\n", indentStr, line.instruction.A, line.ip, state.originalCode[line.ip]))
-
-				if line.jumpToBlock.blockType == InlineBlock {
-					line.jumpToBlock.writeBlockAsJavaScript(state, str, indent+1)
-				} else {
-					str.WriteString(fmt.Sprintf("%s    block%d()\n", indentStr, line.jumpToBlock.blockNum))
-				}
+				backend.WriteIfStart(state, line, line.instruction.A, 1, indentStr, str)
+				writeBranch(line.jumpToBlock)
 
 				if line.elseBlock != nil {
-					str.WriteString(indentStr + "} else {\n")
-					if line.elseBlock.blockType == InlineBlock {
-						line.elseBlock.writeBlockAsJavaScript(state, str, indent+1)
-					} else {
-						str.WriteString(fmt.Sprintf("%s    block%d()\n", indentStr, line.elseBlock.blockNum))
-					}
+					backend.WriteElse(state, line, indentStr, str)
+					writeBranch(line.elseBlock)
 				}
 
-				str.WriteString(indentStr + "}\n")
+				backend.WriteIfEnd(state, line, indentStr, str)
 			} else if line.elseBlock != nil {
-				str.WriteString(fmt.Sprintf("%sif (R[%d] == 0) { // IP: %d (%s)\n", indentStr, line.instruction.A, line.ip, state.originalCode[line.ip]))
-				if line.elseBlock.blockType == InlineBlock {
-					line.elseBlock.writeBlockAsJavaScript(state, str, indent+1)
-				} else {
-					str.WriteString(fmt.Sprintf("%s    block%d()\n", indentStr, line.elseBlock.blockNum))
-				}
-				str.WriteString(indentStr + "}\n")
+				backend.WriteIfStart(state, line, line.instruction.A, 0, indentStr, str)
+				writeBranch(line.elseBlock)
+				backend.WriteIfEnd(state, line, indentStr, str)
 			} else {
-				panic("JumpStatement with no jumps!")
+				// Both branches have been optimised away, so the condition no longer matters
+				backend.WriteComment(state, state.ipComment(line.ip)+" both branches end the program", indentStr, str)
 			}
 
 			if b.lastLine != line {
 				// Add whitespace under if statements for readability
 				str.WriteString("\n")
 			}
-			continue
 		case DoWhileStatement:
 			if b.firstLine != line {
 				// Add whitespace above if statements for readability
 				str.WriteString("\n")
 			}
 
-			str.WriteString(fmt.Sprintf("%sdo {\n", indentStr))
+			backend.WriteDoWhileStart(state, line, indentStr, str)
 
-			line.jumpToBlock.writeBlockAsJavaScript(state, str, indent+1)
+			line.jumpToBlock.writeBlock(state, str, indent+1)
 
 			targetValue := 1
 			if line.invertCondition {
 				targetValue = 0
 			}
-			str.WriteString(fmt.Sprintf("%s} while (R[%d] == %d); // IP: %d (%s)\n", indentStr, line.instruction.A, targetValue, line.ip, state.originalCode[line.ip]))
+			backend.WriteDoWhileEnd(state, line, line.instruction.A, targetValue, indentStr, str)
 
 			if b.lastLine != line {
 				// Add whitespace under if statements for readability
 				str.WriteString("\n")
 			}
-			continue
+
+		case IdiomStatement:
+			backend.WriteIdiom(state, line, indentStr, str)
 
 		default:
 			log.Println("Unknown line type", line.lineType)
 		}
-
-		str.WriteString(fmt.Sprintf(" // IP: %d (%s)\n", line.ip, state.originalCode[line.ip]))
 	}
 
 	if state.options.DisplayBlockNumbersOnOutput {
-		str.WriteString(fmt.Sprintf("%s// END block%d\n", indentStr, b.blockNum))
+		backend.WriteComment(state, fmt.Sprintf("END block%d", b.blockNum), indentStr, str)
 	}
 }
 
@@ -477,14 +527,18 @@ func (b *ProgramBlock) getLastJumpIfInlined() *ProgramLine {
 
 func (b ProgramBlock) debugPrintBlock(t *TranspileState) {
 	var str strings.Builder
-	b.writeBlockAsJavaScript(t, &str, 1)
+	b.writeBlock(t, &str, 1)
 	log.Println(str.String())
 }
 
 func (b *ProgramBlock) compressConstants(state *TranspileState) (changes int) {
 	compressInlineBlock := func(jumpTo *ProgramBlock) {
-		if jumpTo.blockType == InlineBlock {
+		if jumpTo.blockType == InlineBlock && len(jumpTo.calledBy) == 1 {
 			changes += jumpTo.compressConstants(state)
+		} else if jumpTo.blockType == InlineBlock {
+			// The block is written out at each call, but they share its lines, so the constants
+			// from one of them can't be folded into it
+			state.resetRegistersToUnknownState()
 		} else {
 			// The jump could have modified something, so let's remove any constant flags
 			// we have for things it could have changed
@@ -493,12 +547,6 @@ func (b *ProgramBlock) compressConstants(state *TranspileState) (changes int) {
 					state.Registers[i].SetUnknownInt()
 				}
 			}
-
-			for i, m := range jumpTo.readsRegisters {
-				if m {
-					state.Registers[i].MarkRead()
-				}
-			}
 		}
 	}
 
@@ -507,8 +555,12 @@ func (b *ProgramBlock) compressConstants(state *TranspileState) (changes int) {
 			instruction := line.instruction
 			isImmediate := OpCodeInputType[instruction.OpCode]
 
+			// The instruction pointer always holds the line's own IP when it runs
+			state.Registers[state.cpu.InstructionPointerRegister].SetInt(line.ip)
+
 			// Can we evaluate this expression at compile time?
-			if (isImmediate.A || state.Registers[instruction.A].isConst) &&
+			if !instruction.OpCode.talksToHost() &&
+				(isImmediate.A || state.Registers[instruction.A].isConst) &&
 				(isImmediate.B || state.Registers[instruction.B].isConst) {
 				state.updateCPURegisters()
 				value, err := OpCodeFunc[line.instruction.OpCode](instruction.A, instruction.B, state.cpu.Registers)
@@ -516,59 +568,46 @@ func (b *ProgramBlock) compressConstants(state *TranspileState) (changes int) {
 					panic(err)
 				}
 
-				if state.options.RemoveUnUsedRegisterWrites {
-					lastLine := state.Registers[instruction.C].lastUnreadSet
-					if lastLine != nil && lastLine.parentBlock == b {
-						lastLine.RemoveUnusedLine()
-					}
-				}
-
 				if instruction.OpCode != SetI {
 					instruction.OpCode = SetI
 					instruction.A = value
 					instruction.B = 0
 					changes++
 				}
-				state.Registers[instruction.C].SetInt(value, line)
+				state.Registers[instruction.C].SetInt(value)
 			} else if iOp, found := OpCodeImmedateVersion[instruction.OpCode]; found &&
 				state.Registers[instruction.B].isConst {
 
-				if !isImmediate.A {
-					state.Registers[instruction.A].MarkRead()
-				}
-
 				instruction.OpCode = iOp
 				instruction.B = state.Registers[instruction.B].value
 
 				state.Registers[instruction.C].SetUnknownInt()
 				changes++
 			} else {
-				if !isImmediate.A {
-					state.Registers[instruction.A].MarkRead()
-				}
-
-				if !isImmediate.B {
-					state.Registers[instruction.B].MarkRead()
-				}
-
 				state.Registers[instruction.C].SetUnknownInt()
 			}
-		} else if line.lineType != DoWhileStatement {
+		} else if line.lineType == IfStatement {
+			// Each branch starts from the registers before the if, and afterwards only what both
+			// branches agree on is still known (a missing branch leaves the registers as they were)
+			before := append([]RegisterState{}, state.Registers...)
 			if line.jumpToBlock != nil {
 				compressInlineBlock(line.jumpToBlock)
 			}
 
+			afterTrue := append([]RegisterState{}, state.Registers...)
+			copy(state.Registers, before)
 			if line.elseBlock != nil {
 				compressInlineBlock(line.elseBlock)
 			}
-		}
-	}
 
-	// If we're leaving a function, remove any unused writes
-	if b.blockType == FunctionBlock && state.options.RemoveUnUsedRegisterWrites {
-		for _, register := range state.Registers {
-			if register.lastUnreadSet != nil {
-				register.lastUnreadSet.RemoveUnusedLine()
+			for i := range state.Registers {
+				if state.Registers[i] != afterTrue[i] {
+					state.Registers[i].SetUnknownInt()
+				}
+			}
+		} else if line.lineType != DoWhileStatement {
+			if line.jumpToBlock != nil {
+				compressInlineBlock(line.jumpToBlock)
 			}
 		}
 	}
@@ -639,18 +678,24 @@ const (
 	BoolType
 )
 
+func (d DataType) String() string {
+	if d == BoolType {
+		return "bool"
+	}
+
+	return "int"
+}
+
 type RegisterState struct {
-	isConst       bool         // Is the register holding a constant? If so see `value`
-	value         int          // The constant value the register is holding
-	dataType      DataType     // Is the register holding a boolean result?
-	lastUnreadSet *ProgramLine // The previous line setting the value
+	isConst  bool     // Is the register holding a constant? If so see `value`
+	value    int      // The constant value the register is holding
+	dataType DataType // Is the register holding a boolean result?
 }
 
-func (r *RegisterState) SetInt(value int, lineSettingValue *ProgramLine) {
+func (r *RegisterState) SetInt(value int) {
 	r.isConst = true
 	r.value = value
 	r.dataType = IntType
-	r.lastUnreadSet = lineSettingValue
 }
 
 func (r *RegisterState) SetUnknownInt() {
@@ -665,10 +710,6 @@ func (r *RegisterState) SetUnknownBool() {
 	r.dataType = BoolType
 }
 
-func (r *RegisterState) MarkRead() {
-	r.lastUnreadSet = nil
-}
-
 type TranspileOptions struct {
 	CompressConstants           bool // Where there are multiple instructions in a block building a constant, compress into a single SetI
 	RemoveEmptyBlocks           bool // Remove blocks with no instructions in them
@@ -676,8 +717,11 @@ type TranspileOptions struct {
 	RemoveUnUsedRegisterWrites  bool // Remove unused register writes
 	RewriteRecursionAsLoops     bool // If possible rewrite recursion as loops
 	InlineBlocksWherePossible   bool // Inline Blocks which are only called once
+	RecogniseIdioms             bool // Replace known loop patterns with closed form idiom statements
 	DisplayBlockNumbersOnOutput bool // Should the output include block information?
 	DisplayBlockRegisterUse     bool // Should the output include which registers are modified by a block?
+
+	Backend TranspileBackend // The language to write the program out in (JavaScript if nil)
 }
 
 type TranspileState struct {
@@ -687,6 +731,9 @@ type TranspileState struct {
 	ipToInstruction map[int]*ProgramLine  // The instruction pointer mapping to a program line
 	Registers       []RegisterState       // The Registers
 	options         TranspileOptions      // The options
+	mainBlock       *ProgramBlock         // The block the program starts in, once compiled
+	idioms          int                   // The number of idiom statements in the compiled program
+	booleanInputs   []int                 // The registers starting as booleans, which can be used in jumps
 
 	nextBlockNum int
 }
@@ -699,6 +746,9 @@ func (cpu *CPU) StartTranspiler(options TranspileOptions) (state *TranspileState
 		make(map[int]*ProgramLine),
 		make([]RegisterState, len(cpu.Registers)),
 		options,
+		nil,
+		0,
+		nil,
 		0,
 	}
 
@@ -707,7 +757,7 @@ func (cpu *CPU) StartTranspiler(options TranspileOptions) (state *TranspileState
 
 	// Set the instruction pointer const
 	for i := 0; i < len(cpu.Registers); i++ {
-		state.Registers[i].SetInt(cpu.Registers[i], nil)
+		state.Registers[i].SetInt(cpu.Registers[i])
 	}
 
 	// Normalise the CPU code for the transpile
@@ -717,6 +767,20 @@ func (cpu *CPU) StartTranspiler(options TranspileOptions) (state *TranspileState
 	return
 }
 
+// The backend to write the program out with
+func (t *TranspileState) backend() TranspileBackend {
+	if t.options.Backend == nil {
+		return JavaScriptBackend{}
+	}
+
+	return t.options.Backend
+}
+
+// A comment pointing back at the original instruction
+func (t *TranspileState) ipComment(ip int) string {
+	return fmt.Sprintf("IP: %d (%s)", ip, t.originalCode[ip])
+}
+
 func (t *TranspileState) updateCPURegisters() {
 	for i, register := range t.Registers {
 		if register.isConst {
@@ -734,11 +798,10 @@ func (t *TranspileState) resetRegistersToUnknownState() {
 		t.Registers[i].isConst = false
 		t.Registers[i].dataType = IntType
 		t.Registers[i].value = 0
-		t.Registers[i].lastUnreadSet = nil
 	}
 }
 
-func (t *TranspileState) processBlock(startIP int, stopIP int, callingLine *ProgramLine) (block *ProgramBlock) {
+func (t *TranspileState) processBlock(startIP int, stopIP int, callingLine *ProgramLine) (block *ProgramBlock, err error) {
 	block, found := t.blocks[startIP]
 	if found {
 		return
@@ -757,13 +820,19 @@ func (t *TranspileState) processBlock(startIP int, stopIP int, callingLine *Prog
 	// Is this instruction already in a block? If so, then we need to move it into it's own block
 	// and replace the original call site with a jump to this new version of the block
 	if line, found := t.ipToInstruction[startIP]; found {
+		for following := line.next; following != nil; following = following.next {
+			if t.ipToInstruction[following.ip] == following {
+				delete(t.ipToInstruction, following.ip)
+			}
+		}
+
 		line.RemoveAllFollowingLines()
 		line.lineType = JumpStatement
 		line.instruction = nil
 		line.jumpToBlock = block
 	}
 
-	t.Registers[t.cpu.InstructionPointerRegister].SetInt(startIP, nil)
+	t.Registers[t.cpu.InstructionPointerRegister].SetInt(startIP)
 	for {
 		ip := t.Registers[t.cpu.InstructionPointerRegister].value
 
@@ -774,7 +843,6 @@ func (t *TranspileState) processBlock(startIP int, stopIP int, callingLine *Prog
 
 		// Convert the instruction to a line and link it into the parentBlock
 		line := NewProgramLine(ip, &t.cpu.Program[ip], block)
-		t.ipToInstruction[ip] = line
 		if block.lastLine == nil {
 			block.firstLine = line
 		} else {
@@ -783,6 +851,26 @@ func (t *TranspileState) processBlock(startIP int, stopIP int, callingLine *Prog
 		}
 		block.lastLine = line
 
+		// Carry on in the block which already holds this instruction, rather than writing it out again,
+		// as both copies would share the instruction which the optimisations rewrite
+		_, inBlock := t.ipToInstruction[ip]
+		if _, isBlock := t.blocks[ip]; (inBlock || isBlock) && ip != startIP {
+			line.lineType = JumpStatement
+			line.instruction = nil
+			line.jumpToBlock, err = t.processBlock(ip, stopIP, line)
+			if err != nil {
+				return nil, err
+			}
+
+			for i := 0; i < len(block.modifiersRegisters); i++ {
+				if !block.modifiersRegisters[i] {
+					block.readsRegisters[i] = block.readsRegisters[i] || line.jumpToBlock.readsRegisters[i]
+				}
+			}
+			return
+		}
+		t.ipToInstruction[ip] = line
+
 		// Is this a program line change?
 		isImmediate := OpCodeInputType[line.instruction.OpCode]
 
@@ -792,14 +880,17 @@ func (t *TranspileState) processBlock(startIP int, stopIP int, callingLine *Prog
 				// Update the Registers to present our known state
 				t.updateCPURegisters()
 
-				value, err := OpCodeFunc[line.instruction.OpCode](line.instruction.A, line.instruction.B, t.cpu.Registers)
-				if err != nil {
-					panic(err)
+				value, e := OpCodeFunc[line.instruction.OpCode](line.instruction.A, line.instruction.B, t.cpu.Registers)
+				if e != nil {
+					return nil, fmt.Errorf("unable to evaluate jump at %d: %v", ip, e)
 				}
 
 				line.lineType = JumpStatement
 				line.instruction = nil
-				line.jumpToBlock = t.processBlock(value+1, stopIP, line)
+				line.jumpToBlock, err = t.processBlock(value+1, stopIP, line)
+				if err != nil {
+					return nil, fmt.Errorf("invalid jump at %d: %v", ip, err)
+				}
 
 				for i := 0; i < len(block.modifiersRegisters); i++ {
 					if !block.modifiersRegisters[i] {
@@ -808,17 +899,25 @@ func (t *TranspileState) processBlock(startIP int, stopIP int, callingLine *Prog
 				}
 				return // This block ends because fo the jump
 			} else if (line.instruction.OpCode == AddI) &&
-				(t.Registers[line.instruction.A].dataType == BoolType) {
+				(t.Registers[line.instruction.A].dataType == BoolType) &&
+				t.originalCode.JumpAt(ip, t.cpu.InstructionPointerRegister, t.booleanInputs...).Kind == ConditionalJump {
+				// Other jumps landing between the comparison and here mean it isn't always a boolean
 
 				line.lineType = IfStatement
-				line.jumpToBlock = t.processBlock(ip+2, stopIP, line)
+				line.jumpToBlock, err = t.processBlock(ip+2, stopIP, line)
+				if err != nil {
+					return nil, err
+				}
 				for i := 0; i < len(block.modifiersRegisters); i++ {
 					if !block.modifiersRegisters[i] {
 						block.readsRegisters[i] = block.readsRegisters[i] || line.jumpToBlock.readsRegisters[i]
 					}
 				}
 
-				line.elseBlock = t.processBlock(ip+1, stopIP, line)
+				line.elseBlock, err = t.processBlock(ip+1, stopIP, line)
+				if err != nil {
+					return nil, err
+				}
 				for i := 0; i < len(block.modifiersRegisters); i++ {
 					if !block.modifiersRegisters[i] {
 						block.readsRegisters[i] = block.readsRegisters[i] || line.elseBlock.readsRegisters[i]
@@ -827,7 +926,7 @@ func (t *TranspileState) processBlock(startIP int, stopIP int, callingLine *Prog
 				// If statement found!
 				return
 			} else {
-				panic(fmt.Sprintf("Non constant $IP change at %d: %s", ip, line.instruction))
+				return nil, fmt.Errorf("non constant $IP change at %d: %s", ip, line.instruction)
 			}
 		} else {
 			// Flag if this block reads a register before it has written to it
@@ -856,6 +955,21 @@ func (t *TranspileState) processBlock(startIP int, stopIP int, callingLine *Prog
 	return
 }
 
+// Checks every instruction only references registers which exist, so the program can be safely
+// evaluated while transpiling
+func (t *TranspileState) validateProgram() error {
+	if t.cpu.Word.Bits != 0 {
+		return fmt.Errorf("unable to transpile %s words", t.cpu.Word)
+	}
+
+	// None of the backends can give the transpiled code a host to talk to
+	if ip, found := t.cpu.Program.findHostOpCode(); found {
+		return fmt.Errorf("unable to transpile %v at %d, as it talks to the host", t.cpu.Program[ip].OpCode, ip)
+	}
+
+	return t.cpu.Program.Validate(len(t.Registers))
+}
+
 func (t *TranspileState) buildCalledByListsForBlocks() {
 	for _, b := range t.blocks {
 		b.calledBy = make([]*ProgramLine, 0)
@@ -874,12 +988,16 @@ func (t *TranspileState) buildCalledByListsForBlocks() {
 	}
 }
 
-// Some blocks, might just be calls to other blocks, so we remove them at this point by inling them
-func (t *TranspileState) removeJumpOnlyBlocks() (changes int) {
+// Some blocks, might just be calls to other blocks, so we remove them at this point by inling them.
+// The entry block is also run when the program starts, so is kept, as are blocks which jump to themselves
+func (t *TranspileState) removeJumpOnlyBlocks(entry *ProgramBlock) (changes int) {
 	for _, b := range t.blocks {
-		if b.firstLine != nil &&
+		if b != entry &&
+			b.firstLine != nil &&
 			b.firstLine == b.lastLine &&
 			b.firstLine.lineType == JumpStatement &&
+			b.firstLine.jumpToBlock != nil &&
+			b.firstLine.jumpToBlock != b &&
 			len(b.calledBy) > 0 {
 
 			targetBlock := b.firstLine.jumpToBlock
@@ -906,9 +1024,11 @@ func (t *TranspileState) removeEmptyBlocks() (changes int) {
 	return
 }
 
-func (t *TranspileState) inlineCallOnceBlocks() (changes int) {
+// Inlines blocks which are only called from one place. The entry block is also run when the program starts,
+// so it is never inlined
+func (t *TranspileState) inlineCallOnceBlocks(entry *ProgramBlock) (changes int) {
 	for _, b := range t.blocks {
-		if len(b.calledBy) == 1 {
+		if len(b.calledBy) == 1 && b != entry {
 			if b.calledBy[0].lineType == JumpStatement && b.calledBy[0].previous != nil && b.firstLine != nil {
 				b.firstLine.moveToNewBlockAfter(b.calledBy[0].previous)
 				b.calledBy[0].RemoveUnusedLine()
@@ -994,6 +1114,26 @@ func (t *TranspileState) compressConstants() (changes int) {
 	return
 }
 
+// Removes lines writing values which are never read, using the liveness of the program as rewritten
+// so far (so writes only read by instructions which have since been compressed to constants are removed)
+func (t *TranspileState) removeUnusedRegisterWrites(booleanInputs []int) (changes int) {
+	flow, err := t.cpu.DataFlow(booleanInputs...)
+	if err != nil {
+		return
+	}
+
+	for _, b := range t.blocks {
+		for line := b.firstLine; line != nil; line = line.next {
+			if line.lineType == Statement && flow.IsDeadWrite(line.ip) {
+				line.RemoveUnusedLine()
+				changes++
+			}
+		}
+	}
+
+	return
+}
+
 func (t *TranspileState) findAndRewriteLoops() (changes int) {
 	for _, b := range t.blocks {
 		if len(b.calledBy) == 2 && // It's only called twice
@@ -1015,13 +1155,16 @@ func (t *TranspileState) findAndRewriteLoops() (changes int) {
 				contentToAddAfterLoop = b.lastLine.jumpToBlock
 			}
 
-			if isLoop {
-				// Find the calling line
-				callingLine := b.calledBy[0]
-				if callingLine.parentBlock == b {
-					callingLine = b.calledBy[1]
-				}
+			// Find the calling line, which can only become the loop if it's a plain jump into this block
+			callingLine := b.calledBy[0]
+			if callingLine.parentBlock == b {
+				callingLine = b.calledBy[1]
+			}
+			if callingLine.parentBlock == b || callingLine.lineType != JumpStatement || callingLine.jumpToBlock != b {
+				isLoop = false
+			}
 
+			if isLoop {
 				callingLine.lineType = DoWhileStatement
 				callingLine.invertCondition = loopConditionInverse
 				// Change this block to an inline block
@@ -1041,15 +1184,39 @@ func (t *TranspileState) findAndRewriteLoops() (changes int) {
 	return
 }
 
-// Transpile's the program to pseudo code to easier reading
-func (t *TranspileState) Run() (string, error) {
-	var str strings.Builder
+// Builds the program blocks and applies the optimisations in the options. This only happens once,
+// further calls do nothing
+func (t *TranspileState) Compile() error {
+	if t.mainBlock != nil {
+		return nil
+	}
 
 	initalRegisterState := make([]RegisterState, len(t.Registers))
 	copy(initalRegisterState, t.Registers)
 
-	// Build the program out into the minimum number of blocks it can exist as
-	startingBlock := t.processBlock(0, 37, nil)
+	// Registers starting as booleans can be used in jumps
+	booleanInputs := make([]int, 0)
+	for i, register := range initalRegisterState {
+		if register.dataType == BoolType {
+			booleanInputs = append(booleanInputs, i)
+		}
+	}
+	t.booleanInputs = booleanInputs
+
+	if err := t.validateProgram(); err != nil {
+		return err
+	}
+
+	// Build the program out into the minimum number of blocks it can exist as, starting from
+	// wherever the instruction pointer currently is
+	startIP := 0
+	if ipState := t.Registers[t.cpu.InstructionPointerRegister]; ipState.isConst {
+		startIP = ipState.value
+	}
+	startingBlock, err := t.processBlock(startIP, len(t.cpu.Program), nil)
+	if err != nil {
+		return err
+	}
 	t.buildCalledByListsForBlocks()
 	startingBlock.buildRegisterUsageLists(make(map[*ProgramBlock]bool))
 
@@ -1059,7 +1226,7 @@ func (t *TranspileState) Run() (string, error) {
 	for changes := 1; changes > 0; {
 		changes = 0
 		if t.options.RemoveExtraJumps {
-			changes += t.removeJumpOnlyBlocks()
+			changes += t.removeJumpOnlyBlocks(startingBlock)
 		}
 
 		if t.options.RemoveEmptyBlocks {
@@ -1067,19 +1234,26 @@ func (t *TranspileState) Run() (string, error) {
 		}
 
 		if t.options.InlineBlocksWherePossible {
-			changes += t.inlineCallOnceBlocks()
+			changes += t.inlineCallOnceBlocks(startingBlock)
 			changes += t.inlineBlocksAfterIfsWhichEndBothBranches()
 		}
 
 		if t.options.CompressConstants {
-			// We need initial register state here for the main func
-			copy(t.Registers, initalRegisterState)
-			startingBlock.compressConstants(t)
+			// We need initial register state here for the main func, unless jumps back to it mean it
+			// can also run with other values
+			if len(startingBlock.calledBy) == 0 {
+				copy(t.Registers, initalRegisterState)
+				startingBlock.compressConstants(t)
+			}
 
 			// Then rest as normal
 			t.resetRegistersToUnknownState()
 			changes += t.compressConstants()
 		}
+
+		if t.options.RemoveUnUsedRegisterWrites {
+			changes += t.removeUnusedRegisterWrites(booleanInputs)
+		}
 	}
 
 	// This occurs outside the other loops, as inlinng the blocks could cause other optimisations to
@@ -1088,6 +1262,24 @@ func (t *TranspileState) Run() (string, error) {
 		for t.findAndRewriteLoops() > 0 {}
 	}
 
+	// Idioms are found within the loops, so have to be last
+	if t.options.RecogniseIdioms {
+		t.idioms = t.recogniseIdioms()
+	}
+
+	t.mainBlock = startingBlock
+	return nil
+}
+
+// Transpile's the program to pseudo code to easier reading
+func (t *TranspileState) Run() (string, error) {
+	var str strings.Builder
+
+	if err := t.Compile(); err != nil {
+		return "", err
+	}
+	startingBlock := t.mainBlock
+
 	// Copy the blocks to a slice, then sort
 	blocks := make([]*ProgramBlock, len(t.blocks))
 	i := 0
@@ -1105,16 +1297,8 @@ func (t *TranspileState) Run() (string, error) {
 		}
 	})
 
-	str.WriteString("// Registers\nvar R = [")
-	for i := 0; i < len(t.Registers); i++ {
-		if i > 0 {
-			str.WriteString(", ")
-		}
-
-		str.WriteRune('0')
-	}
-	str.WriteString("]\n\n")
-
+	// Only function blocks which are still called get written out
+	functions := make([]*ProgramBlock, 0, len(blocks))
 	for _, b := range blocks {
 		if b.blockType == InlineBlock {
 			// This should be written inside a function block somewhere
@@ -1126,36 +1310,51 @@ func (t *TranspileState) Run() (string, error) {
 			continue
 		}
 
+		functions = append(functions, b)
+	}
+
+	if startingBlock.blockType == InlineBlock {
+		return "", errors.New("the block the program starts in was optimised out")
+	}
+
+	// Every call must be to a block which gets written out
+	written := make(map[*ProgramBlock]bool)
+	for _, b := range functions {
+		written[b] = true
+	}
+	for _, b := range functions {
+		if err := b.checkCallsAreWritten(written); err != nil {
+			return "", err
+		}
+	}
+
+	backend := t.backend()
+	backend.WriteProgramStart(t, functions, &str)
+
+	for _, b := range functions {
 		indent := 1
 
-		if b == startingBlock {
-			if t.options.DisplayBlockRegisterUse {
-				b.WriteBlockRegisterModificationList(&str)
-			}
-			str.WriteString("function main() {\n")
-		} else {
-			if t.options.DisplayBlockNumbersOnOutput {
-				str.WriteString("// Called By: ")
-				for index, line := range b.calledBy {
-					if index > 0 {
-						str.WriteString(", ")
-					}
-					str.WriteString(fmt.Sprintf("block%d", line.parentBlock.blockNum))
+		if b != startingBlock && t.options.DisplayBlockNumbersOnOutput {
+			str.WriteString("// Called By: ")
+			for index, line := range b.calledBy {
+				if index > 0 {
+					str.WriteString(", ")
 				}
-				str.WriteString("\n")
+				str.WriteString(fmt.Sprintf("block%d", line.parentBlock.blockNum))
 			}
-
-			if t.options.DisplayBlockRegisterUse {
-				b.WriteBlockRegisterModificationList(&str)
-			}
-
-			str.WriteString(fmt.Sprintf("function block%d() {\n", b.blockNum))
+			str.WriteString("\n")
 		}
 
-		b.writeBlockAsJavaScript(t, &str, indent)
+		if t.options.DisplayBlockRegisterUse {
+			b.WriteBlockRegisterModificationList(&str)
+		}
 
-		str.WriteString("}\n\n")
+		backend.WriteFunctionStart(t, b, b == startingBlock, &str)
+		b.writeBlock(t, &str, indent)
+		backend.WriteFunctionEnd(t, b, b == startingBlock, &str)
 	}
 
-	return str.String(), nil
+	backend.WriteProgramEnd(t, functions, &str)
+
+	return backend.Finish(str.String())
 }
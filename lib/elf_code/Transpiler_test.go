@@ -0,0 +1,199 @@
+package elf_code
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// The day 19 program, which sums the factors of a number
+const day19Program = `#ip 3
+addi 3 16 3
+seti 1 7 1
+seti 1 7 5
+mulr 1 5 4
+eqrr 4 2 4
+addr 4 3 3
+addi 3 1 3
+addr 1 0 0
+addi 5 1 5
+gtrr 5 2 4
+addr 3 4 3
+seti 2 2 3
+addi 1 1 1
+gtrr 1 2 4
+addr 4 3 3
+seti 1 5 3
+mulr 3 3 3
+addi 2 2 2
+mulr 2 2 2
+mulr 3 2 2
+muli 2 11 2
+addi 4 2 4
+mulr 4 3 4
+addi 4 2 4
+addr 2 4 2
+addr 3 0 3
+seti 0 8 3
+setr 3 8 4
+mulr 4 3 4
+addr 3 4 4
+mulr 3 4 4
+muli 4 14 4
+mulr 4 3 4
+addr 2 4 2
+seti 0 7 0
+seti 0 9 3`
+
+// The day 21 program, which halts when R[0] matches a generated number
+const day21Program = `#ip 2
+seti 123 0 4
+bani 4 456 4
+eqri 4 72 4
+addr 4 2 2
+seti 0 0 2
+seti 0 5 4
+bori 4 65536 5
+seti 1765573 9 4
+bani 5 255 1
+addr 4 1 4
+bani 4 16777215 4
+muli 4 65899 4
+bani 4 16777215 4
+gtir 256 5 1
+addr 1 2 2
+addi 2 1 2
+seti 27 0 2
+seti 0 8 1
+addi 1 1 3
+muli 3 256 3
+gtrr 3 5 3
+addr 3 2 2
+addi 2 1 2
+seti 25 1 2
+addi 1 1 1
+seti 17 7 2
+setr 1 4 5
+seti 7 6 2
+eqrr 4 0 1
+addr 1 2 2
+seti 5 2 2`
+
+func allTranspileOptions() TranspileOptions {
+	return TranspileOptions{
+		CompressConstants:          true,
+		RemoveEmptyBlocks:          true,
+		RemoveExtraJumps:           true,
+		RemoveUnUsedRegisterWrites: true,
+		RewriteRecursionAsLoops:    true,
+		InlineBlocksWherePossible:  true,
+	}
+}
+
+func TestTranspileState_Run(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		unknown []int
+		wantErr string
+	}{
+		{"Day 19", day19Program, []int{0}, ""}, // R[0] is only ever used to pick a branch
+		{"Day 21", day21Program, []int{0}, ""},
+		{"Jump from register", debuggerTestProgram, nil, "non constant $IP change at 4"},
+		{"Loop", loopTestProgram, nil, ""},
+		{"Branches which both halt", "#ip 0\naddr 1 0 0\nseti 0 0 2", []int{1}, ""},
+		{"Computed jump", "#ip 0\nsetr 1 0 0\nseti 0 0 2", []int{1}, "non constant $IP change at 0"},
+		{"Negative jump", "#ip 0\nseti 1 0 1\nseti -5 0 0", nil, ""},
+		{"Jump after comparison", jumpAfterComparisonTestProgram, nil, "non constant $IP change at 5"},
+		{"Register out of range", "#ip 0\naddr 9 1 2", nil, "input A out of range at 0"},
+		{"Infinite loop", "#ip 3\nseti 1 0 0\nseti 0 0 3", nil, ""},
+		{"Optimised out loop exit", optimisedOutLoopExitProgram, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(tt.program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			transpiler := cpu.StartTranspiler(allTranspileOptions())
+			for _, register := range tt.unknown {
+				transpiler.Registers[register].SetUnknownBool()
+			}
+
+			output, err := transpiler.Run()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("TranspileState.Run() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("TranspileState.Run() error = %v", err)
+			}
+
+			if !strings.Contains(output, "function main() {") {
+				t.Errorf("TranspileState.Run() = %q, missing main function", output)
+			}
+
+			// Every block called must also be written out
+			for _, call := range regexp.MustCompile(`(block\d+)\(\)`).FindAllStringSubmatch(output, -1) {
+				if !strings.Contains(output, "function "+call[1]+"() {") {
+					t.Errorf("TranspileState.Run() = %s\ncalls %s which isn't written", output, call[1])
+				}
+			}
+		})
+	}
+}
+
+func TestProgramBlock_checkCallsAreWritten(t *testing.T) {
+	removed := &ProgramBlock{blockNum: 2}
+	jump := &ProgramLine{ip: 1, lineType: JumpStatement, jumpToBlock: removed}
+	main := &ProgramBlock{firstLine: jump, lastLine: jump}
+
+	err := main.checkCallsAreWritten(map[*ProgramBlock]bool{main: true})
+	if err == nil || err.Error() != "error at 1: call to block2 which was optimised out" {
+		t.Errorf("ProgramBlock.checkCallsAreWritten() error = %v, want call to block2 which was optimised out", err)
+	}
+
+	if err := main.checkCallsAreWritten(map[*ProgramBlock]bool{main: true, removed: true}); err != nil {
+		t.Errorf("ProgramBlock.checkCallsAreWritten() error = %v", err)
+	}
+
+	jump.lineType, jump.jumpToBlock = DoWhileStatement, nil
+	err = main.checkCallsAreWritten(map[*ProgramBlock]bool{main: true})
+	if err == nil || err.Error() != "error at 1: loop body was optimised out" {
+		t.Errorf("ProgramBlock.checkCallsAreWritten() error = %v, want loop body was optimised out", err)
+	}
+}
+
+func TestTranspileState_RemoveUnUsedRegisterWrites(t *testing.T) {
+	const program = "#ip 5\nseti 4 0 1\nseti 7 0 1\naddi 1 2 0"
+
+	tests := []struct {
+		name    string
+		options TranspileOptions
+		want    bool
+	}{
+		{"Off", TranspileOptions{Backend: PseudoCodeBackend{}}, true},
+		{"On", TranspileOptions{RemoveUnUsedRegisterWrites: true, Backend: PseudoCodeBackend{}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cpu, err := NewCPUFromProgramFile(program)
+			if err != nil {
+				t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+			}
+
+			output, err := cpu.StartTranspiler(tt.options).Run()
+			if err != nil {
+				t.Fatalf("TranspileState.Run() error = %v", err)
+			}
+
+			if got := strings.Contains(output, "R[1] = 4"); got != tt.want || !strings.Contains(output, "R[1] = 7") {
+				t.Errorf("TranspileState.Run() = %s\nwant dead write kept = %v", output, tt.want)
+			}
+		})
+	}
+}
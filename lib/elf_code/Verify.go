@@ -0,0 +1,230 @@
+package elf_code
+
+import (
+	"context"
+	"fmt"
+)
+
+// A frame of execution within the transpiled program
+type executionFrame struct {
+	line *ProgramLine // The next line to execute, nil once the block is complete
+	loop *ProgramLine // If this frame is the body of a do while loop, the loop line
+}
+
+// Executes the compiled program blocks on the given registers, with the same meaning as the transpiled
+// output: jumps are calls which return once the target block completes, and the instruction pointer
+// register is never written. At most `maxInstructions` lines (statements, jumps, ifs and loop checks)
+// are executed (if greater than zero), after which an *ExecutionStoppedError is returned
+func (t *TranspileState) Execute(registers Registers, maxInstructions int) (executed int, err error) {
+	if err = t.Compile(); err != nil {
+		return
+	}
+
+	if len(registers) != len(t.Registers) {
+		return 0, fmt.Errorf("expected %d registers, got %d", len(t.Registers), len(registers))
+	}
+
+	ipRegister := t.cpu.InstructionPointerRegister
+	stack := []executionFrame{{t.mainBlock.firstLine, nil}}
+
+	// Enters the given block, as the current frame may have no more lines to run we can drop
+	// it rather than returning to it, which keeps recursive blocks from growing the stack
+	enter := func(block *ProgramBlock, line *ProgramLine, loop *ProgramLine) error {
+		if block == nil {
+			return fmt.Errorf("error at %d: jump to a block which was optimised out", line.ip)
+		}
+
+		if top := stack[len(stack)-1]; top.line == nil && top.loop == nil {
+			stack = stack[:len(stack)-1]
+		}
+
+		stack = append(stack, executionFrame{block.firstLine, loop})
+		return nil
+	}
+
+	// Stops the program once the limit has been reached, before running the line at `ip`
+	overLimit := func(ip int) error {
+		if maxInstructions > 0 && executed >= maxInstructions {
+			registers[ipRegister] = ip
+			return &ExecutionStoppedError{ErrInstructionLimit, ip, registers.Copy(), executed}
+		}
+
+		executed++
+		return nil
+	}
+
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+
+		if top.line == nil {
+			if top.loop == nil || registers[top.loop.instruction.A] != top.loop.loopConditionValue() {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+
+			// Repeat the loop body while the condition still holds
+			if err = overLimit(top.loop.ip); err != nil {
+				return
+			}
+			if top.loop.jumpToBlock == nil {
+				return executed, fmt.Errorf("error at %d: loop body was optimised out", top.loop.ip)
+			}
+			top.line = top.loop.jumpToBlock.firstLine
+			continue
+		}
+
+		line := top.line
+		top.line = line.next
+
+		if err = overLimit(line.ip); err != nil {
+			return
+		}
+
+		switch line.lineType {
+		case Statement:
+			instruction := line.instruction
+			value, e := OpCodeFunc[instruction.OpCode](instruction.A, instruction.B, registers)
+			if e != nil {
+				return executed, fmt.Errorf("error at %d: %v", line.ip, e)
+			}
+
+			if e = registers.Set(instruction.C, value); e != nil {
+				return executed, fmt.Errorf("error at %d: %v", line.ip, e)
+			}
+
+		case JumpStatement:
+			if line.jumpToBlock != nil {
+				err = enter(line.jumpToBlock, line, nil)
+			}
+
+		case IfStatement:
+			if line.jumpToBlock != nil {
+				if registers[line.instruction.A] == 1 {
+					err = enter(line.jumpToBlock, line, nil)
+				} else if line.elseBlock != nil {
+					err = enter(line.elseBlock, line, nil)
+				}
+			} else if line.elseBlock != nil && registers[line.instruction.A] == 0 {
+				err = enter(line.elseBlock, line, nil)
+			}
+
+		case DoWhileStatement:
+			err = enter(line.jumpToBlock, line, line)
+
+		case IdiomStatement:
+			line.idiom.Apply(registers)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// The value the condition register must hold for a do while loop to repeat
+func (pl *ProgramLine) loopConditionValue() int {
+	if pl.invertCondition {
+		return 0
+	}
+
+	return 1
+}
+
+// Options for checking a transpiled program behaves the same as the interpreter
+type VerifyOptions struct {
+	Transpile        TranspileOptions // The transpiler options being checked
+	Inputs           []Registers      // The starting registers to check with
+	UnknownRegisters []int            // Registers which the transpiler should treat as unknown integers
+	BooleanRegisters []int            // Registers which the transpiler should treat as unknown booleans
+	Observe          []int            // The registers compared once both halt (all but the IP register if empty)
+	MaxInstructions  int              // The instruction budget for each run, runs over budget are skipped
+}
+
+// The first point where the transpiled program didn't match the interpreter
+type Divergence struct {
+	Input    Registers // The starting registers
+	Register int       // The first observed register which was different
+	Expected Registers // The registers from the interpreter
+	Actual   Registers // The registers from the transpiled program
+}
+
+func (d *Divergence) Error() string {
+	return fmt.Sprintf("transpiled program diverged on R[%d] with input %s: interpreter %s, transpiled %s", d.Register, d.Input, d.Expected, d.Actual)
+}
+
+// The outcome of verifying a program
+type VerifyResult struct {
+	Checked    int         // The number of inputs where both versions halted and matched
+	Skipped    int         // The number of inputs where either version ran out of instructions
+	Divergence *Divergence // The first divergence found, or nil if there wasn't one
+}
+
+// Runs the program on the interpreter and transpiled with the given options for every input, stopping
+// at the first input where the observed registers differ. Each input is transpiled separately, so registers
+// not marked as unknown are treated as constants by the transpiler
+func Verify(program Program, ipRegister int, numRegisters int, options VerifyOptions) (result VerifyResult, err error) {
+	observe := options.Observe
+	if len(observe) == 0 {
+		for register := 0; register < numRegisters; register++ {
+			if register != ipRegister {
+				observe = append(observe, register)
+			}
+		}
+	}
+
+	for _, input := range options.Inputs {
+		if len(input) != numRegisters {
+			return result, fmt.Errorf("input %s does not have %d registers", input, numRegisters)
+		}
+
+		// Run the original program
+		cpu := NewCPU(program, ipRegister, numRegisters)
+		copy(cpu.Registers, input)
+		err = cpu.ExecuteWithLimits(context.Background(), options.MaxInstructions)
+		if _, stopped := err.(*ExecutionStoppedError); stopped {
+			result.Skipped++
+			err = nil
+			continue
+		} else if err != nil {
+			return result, fmt.Errorf("interpreter failed on input %s: %v", input, err)
+		}
+
+		// Then the transpiled version, which needs its own copy of the program as transpiling rewrites it
+		transpileCPU := NewCPU(append(Program{}, program...), ipRegister, numRegisters)
+		copy(transpileCPU.Registers, input)
+		transpiler := transpileCPU.StartTranspiler(options.Transpile)
+		for _, register := range options.UnknownRegisters {
+			transpiler.Registers[register].SetUnknownInt()
+		}
+		for _, register := range options.BooleanRegisters {
+			transpiler.Registers[register].SetUnknownBool()
+		}
+
+		if err = transpiler.Compile(); err != nil {
+			return result, fmt.Errorf("unable to transpile with input %s: %v", input, err)
+		}
+
+		actual := input.Copy()
+		_, err = transpiler.Execute(actual, options.MaxInstructions)
+		if _, stopped := err.(*ExecutionStoppedError); stopped {
+			result.Skipped++
+			err = nil
+			continue
+		} else if err != nil {
+			return result, fmt.Errorf("transpiled program failed on input %s: %v", input, err)
+		}
+
+		for _, register := range observe {
+			if cpu.Registers[register] != actual[register] {
+				result.Divergence = &Divergence{input.Copy(), register, cpu.Registers, actual}
+				return
+			}
+		}
+
+		result.Checked++
+	}
+
+	return
+}
@@ -0,0 +1,242 @@
+package elf_code
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Day 19 with a much smaller number to factorise, so it can be run to completion for both parts
+var smallDay19Program = strings.NewReplacer("muli 2 11 2", "muli 2 1 2", "muli 4 14 4", "muli 4 0 4").Replace(day19Program)
+
+// Each optimisation pass on its own, and then all of them together
+func verifyTranspileOptions() map[string]TranspileOptions {
+	return map[string]TranspileOptions{
+		"None":                       {},
+		"CompressConstants":          {CompressConstants: true},
+		"RemoveEmptyBlocks":          {RemoveEmptyBlocks: true},
+		"RemoveExtraJumps":           {RemoveExtraJumps: true},
+		"RemoveUnUsedRegisterWrites": {RemoveUnUsedRegisterWrites: true},
+		"CompressAndRemoveWrites":    {CompressConstants: true, RemoveUnUsedRegisterWrites: true},
+		"RewriteRecursionAsLoops":    {RewriteRecursionAsLoops: true},
+		"InlineBlocksWherePossible":  {InlineBlocksWherePossible: true},
+		"All":                        allTranspileOptions(),
+	}
+}
+
+// Random starting registers, with the part selection register holding 0 or 1
+func randomInputs(seed int64, count int, numRegisters int, ipRegister int, boolRegister int) []Registers {
+	random := rand.New(rand.NewSource(seed))
+	inputs := make([]Registers, count)
+
+	for i := range inputs {
+		inputs[i] = NewRegisters(numRegisters)
+		for register := range inputs[i] {
+			switch register {
+			case ipRegister:
+				inputs[i][register] = 0
+			case boolRegister:
+				inputs[i][register] = random.Intn(2)
+			default:
+				inputs[i][register] = random.Intn(4)
+			}
+		}
+	}
+
+	return inputs
+}
+
+// A loop which is only entered from the else branch of an if, where both the if and the loop exit the
+// program when their condition holds, so removing those exits leaves nothing for the loop to jump to
+const optimisedOutLoopExitProgram = `#ip 5
+gtri 0 5 1
+addr 1 5 5
+seti 3 0 5
+seti 99 0 5
+addi 0 1 0
+gtri 0 9 2
+addr 2 5 5
+seti 3 0 5`
+
+// A random program, which writes to the instruction pointer register for about a third of its instructions
+func randomProgram(random *rand.Rand, length int, numRegisters int) (program Program, ipRegister int) {
+	ipRegister = random.Intn(numRegisters)
+	program = make(Program, length)
+
+	for i := range program {
+		opCode := OpCode(random.Intn(int(NumOpCodes)))
+		isImmediate := OpCodeInputType[opCode]
+
+		input := func(immediate bool) int {
+			if immediate {
+				return random.Intn(16) - 1
+			}
+			return random.Intn(numRegisters)
+		}
+
+		output := random.Intn(numRegisters)
+		if random.Intn(3) == 0 {
+			output = ipRegister
+		}
+
+		program[i] = Instruction{opCode, input(isImmediate.A), input(isImmediate.B), output}
+	}
+
+	return
+}
+
+func TestVerify(t *testing.T) {
+	day21, err := NewCPUFromProgramFile(day21Program)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	// Find the first value of R[0] which halts day 21, which is the value R[4] is compared to
+	debugger := NewDebugger(day21)
+	debugger.SetBreakpoint(28)
+	if reason, err := debugger.Continue(); err != nil || reason != BreakpointHit {
+		t.Fatalf("Debugger.Continue() = %v, %v, want %v", reason, err, BreakpointHit)
+	}
+	day21Halt := day21.Registers[4]
+
+	tests := []struct {
+		name    string
+		program string
+		options VerifyOptions
+	}{
+		{"Day 19", smallDay19Program, VerifyOptions{
+			Inputs:           randomInputs(19, 20, 6, 3, 0),
+			UnknownRegisters: []int{1, 2, 4, 5},
+			BooleanRegisters: []int{0},
+			Observe:          []int{0},
+			MaxInstructions:  1000000,
+		}},
+		{"Loop", loopTestProgram, VerifyOptions{
+			Inputs:          randomInputs(3, 20, 6, 3, -1),
+			MaxInstructions: 1000,
+		}},
+		{"Day 21", day21Program, VerifyOptions{
+			Inputs:           []Registers{{day21Halt, 0, 0, 0, 0, 0}},
+			UnknownRegisters: []int{0},
+			Observe:          []int{0},
+			MaxInstructions:  100000,
+		}},
+		{"Greater than register", "#ip 1\ngtir 6 3 3", VerifyOptions{
+			Inputs:           randomInputs(6, 5, 6, 1, -1),
+			UnknownRegisters: []int{3},
+			MaxInstructions:  10,
+		}},
+		{"Reads the IP", "#ip 1\ngtrr 1 1 3\nbani 2 1 2\nborr 0 1 2\ngtri 1 -1 0", VerifyOptions{
+			Inputs:          randomInputs(1, 5, 6, 1, -1),
+			MaxInstructions: 10,
+		}},
+		{"Optimised out loop exit", optimisedOutLoopExitProgram, VerifyOptions{
+			Inputs:           randomInputs(9, 5, 6, 5, -1),
+			UnknownRegisters: []int{0, 1, 2, 3, 4},
+			MaxInstructions:  1000,
+		}},
+	}
+	for _, tt := range tests {
+		cpu, err := NewCPUFromProgramFile(tt.program)
+		if err != nil {
+			t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+		}
+
+		for optionsName, transpileOptions := range verifyTranspileOptions() {
+			t.Run(tt.name+"/"+optionsName, func(t *testing.T) {
+				options := tt.options
+				options.Transpile = transpileOptions
+
+				result, err := Verify(cpu.Program, cpu.InstructionPointerRegister, len(cpu.Registers), options)
+				if err != nil {
+					t.Fatalf("Verify() error = %v", err)
+				}
+
+				if result.Divergence != nil {
+					t.Errorf("Verify() divergence = %v", result.Divergence)
+				}
+
+				if result.Checked == 0 {
+					t.Errorf("Verify() checked = 0, skipped = %d, want inputs to be checked", result.Skipped)
+				}
+			})
+		}
+	}
+}
+
+func TestVerify_Divergence(t *testing.T) {
+	// R[0] is treated as a boolean by the transpiler, so holding 2 makes the jump skip further than it assumes
+	cpu, err := NewCPUFromProgramFile("#ip 1\naddr 0 1 1\naddi 2 1 2\naddi 2 10 2")
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	result, err := Verify(cpu.Program, cpu.InstructionPointerRegister, len(cpu.Registers), VerifyOptions{
+		Inputs:           []Registers{{0, 0, 0, 0, 0, 0}, {1, 0, 0, 0, 0, 0}, {2, 0, 0, 0, 0, 0}},
+		BooleanRegisters: []int{0},
+		MaxInstructions:  1000,
+	})
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	want := &Divergence{Registers{2, 0, 0, 0, 0, 0}, 2, Registers{2, 2, 0, 0, 0, 0}, Registers{2, 0, 11, 0, 0, 0}}
+	if result.Checked != 2 || !reflect.DeepEqual(result.Divergence, want) {
+		t.Errorf("Verify() = %+v, %v, want 2 checked and %v", result, result.Divergence, want)
+	}
+
+	// An instruction budget which is too small skips the input
+	cpu, err = NewCPUFromProgramFile(loopTestProgram)
+	if err != nil {
+		t.Fatalf("NewCPUFromProgramFile() error = %v", err)
+	}
+
+	result, err = Verify(cpu.Program, cpu.InstructionPointerRegister, len(cpu.Registers), VerifyOptions{
+		Inputs:          []Registers{{0, 0, 0, 0, 0, 0}},
+		MaxInstructions: 5,
+	})
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	if result.Skipped != 1 || result.Checked != 0 {
+		t.Errorf("Verify() = %+v, want 1 skipped", result)
+	}
+}
+
+func TestVerify_GeneratedPrograms(t *testing.T) {
+	random := rand.New(rand.NewSource(2018))
+
+	for optionsName, transpileOptions := range verifyTranspileOptions() {
+		t.Run(optionsName, func(t *testing.T) {
+			for i := 0; i < 500; i++ {
+				program, ipRegister := randomProgram(random, 13, 6)
+
+				// Every register is unknown, as constant inputs would only be correct for the input transpiled with
+				unknownRegisters := make([]int, 0)
+				for register := 0; register < 6; register++ {
+					if register != ipRegister {
+						unknownRegisters = append(unknownRegisters, register)
+					}
+				}
+
+				result, err := Verify(program, ipRegister, 6, VerifyOptions{
+					Transpile:        transpileOptions,
+					Inputs:           randomInputs(random.Int63(), 2, 6, ipRegister, -1),
+					UnknownRegisters: unknownRegisters,
+					MaxInstructions:  10000,
+				})
+
+				// Programs with jumps the transpiler can't follow are refused rather than transpiled wrongly
+				if err != nil && !strings.HasPrefix(err.Error(), "unable to transpile") {
+					t.Fatalf("Verify() error = %v\n#ip %d\n%v", err, ipRegister, program)
+				}
+
+				if result.Divergence != nil {
+					t.Fatalf("Verify() divergence = %v\n#ip %d\n%v", result.Divergence, ipRegister, program)
+				}
+			}
+		})
+	}
+}